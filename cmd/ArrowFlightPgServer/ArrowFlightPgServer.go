@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
 	"github.com/labstack/echo-contrib/echoprometheus"
 	"github.com/labstack/echo/v4"
 	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetquery"
 	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/config"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/database"
@@ -14,8 +18,12 @@ import (
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/tools"
 	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -33,6 +41,14 @@ const (
 	MIMEHtml               = "text/html"
 	MIMEHtmlCharsetUTF8    = MIMEHtml + "; " + charsetUTF8
 	MIMEAppJSONCharsetUTF8 = MIMEAppJSON + "; " + charsetUTF8
+	MIMEArrowStream        = "application/vnd.apache.arrow.stream"
+	// defaultQueryMaxRows is the maximum number of rows returned by the parquet queries
+	defaultQueryMaxRows = 1_000_000
+	// defaultQueryThreads, defaultQueryMemoryLimit and defaultQueryTimeout limit each parquet query,
+	// PARQUET_QUERY_THREADS, PARQUET_QUERY_MEMORY_LIMIT and PARQUET_QUERY_TIMEOUT change them
+	defaultQueryThreads     = 2
+	defaultQueryMemoryLimit = "1GB"
+	defaultQueryTimeout     = time.Minute
 )
 
 // content holds our static web server content.
@@ -45,10 +61,22 @@ type UserLogin struct {
 	Username     string `json:"username"`
 }
 
+// ParquetQuery is the body of the parquet query requests, the files are relative to PARQUET_QUERY_DIR
+type ParquetQuery struct {
+	Sql     string   `json:"sql"`
+	Files   []string `json:"files"`
+	MaxRows int64    `json:"max_rows"`
+}
+
 type Service struct {
 	Logger golog.MyLogger
 	dbConn database.DB
 	server *goHttpEcho.Server
+	// parquetDir is the directory of the parquet files available to the queries, they are disabled when empty
+	parquetDir string
+	// parquetLimits are the threads and the memory of DuckDB for each query, parquetTimeout its maximum duration
+	parquetLimits  parquetquery.Options
+	parquetTimeout time.Duration
 }
 
 // login is just a trivial example to test this server
@@ -108,6 +136,78 @@ func (s Service) GetStatus(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, claims)
 }
 
+// queryParquet runs the SQL of the request over parquet files of parquetDir with DuckDB, the view parquet reads all
+// the files given or all the files of parquetDir, the rows are returned as an Arrow IPC stream
+func (s Service) queryParquet(ctx echo.Context) error {
+	s.Logger.TraceHttpRequest("queryParquet", ctx.Request())
+	if s.parquetDir == "" {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "parquet queries are disabled, PARQUET_QUERY_DIR is not set")
+	}
+	query := new(ParquetQuery)
+	if err := ctx.Bind(query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid json format in request body")
+	}
+	if strings.TrimSpace(query.Sql) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "sql is required")
+	}
+	if query.MaxRows <= 0 || query.MaxRows > defaultQueryMaxRows {
+		query.MaxRows = defaultQueryMaxRows
+	}
+	locations := []string{s.parquetDir}
+	if len(query.Files) > 0 {
+		locations = locations[:0]
+		for _, file := range query.Files {
+			if !filepath.IsLocal(file) {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid file %s, expected a path inside the parquet directory", file))
+			}
+			locations = append(locations, filepath.Join(s.parquetDir, file))
+		}
+	}
+	files, err := parquetquery.ResolveFiles(locations)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid files: %v", err))
+	}
+	// the query and the streaming of its rows are stopped after parquetTimeout
+	queryCtx, cancel := context.WithTimeout(ctx.Request().Context(), s.parquetTimeout)
+	defer cancel()
+	options := s.parquetLimits
+	options.MaxRows = query.MaxRows
+	result, err := parquetquery.Query(queryCtx, files, query.Sql, options, s.Logger)
+	if err != nil {
+		switch {
+		case errors.Is(err, parquetquery.ErrUnavailable):
+			return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+		case errors.Is(queryCtx.Err(), context.DeadlineExceeded):
+			return echo.NewHTTPError(http.StatusGatewayTimeout, fmt.Sprintf("parquet query canceled after %s", s.parquetTimeout))
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	defer result.Close()
+	// the status is sent with the first bytes, an error while streaming the rows can only be logged
+	ctx.Response().Header().Set(echo.HeaderContentType, MIMEArrowStream)
+	ctx.Response().WriteHeader(http.StatusOK)
+	w := ipc.NewWriter(ctx.Response(), ipc.WithSchema(result.Schema()))
+	for result.Next() {
+		if err := queryCtx.Err(); err != nil {
+			s.Logger.Error("queryParquet stopped after %d rows: %v", result.Rows(), err)
+			return nil
+		}
+		if err := w.Write(result.Record()); err != nil {
+			s.Logger.Error("queryParquet failed to write the rows: %v", err)
+			return nil
+		}
+	}
+	if err := result.Err(); err != nil {
+		s.Logger.Error("queryParquet failed to read the rows: %v", err)
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		s.Logger.Error("queryParquet failed to write the end of the stream: %v", err)
+	}
+	s.Logger.Info("queryParquet returned %d rows of %d parquet files", result.Rows(), len(files))
+	return nil
+}
+
 func (s Service) IsDBAlive() bool {
 	dbVer, err := s.dbConn.GetVersion()
 	if err != nil {
@@ -135,6 +235,31 @@ func checkHealthy(string) bool {
 	return true
 }
 
+// getParquetQueryLimitsFromEnv returns the threads, the memory limit and the timeout of the parquet queries,
+// from PARQUET_QUERY_THREADS, PARQUET_QUERY_MEMORY_LIMIT (like 512MB or 4GB) and PARQUET_QUERY_TIMEOUT (like 30s)
+func getParquetQueryLimitsFromEnv() (parquetquery.Options, time.Duration, error) {
+	limits := parquetquery.Options{Threads: defaultQueryThreads, MemoryLimit: defaultQueryMemoryLimit}
+	timeout := defaultQueryTimeout
+	if value := os.Getenv("PARQUET_QUERY_THREADS"); value != "" {
+		threads, err := strconv.Atoi(value)
+		if err != nil || threads < 1 {
+			return limits, 0, fmt.Errorf("invalid PARQUET_QUERY_THREADS %s, expected a number of threads", value)
+		}
+		limits.Threads = threads
+	}
+	if value := os.Getenv("PARQUET_QUERY_MEMORY_LIMIT"); value != "" {
+		limits.MemoryLimit = value
+	}
+	if value := os.Getenv("PARQUET_QUERY_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return limits, 0, fmt.Errorf("invalid PARQUET_QUERY_TIMEOUT %s, expected a duration like 30s", value)
+		}
+		timeout = d
+	}
+	return limits, timeout, nil
+}
+
 func main() {
 	l, err := golog.NewLogger("zap", golog.TraceLevel, version.APP)
 	if err != nil {
@@ -217,10 +342,17 @@ func main() {
 	e.Use(echoprometheus.NewMiddlewareWithConfig(mwConfig)) // adds middleware to gather metrics
 	// end prometheus stuff to create a custom counter metric
 
+	parquetLimits, parquetTimeout, err := getParquetQueryLimitsFromEnv()
+	if err != nil {
+		l.Fatal("💥💥 error reading the parquet query limits: %v", err)
+	}
 	yourService := Service{
-		Logger: l,
-		dbConn: dbInstance,
-		server: server,
+		Logger:         l,
+		dbConn:         dbInstance,
+		server:         server,
+		parquetDir:     os.Getenv("PARQUET_QUERY_DIR"),
+		parquetLimits:  parquetLimits,
+		parquetTimeout: parquetTimeout,
 	}
 
 	e.GET("/metrics", echoprometheus.NewHandler()) // adds route to serve gathered metrics
@@ -230,6 +362,7 @@ func main() {
 	e.POST("/login", yourService.login)
 	r := server.GetRestrictedGroup()
 	r.GET("/status", yourService.GetStatus)
+	r.POST("/parquet/query", yourService.queryParquet)
 
 	dbStore := db.GetStorageInstanceOrPanic("pgx", dbInstance, l)
 
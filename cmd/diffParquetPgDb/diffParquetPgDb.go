@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquet2db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/config"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/tools"
+)
+
+const (
+	APP                   = "diffParquetPgDb"
+	defaultDBPort         = 5432
+	defaultDBIp           = "127.0.0.1"
+	defaultDBSslMode      = "prefer"
+	defaultMaxDifferences = 100
+	// exitDifferences is the exit status when the file and the table differ, errors exit with 1
+	exitDifferences = 3
+)
+
+func main() {
+	l, err := golog.NewLogger("zap", golog.TraceLevel, APP)
+	if err != nil {
+		panic(fmt.Sprintf("💥💥 error log.NewLogger error: %v'\n", err))
+	}
+	// the differences are written to stdout, the log messages go to stderr
+	if stdLogger, err := l.GetDefaultLogger(); err == nil {
+		stdLogger.SetOutput(os.Stderr)
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
+
+	key := flag.String("key", "", "comma separated list of the key columns matching the rows (default: primary key of the table)")
+	columns := flag.String("columns", "", "comma separated list of the compared columns (default: every column of the file present in the table)")
+	maxDifferences := flag.Int64("max-differences", defaultMaxDifferences, "maximum number of differences printed, the others are only counted (0 prints all)")
+	output := flag.String("output", "text", "format of the differences: text or json (one object per line)")
+	sortRunRows := flag.Int("sort-run-rows", 0, "number of rows of the file sorted in memory, larger files are sorted in runs spilled to --temp-dir (default 500000)")
+	tempDir := flag.String("temp-dir", "", "directory of the sorted runs of large files (default: the temporary directory)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 3 {
+		l.Fatal("💥💥 error expected arguments: schema table parquet_file_path")
+	}
+	schemaName, tableName, parquetFilePath := args[0], args[1], args[2]
+	if *output != "text" && *output != "json" {
+		l.Fatal("💥💥 error invalid --output %s, expected text or json", *output)
+	}
+	l.Info("comparing parquet file %s with table %s.%s", parquetFilePath, schemaName, tableName)
+
+	var printed int64
+	encoder := json.NewEncoder(os.Stdout)
+	options := parquet2db.DiffOptions{
+		KeyColumns:  splitColumns(*key),
+		Columns:     splitColumns(*columns),
+		SortRunRows: *sortRunRows,
+		TempDir:     *tempDir,
+		Report: func(difference parquet2db.RowDifference) error {
+			if *maxDifferences > 0 && printed >= *maxDifferences {
+				return nil
+			}
+			printed++
+			if *output == "json" {
+				return encoder.Encode(difference)
+			}
+			fmt.Println(formatDifference(difference))
+			return nil
+		},
+	}
+
+	dbDsn := config.GetPgDbDsnUrlFromEnvOrPanic(defaultDBIp, defaultDBPort, tools.ToSnakeCase(version.APP), version.AppSnake, defaultDBSslMode)
+	dbInstance, err := database.GetInstance("pgx", dbDsn, runtime.NumCPU(), l)
+	if err != nil {
+		l.Fatal("💥💥 error doing database.GetInstance(pgx ...) error: %v", err)
+	}
+	defer dbInstance.Close()
+
+	dbVersion, err := dbInstance.GetVersion()
+	if err != nil {
+		l.Fatal("💥💥 error doing dbConn.GetVersion() error: %v", err)
+	}
+	l.Info("connected to db version : %s", dbVersion)
+
+	dbStore := db.GetStorageInstanceOrPanic("pgx", dbInstance, l)
+	tableColumns, err := dbStore.GetTableSchema(schemaName, tableName)
+	if err != nil {
+		l.Fatal("💥💥 error doing dbStore.GetTableSchema() : %v", err)
+	}
+	if len(tableColumns) == 0 {
+		l.Fatal("💥💥 error no columns found for table %s.%s", schemaName, tableName)
+	}
+	pgxPool, err := dbInstance.GetPGConn()
+	if err != nil {
+		l.Fatal("💥💥 error doing dbInstance.GetPGConn() : %v", err)
+	}
+	// SIGINT stops the comparison, the sorted runs of the file are removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := parquet2db.DiffParquetFile(ctx, pgxPool, parquetFilePath, schemaName, tableName, tableColumns, options, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing parquet2db.DiffParquetFile() : %v", err)
+	}
+	l.Info("compared %d rows of table %s.%s with %d rows of %s on key %s",
+		result.TableRows, schemaName, tableName, result.FileRows, parquetFilePath, strings.Join(result.KeyColumns, ", "))
+	if result.Identical() {
+		l.Info("🚀🚀 No difference found in the %d compared columns", len(result.Columns))
+		return
+	}
+	l.Warn("differences found: %d rows missing from the file, %d extra rows in the file, %d changed rows",
+		result.Missing, result.Extra, result.Changed)
+	if total := result.Missing + result.Extra + result.Changed; total > printed {
+		l.Warn("only %d of the %d differences were printed, see --max-differences", printed, total)
+	}
+	dbInstance.Close()
+	os.Exit(exitDifferences)
+}
+
+// formatDifference returns the text line of a difference
+func formatDifference(difference parquet2db.RowDifference) string {
+	switch difference.Kind {
+	case parquet2db.DiffMissing:
+		return fmt.Sprintf("- %s: missing from the file", difference.Key)
+	case parquet2db.DiffExtra:
+		return fmt.Sprintf("+ %s: absent from the table", difference.Key)
+	default:
+		cells := make([]string, len(difference.Cells))
+		for i, cell := range difference.Cells {
+			cells[i] = fmt.Sprintf("%s: table %s, file %s", cell.Column, cell.TableValue, cell.FileValue)
+		}
+		return fmt.Sprintf("~ %s: %s", difference.Key, strings.Join(cells, "; "))
+	}
+}
+
+// splitColumns returns the trimmed column names of a comma separated list
+func splitColumns(list string) []string {
+	if list == "" {
+		return nil
+	}
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	return columns
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/labstack/gommon/log"
 	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
 	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2parquet"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
 	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/config"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/database"
@@ -17,38 +22,156 @@ import (
 )
 
 const (
-	APP              = "getParquetFromPgDb"
-	defaultDBPort    = 5432
-	defaultDBIp      = "127.0.0.1"
-	defaultDBSslMode = "prefer"
+	APP               = "getParquetFromPgDb"
+	defaultDBPort     = 5432
+	defaultDBIp       = "127.0.0.1"
+	defaultDBSslMode  = "prefer"
+	defaultBatchSize  = 100
+	defaultWorkers    = 2
+	defaultConverters = 2
+	// exitCanceled is the usual exit status of a program stopped by SIGINT
+	exitCanceled = 130
 )
 
 func main() {
-	l, err := golog.NewLogger("zap", golog.TraceLevel, APP)
+	partitionBy := flag.String("partition-by", "", "comma separated list of columns used to write a hive style partitioned dataset in the output directory")
+	maxRowsPerFile := flag.Int64("max-rows-per-file", 0, "maximum number of rows in each parquet file of the dataset (0 means no limit)")
+	maxBytesPerFile := flag.Int64("max-bytes-per-file", 0, "approximate maximum size in bytes of each parquet file of the dataset (0 means no limit)")
+	writeMetadata := flag.Bool("write-metadata", false, "write the _common_metadata and _metadata summary files in the dataset directory")
+	incrementalColumn := flag.String("incremental-column", "", "monotonic column (updated_at, serial id) used to export only the rows newer than the last export in a new part file of the output directory")
+	incrementalXmin := flag.Bool("incremental-xmin", false, "use the transaction id (xmin) of the rows to export only the rows inserted or updated since the last export")
+	stateFile := flag.String("state-file", "", "json file keeping the watermark of the incremental export (default: _incremental_state.json in the output directory)")
+	tables := flag.String("tables", "", "comma separated list of tables or glob patterns ('*' for the whole schema) exported under one snapshot, the arguments are then: schema output_directory")
+	workers := flag.Int("workers", defaultWorkers, "number of tables exported in parallel with --tables")
+	format := flag.String("format", string(db2parquet.FormatParquet), "output format: parquet, arrow (Arrow IPC file, Feather v2), arrow-stream (Arrow IPC stream), csv or ndjson")
+	compression := flag.String("compression", string(db2parquet.CompressionNone), "compression codec of the parquet pages or of the Arrow IPC buffers: none, lz4 or zstd")
+	csvDelimiter := flag.String("csv-delimiter", ",", "field delimiter of the csv format")
+	csvHeader := flag.Bool("csv-header", true, "write the column names in the first line of the csv format")
+	nullString := flag.String("null-string", "", "string written for the NULL values in the csv format")
+	memoryBudgetMb := flag.Int64("memory-budget-mb", 0, "approximate memory used by the export in MiB, the fetch size is adapted to the row width (0 keeps the fixed batch size)")
+	converters := flag.Int("converters", defaultConverters, "number of goroutines converting the fetched rows to Arrow while the next batch is fetched and the previous one written")
+	verify := flag.Bool("verify", false, "after the export compare the row count and the checksums of every column of the parquet file with the table, in the same snapshot")
+	showProgress := flag.Bool("progress", true, "show a progress bar on stderr, or log the progress every 30s when stderr is not a terminal")
+	timestampLayout := flag.String("timestamp-layout", "", "Go time layout of the timestamps in the csv and ndjson formats (default 2006-01-02 15:04:05.999999999)")
+	footerKey := flag.String("encryption-footer-key", "", "encrypt the parquet files with this footer key: env:NAME or file:path, holding 16, 24 or 32 bytes raw or in hex or base64")
+	columnKeys := flag.String("encryption-column-keys", "", "comma separated list of column:keyspec encrypting these columns with their own key, the other columns stay in clear")
+	plaintextFooter := flag.Bool("plaintext-footer", false, "keep the footer of the encrypted parquet files readable without the keys, it is only signed with the footer key")
+	verbose := flag.Bool("verbose", false, "log the debug messages, like each batch fetched from the cursor")
+	flag.Parse()
+	args := flag.Args()
+
+	logLevel := golog.InfoLevel
+	if *verbose {
+		logLevel = golog.TraceLevel
+	}
+	l, err := golog.NewLogger("zap", logLevel, APP)
 	if err != nil {
 		panic(fmt.Sprintf("💥💥 error log.NewLogger error: %v'\n", err))
 	}
+	// with - as output path the data is written to stdout, the log messages must go to stderr from the first one
+	outputArgIndex := 2
+	if *tables != "" {
+		outputArgIndex = 1
+	}
+	if len(args) > outputArgIndex && args[outputArgIndex] == db2parquet.StdoutPath {
+		if stdLogger, err := l.GetDefaultLogger(); err == nil {
+			stdLogger.SetOutput(os.Stderr)
+		}
+	}
 	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
 
 	// read argument schema from command line
-	if len(os.Args) < 2 {
+	if len(args) < 1 {
 		l.Fatal("💥💥 error missing argument schema name")
 	}
-	schemaName := os.Args[1]
+	schemaName := args[0]
 	l.Info("using schema name : %s", schemaName)
-	// read argument table from command line
-	if len(os.Args) < 3 {
-		l.Fatal("💥💥 error missing argument table name")
+	isSchemaExport := *tables != ""
+	// read argument table from command line, a schema export has no table argument
+	tableName := ""
+	pathArgIndex := 1
+	if !isSchemaExport {
+		if len(args) < 2 {
+			l.Fatal("💥💥 error missing argument table name")
+		}
+		tableName = args[1]
+		l.Info("using table name : %s", tableName)
+		pathArgIndex = 2
 	}
-	tableName := os.Args[2]
-	l.Info("using table name : %s", tableName)
 
-	// get the parquet file path from the command line
-	if len(os.Args) < 4 {
+	// get the parquet file path (or the output directory) from the command line, s3://bucket/key is written to object storage
+	if len(args) <= pathArgIndex {
 		l.Fatal("💥💥 error missing argument parquet file path")
 	}
-	parquetFilePath := os.Args[3]
+	parquetFilePath := args[pathArgIndex]
 	l.Info("using parquet file path : %s", parquetFilePath)
+	delimiter := []rune(*csvDelimiter)
+	if len(delimiter) != 1 {
+		l.Fatal("💥💥 error --csv-delimiter must be a single character")
+	}
+	memoryOptions := db2parquet.MemoryOptions{Budget: *memoryBudgetMb * 1024 * 1024}
+	writerOptions := db2parquet.WriterOptions{Memory: memoryOptions, Converters: *converters, Verify: *verify, Csv: db2parquet.CsvOptions{
+		Delimiter:       delimiter[0],
+		Header:          *csvHeader,
+		NullString:      *nullString,
+		TimestampLayout: *timestampLayout,
+	}}
+	if writerOptions.Format, err = db2parquet.ParseFormat(*format); err != nil {
+		l.Fatal("💥💥 error %v", err)
+	}
+	if writerOptions.Compression, err = db2parquet.ParseCompression(*compression); err != nil {
+		l.Fatal("💥💥 error %v", err)
+	}
+	if *footerKey != "" {
+		encryption := &db2parquet.EncryptionOptions{PlaintextFooter: *plaintextFooter}
+		if encryption.FooterKey, err = db2parquet.LoadEncryptionKey(*footerKey); err != nil {
+			l.Fatal("💥💥 error loading --encryption-footer-key: %v", err)
+		}
+		if encryption.ColumnKeys, err = db2parquet.ParseColumnKeys(*columnKeys); err != nil {
+			l.Fatal("💥💥 error loading --encryption-column-keys: %v", err)
+		}
+		writerOptions.Encryption = encryption
+	} else if *columnKeys != "" || *plaintextFooter {
+		l.Fatal("💥💥 error --encryption-column-keys and --plaintext-footer need an --encryption-footer-key")
+	}
+	datasetOptions := db2parquet.DatasetOptions{
+		MaxRowsPerFile:     *maxRowsPerFile,
+		MaxBytesPerFile:    *maxBytesPerFile,
+		WriteMetadataFiles: *writeMetadata,
+		Memory:             memoryOptions,
+		Encryption:         writerOptions.Encryption,
+	}
+	if *partitionBy != "" {
+		for _, column := range strings.Split(*partitionBy, ",") {
+			datasetOptions.PartitionBy = append(datasetOptions.PartitionBy, strings.TrimSpace(column))
+		}
+	}
+	isDataset := len(datasetOptions.PartitionBy) > 0 || *maxRowsPerFile > 0 || *maxBytesPerFile > 0 || *writeMetadata
+	isIncremental := *incrementalColumn != "" || *incrementalXmin
+	if isDataset && isIncremental {
+		l.Fatal("💥💥 error incremental export cannot be combined with a partitioned dataset")
+	}
+	if isSchemaExport && (isDataset || isIncremental) {
+		l.Fatal("💥💥 error --tables cannot be combined with a partitioned dataset or an incremental export")
+	}
+	if (isDataset || isIncremental) && writerOptions.Format != db2parquet.FormatParquet {
+		l.Fatal("💥💥 error partitioned datasets and incremental exports are only written in the parquet format")
+	}
+	if writerOptions.Encryption != nil && writerOptions.Format != db2parquet.FormatParquet {
+		l.Fatal("💥💥 error encryption is only available for the parquet format")
+	}
+	if writerOptions.Encryption != nil && *writeMetadata {
+		l.Fatal("💥💥 error --write-metadata cannot be combined with encryption")
+	}
+	if *verify && (isDataset || isIncremental || writerOptions.Format != db2parquet.FormatParquet || parquetFilePath == db2parquet.StdoutPath) {
+		l.Fatal("💥💥 error --verify is only available for parquet files of a single table or of a schema export")
+	}
+	if parquetFilePath == db2parquet.StdoutPath && (isSchemaExport || isDataset || isIncremental) {
+		l.Fatal("💥💥 error only a single table export can be written to stdout")
+	}
+	if sink.IsS3(parquetFilePath) && isIncremental {
+		l.Fatal("💥💥 error incremental exports keep their state in a local directory and cannot be written to s3")
+	}
 
 	dbDsn := config.GetPgDbDsnUrlFromEnvOrPanic(defaultDBIp, defaultDBPort, tools.ToSnakeCase(version.APP), version.AppSnake, defaultDBSslMode)
 	dbInstance, err := database.GetInstance("pgx", dbDsn, runtime.NumCPU(), l)
@@ -64,6 +187,47 @@ func main() {
 	l.Info("connected to db version : %s", dbVersion)
 
 	dbStore := db.GetStorageInstanceOrPanic("pgx", dbInstance, l)
+	// SIGINT and SIGTERM cancel the export, the files it wrote are removed and the transaction rolled back
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// the progress is always collected for the final summary, it is only shown with --progress
+	progress := newProgressReporter(*showProgress, l)
+	writerOptions.Progress = progress.report
+	datasetOptions.Progress = progress.report
+	// exitOnError stops the program when the export failed, with a distinct message and status when it was canceled
+	exitOnError := func(function string, err error) {
+		if err == nil {
+			return
+		}
+		progress.finish()
+		if ctx.Err() != nil {
+			l.Warn("export canceled, the files written by this export in %s were removed: %v", parquetFilePath, err)
+			os.Exit(exitCanceled)
+		}
+		l.Fatal("💥💥 error doing %s : %v", function, err)
+	}
+	// printSummary logs the rows, the size and the rate of the whole export
+	printSummary := func() {
+		total := progress.finish()
+		l.Info("exported %d rows (%s) in %s, %.0f rows/s", total.Rows, formatBytes(total.Bytes), total.Elapsed.Round(time.Millisecond), total.Rate())
+	}
+	pgxPool, err := dbInstance.GetPGConn()
+	if err != nil {
+		l.Fatal("💥💥 error doing dbInstance.GetPGConn() : %v", err)
+	}
+
+	if isSchemaExport {
+		schemaOptions := db2parquet.SchemaExportOptions{Workers: *workers, Writer: writerOptions}
+		for _, table := range strings.Split(*tables, ",") {
+			schemaOptions.Tables = append(schemaOptions.Tables, strings.TrimSpace(table))
+		}
+		manifest, err := db2parquet.CreateParquetFilesFromDbSchema(ctx, pgxPool, dbStore, schemaName, parquetFilePath, defaultBatchSize, schemaOptions, l)
+		exitOnError("db2parquet.CreateParquetFilesFromDbSchema()", err)
+		printSummary()
+		l.Info("🚀🚀 Done exporting %d tables of schema %s in : %s at LSN %s", len(manifest.Tables), schemaName, parquetFilePath, manifest.SnapshotLSN)
+		return
+	}
+
 	// Step 1: Retrieve table schema
 	myTableColumns, err := dbStore.GetTableSchema(schemaName, tableName)
 	if err != nil {
@@ -72,18 +236,33 @@ func main() {
 	if len(myTableColumns) == 0 {
 		l.Fatal("💥💥 error no columns found for table %s.%s", schemaName, tableName)
 	}
-	log.Info("found %d columns for table %s.%s", len(myTableColumns), schemaName, tableName)
+	l.Info("found %d columns for table %s.%s", len(myTableColumns), schemaName, tableName)
 
-	ctx := context.Background()
-	pgxPool, err := dbInstance.GetPGConn()
-	if err != nil {
-		l.Fatal("💥💥 error doing dbInstance.GetPGConn() : %v", err)
+	if isIncremental {
+		incrementalOptions := db2parquet.IncrementalOptions{
+			WatermarkColumn: *incrementalColumn,
+			UseXmin:         *incrementalXmin,
+			StateFilePath:   *stateFile,
+			Memory:          memoryOptions,
+			Progress:        writerOptions.Progress,
+			Encryption:      writerOptions.Encryption,
+		}
+		state, err := db2parquet.CreateIncrementalParquetFileFromDbTable(ctx, pgxPool, schemaName, tableName, myTableColumns, parquetFilePath, defaultBatchSize, incrementalOptions, l)
+		exitOnError("db2parquet.CreateIncrementalParquetFileFromDbTable()", err)
+		printSummary()
+		l.Info("🚀🚀 Done incremental export in : %s, last file: %s, rows: %d", parquetFilePath, state.LastFile, state.LastRowCount)
+		return
 	}
-
-	err = db2parquet.CreateParquetFileFromDbTable(ctx, pgxPool, schemaName, tableName, myTableColumns, parquetFilePath, 100, l)
-	if err != nil {
-		l.Fatal("💥💥 error doing db2parquet.CreateParquetFileFromDbTable() : %v", err)
+	if isDataset {
+		err = db2parquet.CreateParquetDatasetFromDbTable(ctx, pgxPool, schemaName, tableName, myTableColumns, parquetFilePath, defaultBatchSize, datasetOptions, l)
+		exitOnError("db2parquet.CreateParquetDatasetFromDbTable()", err)
+		printSummary()
+		l.Info("🚀🚀 Done creating parquet dataset : %s", parquetFilePath)
+		return
 	}
-	l.Info("🚀🚀 Done creating parquet file : %s", parquetFilePath)
+	err = db2parquet.CreateFileFromDbTable(ctx, pgxPool, schemaName, tableName, myTableColumns, parquetFilePath, defaultBatchSize, writerOptions, l)
+	exitOnError("db2parquet.CreateFileFromDbTable()", err)
+	printSummary()
+	l.Info("🚀🚀 Done creating %s file : %s", writerOptions.Format, parquetFilePath)
 
 }
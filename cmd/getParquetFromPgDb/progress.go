@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2parquet"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	progressBarWidth = 30
+	// progressRefresh is the interval between two redraws of the progress bar on a terminal
+	progressRefresh = 200 * time.Millisecond
+	// progressLogInterval is the interval between two progress log lines when stderr is not a terminal
+	progressLogInterval = 30 * time.Second
+)
+
+// progressReporter collects the progress of the tables being exported. When shown, it is drawn on one line
+// of stderr, or logged from time to time when stderr is redirected to a file.
+type progressReporter struct {
+	mu         sync.Mutex
+	show       bool
+	out        io.Writer
+	isTerminal bool
+	start      time.Time
+	lastUpdate time.Time
+	tables     map[string]db2parquet.Progress
+	drawn      bool
+	log        golog.MyLogger
+}
+
+func newProgressReporter(show bool, log golog.MyLogger) *progressReporter {
+	isTerminal := false
+	if info, err := os.Stderr.Stat(); err == nil {
+		isTerminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progressReporter{
+		show:       show,
+		out:        os.Stderr,
+		isTerminal: isTerminal,
+		start:      time.Now(),
+		lastUpdate: time.Now(),
+		tables:     make(map[string]db2parquet.Progress),
+		log:        log,
+	}
+}
+
+// report is the db2parquet.ProgressFunc of the export, the tables of a schema export call it concurrently
+func (r *progressReporter) report(progress db2parquet.Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tables[progress.TableName] = progress
+	if !r.show {
+		return
+	}
+	interval := progressRefresh
+	if !r.isTerminal {
+		interval = progressLogInterval
+	}
+	if !progress.Done && time.Since(r.lastUpdate) < interval {
+		return
+	}
+	r.lastUpdate = time.Now()
+	total := r.total()
+	if r.isTerminal {
+		fmt.Fprintf(r.out, "\r\033[K%s", formatProgress(total))
+		r.drawn = true
+	} else if !progress.Done {
+		r.log.Info("progress: %s", formatProgress(total))
+	}
+}
+
+// finish ends the line of the progress bar and returns the sum of the progress of every table
+func (r *progressReporter) finish() db2parquet.Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.drawn {
+		fmt.Fprintln(r.out)
+		r.drawn = false
+	}
+	return r.total()
+}
+
+// total adds the progress of the tables, the elapsed time is the one of the whole export
+func (r *progressReporter) total() db2parquet.Progress {
+	total := db2parquet.Progress{Elapsed: time.Since(r.start), Done: len(r.tables) > 0}
+	estimated := true
+	for _, p := range r.tables {
+		total.Rows += p.Rows
+		total.Bytes += p.Bytes
+		total.EstimatedRows += p.EstimatedRows
+		estimated = estimated && p.EstimatedRows > 0
+		total.Done = total.Done && p.Done
+	}
+	if !estimated {
+		total.EstimatedRows = 0
+	}
+	return total
+}
+
+// formatProgress returns the progress bar with the rows, the size, the rate and the ETA when the rows are estimated
+func formatProgress(p db2parquet.Progress) string {
+	var sb strings.Builder
+	if fraction := p.Fraction(); fraction >= 0 {
+		filled := int(fraction * progressBarWidth)
+		fmt.Fprintf(&sb, "[%s%s] %5.1f%% ", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), fraction*100)
+		fmt.Fprintf(&sb, "%d/~%d rows", p.Rows, p.EstimatedRows)
+	} else {
+		fmt.Fprintf(&sb, "%d rows", p.Rows)
+	}
+	fmt.Fprintf(&sb, ", %s, %.0f rows/s, elapsed %s", formatBytes(p.Bytes), p.Rate(), p.Elapsed.Round(time.Second))
+	if eta, ok := p.ETA(); ok && !p.Done {
+		fmt.Fprintf(&sb, ", ETA %s", eta.Round(time.Second))
+	}
+	return sb.String()
+}
+
+// formatBytes returns the size with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
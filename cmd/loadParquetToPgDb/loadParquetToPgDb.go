@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquet2db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/config"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/tools"
+)
+
+const (
+	APP              = "loadParquetToPgDb"
+	defaultDBPort    = 5432
+	defaultDBIp      = "127.0.0.1"
+	defaultDBSslMode = "prefer"
+	defaultBatchSize = 1000
+)
+
+func main() {
+	l, err := golog.NewLogger("zap", golog.TraceLevel, APP)
+	if err != nil {
+		panic(fmt.Sprintf("💥💥 error log.NewLogger error: %v'\n", err))
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
+
+	mode := flag.String("mode", string(parquet2db.LoadModeCreate), "what to do with the target table: create, append, truncate or replace")
+	upsertKey := flag.String("upsert-key", "", "comma separated list of key columns, existing rows with the same key are updated instead of inserted")
+	batchSize := flag.Int("batch-size", defaultBatchSize, "number of rows sent by each COPY")
+	deleteMissing := flag.Bool("delete-missing", false, "with --upsert-key, delete the rows of the table whose key is absent from the file")
+	partitionColumns := flag.String("partition-columns", "", "comma separated list of columns restricting --delete-missing to the partitions present in the file")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 3 {
+		l.Fatal("💥💥 error expected arguments: schema table parquet_file_path")
+	}
+	schemaName, tableName, parquetFilePath := args[0], args[1], args[2]
+	l.Info("loading parquet file %s in table %s.%s", parquetFilePath, schemaName, tableName)
+
+	loadMode, err := parquet2db.ParseLoadMode(*mode)
+	if err != nil {
+		l.Fatal("💥💥 error %v", err)
+	}
+	options := parquet2db.LoadOptions{
+		Mode:             loadMode,
+		BatchSize:        *batchSize,
+		UpsertKey:        splitColumns(*upsertKey),
+		DeleteMissing:    *deleteMissing,
+		PartitionColumns: splitColumns(*partitionColumns),
+	}
+
+	dbDsn := config.GetPgDbDsnUrlFromEnvOrPanic(defaultDBIp, defaultDBPort, tools.ToSnakeCase(version.APP), version.AppSnake, defaultDBSslMode)
+	dbInstance, err := database.GetInstance("pgx", dbDsn, runtime.NumCPU(), l)
+	if err != nil {
+		l.Fatal("💥💥 error doing database.GetInstance(pgx ...) error: %v", err)
+	}
+	defer dbInstance.Close()
+
+	dbVersion, err := dbInstance.GetVersion()
+	if err != nil {
+		l.Fatal("💥💥 error doing dbConn.GetVersion() error: %v", err)
+	}
+	l.Info("connected to db version : %s", dbVersion)
+
+	pgxPool, err := dbInstance.GetPGConn()
+	if err != nil {
+		l.Fatal("💥💥 error doing dbInstance.GetPGConn() : %v", err)
+	}
+	result, err := parquet2db.LoadParquetFile(context.Background(), pgxPool, parquetFilePath, schemaName, tableName, options, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing parquet2db.LoadParquetFile() : %v", err)
+	}
+	l.Info("🚀🚀 Done loading %d rows from %s in %s.%s : %d inserted, %d updated, %d deleted",
+		result.Rows, parquetFilePath, schemaName, tableName, result.Inserted, result.Updated, result.Deleted)
+}
+
+// splitColumns returns the trimmed column names of a comma separated list
+func splitColumns(list string) []string {
+	if list == "" {
+		return nil
+	}
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	return columns
+}
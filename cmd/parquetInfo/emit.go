@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquet2db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
+)
+
+// emitSchema prints the schema of the file as PostgreSQL DDL, an Arrow schema or a JSON Schema document
+func emitSchema(f *parquetinfo.File, path string, emit string, table string) error {
+	schema, err := f.ArrowSchema()
+	if err != nil {
+		return fmt.Errorf("failed to read the arrow schema: %w", err)
+	}
+	schemaName, tableName := splitTableName(path, table)
+	switch emit {
+	case "ddl":
+		statements, err := parquet2db.CreateTableStatements(schemaName, tableName, schema)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s;\n", strings.Join(statements, ";\n"))
+		return nil
+	case "arrow-schema":
+		return writeDocument(parquetinfo.ArrowSchemaJSON(schema), "json")
+	case "json-schema":
+		return writeDocument(parquetinfo.JSONSchema(tableName, schema), "json")
+	default:
+		return fmt.Errorf("invalid --emit %s, expected ddl, arrow-schema or json-schema", emit)
+	}
+}
+
+// splitTableName returns the schema and the table of --table, by default the public table named after the file
+func splitTableName(path string, table string) (string, string) {
+	if table == "" {
+		return "public", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if schemaName, tableName, found := strings.Cut(table, "."); found {
+		return schemaName, tableName
+	}
+	return "public", table
+}
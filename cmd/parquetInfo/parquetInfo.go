@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
-	"strings"
 
-	"github.com/apache/arrow-go/v18/parquet"
-	"github.com/apache/arrow-go/v18/parquet/file"
-	"github.com/apache/arrow-go/v18/parquet/metadata"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2parquet"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
 	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"gopkg.in/yaml.v3"
 )
 
 const (
 	APP              = "parquetInfo"
 	maxStringDisplay = 18
+	// exitBreakingChanges is the exit status of --diff when the new schema breaks the readers of the old one
+	exitBreakingChanges = 3
 )
 
 func main() {
@@ -22,132 +27,217 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("💥💥 error log.NewLogger error: %v'\n", err))
 	}
-	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
 
-	if len(os.Args) < 2 {
+	footerKey := flag.String("footer-key", "", "footer key of an encrypted parquet file: env:NAME or file:path, holding 16, 24 or 32 bytes raw or in hex or base64")
+	columnKeys := flag.String("column-keys", "", "comma separated list of column:keyspec of the columns encrypted with their own key")
+	format := flag.String("format", "text", "output format: text (tables with truncated values), json or yaml (the full metadata, see parquetinfo.FileInfo), csv for the rows of --head, --tail and --sample")
+	head := flag.Int64("head", 0, "print the first N rows instead of the metadata")
+	tail := flag.Int64("tail", 0, "print the last N rows instead of the metadata")
+	sample := flag.Int64("sample", 0, "print N rows drawn at random, in file order, instead of the metadata")
+	seed := flag.Uint64("seed", 0, "seed of --sample to draw the same rows again (default: random), and of the quantile sample of --profile (default: a fixed seed, the profile is the same on every run)")
+	columns := flag.String("columns", "", "comma separated list of the columns printed by --head, --tail and --sample or profiled by --profile (default: all)")
+	rowGroups := flag.String("row-groups", "", "comma separated list of the row groups read by --head, --tail and --sample (default: all)")
+	schemaDetails := flag.Bool("schema-details", false, "print the logical and converted types, the repetition and the max definition and repetition levels of the columns")
+	pageIndex := flag.Bool("page-index", false, "read and print the column index and offset index of each column chunk: per page offset, size, first row, null count, min and max")
+	bloomFilters := flag.Bool("bloom-filters", false, "read and print the location and the size of the bloom filters")
+	kvMetadata := flag.Bool("kv-metadata", false, "print the key-value metadata of the file with the ARROW:schema entry decoded")
+	profile := flag.Bool("profile", false, "scan the data and print the profile of the columns: null ratio, approximate distinct count, min, max, mean, stddev and quantiles, most frequent values and lengths")
+	topK := flag.Int("top-k", parquetinfo.DefaultProfileTopK, "number of most frequent values printed by --profile")
+	diff := flag.Bool("diff", false, "compare the schema, the row count and the column statistics of two files or datasets given as old new, exit with status 3 on breaking schema changes")
+	emit := flag.String("emit", "", "print the schema of the file instead of the metadata: ddl (PostgreSQL CREATE TABLE), arrow-schema or json-schema")
+	table := flag.String("table", "", "schema.table of the CREATE TABLE statement of --emit ddl and title of --emit json-schema (default: public and the file name)")
+	flag.Parse()
+	var preview *parquetinfo.PreviewOptions
+	for _, mode := range []struct {
+		mode parquetinfo.PreviewMode
+		rows int64
+	}{{parquetinfo.PreviewHead, *head}, {parquetinfo.PreviewTail, *tail}, {parquetinfo.PreviewSample, *sample}} {
+		if mode.rows <= 0 {
+			continue
+		}
+		if preview != nil {
+			l.Fatal("💥💥 error only one of --head, --tail and --sample can be given")
+		}
+		preview = &parquetinfo.PreviewOptions{Mode: mode.mode, Rows: mode.rows, Columns: splitColumns(*columns), Seed: *seed}
+		if preview.RowGroups, err = parseRowGroups(*rowGroups); err != nil {
+			l.Fatal("💥💥 error invalid --row-groups: %v", err)
+		}
+	}
+	switch {
+	case preview == nil && *format != "text" && *format != "json" && *format != "yaml":
+		l.Fatal("💥💥 error invalid --format %s, expected text, json or yaml", *format)
+	case preview != nil && *format != "text" && *format != "json" && *format != "csv":
+		l.Fatal("💥💥 error invalid --format %s for the rows, expected text, json or csv", *format)
+	}
+	if *format != "text" || preview != nil || *emit != "" {
+		// the document or the rows are written to stdout, the log messages go to stderr
+		if stdLogger, err := l.GetDefaultLogger(); err == nil {
+			stdLogger.SetOutput(os.Stderr)
+		}
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
+	if flag.NArg() < 1 {
 		l.Fatal("💥💥 error missing argument parquet file path")
 	}
-	parquetFilePath := os.Args[1]
-	fmt.Printf("parquet file path : %s\n", parquetFilePath)
+	parquetFilePath := flag.Arg(0)
+	if *diff && (flag.NArg() != 2 || preview != nil || *profile) {
+		l.Fatal("💥💥 error --diff needs the old and the new parquet file or dataset and cannot print rows or profiles")
+	}
+	if *profile && preview != nil {
+		l.Fatal("💥💥 error --profile cannot be combined with --head, --tail and --sample")
+	}
+	if *emit != "" && (*diff || *profile || preview != nil) {
+		l.Fatal("💥💥 error --emit cannot be combined with --diff, --profile, --head, --tail and --sample")
+	}
+	var decryption *db2parquet.EncryptionOptions
+	if *footerKey != "" || *columnKeys != "" {
+		decryption = &db2parquet.EncryptionOptions{}
+		if *footerKey != "" {
+			if decryption.FooterKey, err = db2parquet.LoadEncryptionKey(*footerKey); err != nil {
+				l.Fatal("💥💥 error loading --footer-key: %v", err)
+			}
+		}
+		if decryption.ColumnKeys, err = db2parquet.ParseColumnKeys(*columnKeys); err != nil {
+			l.Fatal("💥💥 error loading --column-keys: %v", err)
+		}
+	}
 
-	// Open the Parquet file
-	f, err := os.Open(parquetFilePath)
+	if *diff {
+		older, err := describeLocation(context.Background(), parquetFilePath, decryption)
+		if err != nil {
+			l.Fatal("💥💥 error reading %s: %v", parquetFilePath, err)
+		}
+		newer, err := describeLocation(context.Background(), flag.Arg(1), decryption)
+		if err != nil {
+			l.Fatal("💥💥 error reading %s: %v", flag.Arg(1), err)
+		}
+		comparison := parquetinfo.Compare(older, newer)
+		if err := writeDocument(comparison, *format); err != nil {
+			l.Fatal("💥💥 error writing the %s output: %v", *format, err)
+		}
+		if *format == "text" {
+			printComparison(comparison)
+		}
+		if comparison.Breaking {
+			l.Warn("breaking schema changes found from %s to %s", parquetFilePath, flag.Arg(1))
+			os.Exit(exitBreakingChanges)
+		}
+		return
+	}
+
+	if parquetinfo.IsDataset(parquetFilePath) {
+		if preview != nil || *profile || *emit != "" || *pageIndex || *bloomFilters {
+			l.Fatal("💥💥 error --head, --tail, --sample, --profile, --emit, --page-index and --bloom-filters need a single parquet file")
+		}
+		root, files, err := parquetinfo.ListDatasetFiles(parquetFilePath)
+		if err != nil {
+			l.Fatal("💥💥 error listing the dataset: %v", err)
+		}
+		l.Info("reading the footers of %d parquet files in %s", len(files), root)
+		dataset, err := parquetinfo.DescribeDataset(context.Background(), root, files, decryption)
+		if err != nil {
+			l.Fatal("💥💥 error reading the dataset: %v", err)
+		}
+		if err := writeDocument(dataset, *format); err != nil {
+			l.Fatal("💥💥 error writing the %s output: %v", *format, err)
+		}
+		if *format == "text" {
+			printDataset(dataset)
+		}
+		if !dataset.Compatible() {
+			l.Warn("%d schema differences found between the files of %s", len(dataset.SchemaDifferences), root)
+		}
+		return
+	}
+
+	// Open the Parquet file, the keys decrypt the footer and the metadata of the encrypted columns
+	f, err := parquetinfo.Open(context.Background(), parquetFilePath, decryption)
 	if err != nil {
 		l.Fatal("💥💥 error opening parquet file: %v", err)
 	}
 	defer f.Close()
-
-	// Create a Parquet file reader
-	reader, err := file.NewParquetReader(f)
+	if preview != nil {
+		record, err := parquetinfo.Preview(context.Background(), f, *preview, memory.DefaultAllocator)
+		if err != nil {
+			l.Fatal("💥💥 error reading the rows: %v", err)
+		}
+		defer record.Release()
+		if err := printRecord(record, *format); err != nil {
+			l.Fatal("💥💥 error writing the rows: %v", err)
+		}
+		return
+	}
+	if *emit != "" {
+		if err := emitSchema(f, parquetFilePath, *emit, *table); err != nil {
+			l.Fatal("💥💥 error emitting the schema: %v", err)
+		}
+		return
+	}
+	if *profile {
+		options := parquetinfo.ProfileOptions{Columns: splitColumns(*columns), TopK: *topK, Seed: *seed}
+		profileInfo, err := parquetinfo.Profile(context.Background(), f, options, memory.DefaultAllocator)
+		if err != nil {
+			l.Fatal("💥💥 error profiling the columns: %v", err)
+		}
+		if err := writeDocument(profileInfo, *format); err != nil {
+			l.Fatal("💥💥 error writing the %s output: %v", *format, err)
+		}
+		if *format == "text" {
+			printProfile(profileInfo)
+		}
+		return
+	}
+	info, err := parquetinfo.Describe(f, parquetinfo.DescribeOptions{PageIndex: *pageIndex, BloomFilters: *bloomFilters})
 	if err != nil {
-		l.Fatal("💥💥 error creating parquet reader: %v", err)
+		l.Fatal("💥💥 error reading the metadata: %v", err)
 	}
+	if err := writeDocument(info, *format); err != nil {
+		l.Fatal("💥💥 error writing the %s output: %v", *format, err)
+	}
+	if *format == "text" {
+		printText(info)
+		if *schemaDetails {
+			printSchemaDetails(info)
+		}
+		if *kvMetadata {
+			printKeyValueMetadata(info)
+		}
+		if *bloomFilters {
+			printBloomFilters(info)
+		}
+		if *pageIndex {
+			printPageIndex(info)
+		}
+	}
+}
 
-	fileMetadata := reader.MetaData()
-	fmt.Printf("Version : %d\n", fileMetadata.GetVersion())
-	fmt.Printf("Created by : %s\n", fileMetadata.GetCreatedBy())
-
-	fmt.Printf("Number of rows : %d\n", fileMetadata.GetNumRows())
-	fmt.Printf("Number of row groups: %d\n", reader.NumRowGroups())
-
-	schema := fileMetadata.Schema
-	fmt.Printf("Schema:\n%s\n\n", schema.String())
-
-	for i := 0; i < reader.NumRowGroups(); i++ {
-		rgIdxRdr := reader.RowGroup(i)
-		rgMeta := rgIdxRdr.MetaData()
-		fmt.Printf("##  --- Row Group %d ---\n", i)
-		fmt.Printf("##  Number of rows: %d\n", rgMeta.NumRows())
-		fmt.Printf("##  Total byte size: %d\n", rgMeta.TotalByteSize())
-		fmt.Printf("##  Number of columns: %d\n", rgMeta.NumColumns())
-		fmt.Println("  Columns info:")
-		fmt.Printf("%5s | %20s | %10s | %8s | %18s | %18s | %8s| %8s | %12s | %16s | %8s | %8s\n",
-			"Col", "Path", "Type", "Values", "Min", "Max", "NullCount", "Distinct", "Comp", "Encodings", "CSize", "USize")
-		fmt.Printf("%s\n", strings.Repeat("-", 172))
-		for idx, c := range fileMetadata.Schema.Columns() {
-			//l.Debug("inspecting column %d: %s (%d)", idx, c.LogicalType(), c.TypeLength())
-			cm, err := rgMeta.ColumnChunk(idx)
-			if err != nil {
-				l.Fatal("error getting column %d, %s, metadata: %v", idx, c.Name(), err)
-			}
-
-			path := strings.Join(cm.PathInSchema(), ".")
-			typ := cm.Type()
-			typString := typ.String()
-			if typ == parquet.Types.ByteArray {
-				if c.LogicalType().String() == "String" {
-					typString = "VARCHAR"
-				}
-			}
-			numValues := cm.NumValues()
-			comp := cm.Compression().String()
-			enc := fmt.Sprintf("%v", cm.Encodings())
-			switch enc {
-			case "[PLAIN RLE_DICTIONARY]":
-				enc = "[PLAIN RLE_DICT]"
-			case "[PLAIN_DICTIONARY PLAIN RLE]":
-				enc = "[PLAIN_DICT RLE]"
-			case "[RLE_DICTIONARY PLAIN RLE]":
-				enc = "[RLE_DICT PLAIN RLE]"
-			default:
-
-			}
-
-			cSize := cm.TotalCompressedSize()
-			uSize := cm.TotalUncompressedSize()
-			stats, err := cm.Statistics()
-			if err != nil {
-				l.Fatal("error statistics for column %d, %s, metadata: %v", idx, c.Name(), err)
-			}
-			NullCount := int64(0)
-			DistinctCount := int64(0)
-			var minVal, maxVal interface{}
-			minVal = nil // Or use a placeholder like "N/A" if you prefer
-			maxVal = nil // Or use a placeholder like "N/A"
-			if stats != nil {
-				if stats.HasNullCount() {
-					//fmt.Printf(", Null Values: %d", stats.NullCount())
-					NullCount = stats.NullCount()
-				}
-				if stats.HasDistinctCount() {
-					//fmt.Printf(", Distinct Values: %d", stats.DistinctCount())
-					DistinctCount = stats.DistinctCount()
-				}
-				if stats.HasMinMax() {
-					minRaw := metadata.GetStatValue(stats.Type(), stats.EncodeMin())
-					maxRaw := metadata.GetStatValue(stats.Type(), stats.EncodeMax())
+// describeLocation describes a parquet file as a dataset of one file, or the files of a directory or a glob
+func describeLocation(ctx context.Context, location string, decryption *db2parquet.EncryptionOptions) (*parquetinfo.DatasetInfo, error) {
+	if !parquetinfo.IsDataset(location) {
+		return parquetinfo.DescribeDataset(ctx, location, []string{location}, decryption)
+	}
+	root, files, err := parquetinfo.ListDatasetFiles(location)
+	if err != nil {
+		return nil, err
+	}
+	return parquetinfo.DescribeDataset(ctx, root, files, decryption)
+}
 
-					// Check if the type is BYTE_ARRAY and convert to string for printing
-					if typ == parquet.Types.ByteArray {
-						if minBytes, ok := minRaw.([]byte); ok {
-							// Convert byte slice to string
-							if len(string(minBytes)) > maxStringDisplay {
-								minVal = string(minBytes)[0:maxStringDisplay-1] + "…"
-							} else {
-								minVal = string(minBytes)
-							}
-						} else {
-							minVal = minRaw // Fallback in case of unexpected type
-						}
-						if maxBytes, ok := maxRaw.([]byte); ok {
-							// Convert byte slice to string
-							if len(string(maxBytes)) > maxStringDisplay {
-								maxVal = string(maxBytes)[0:maxStringDisplay-1] + "…"
-							} else {
-								maxVal = string(maxBytes)
-							}
-						} else {
-							maxVal = maxRaw // Fallback
-						}
-					} else {
-						// Not a BYTE_ARRAY, use the raw value
-						minVal = minRaw
-						maxVal = maxRaw
-					}
-				}
-			}
-			fmt.Printf("%5d | %20s | %10s | %8d | %18v | %18v | %8d | %8d | %12s | %16s | %8d | %8d\n",
-				idx+1, path, typString, numValues, minVal, maxVal, NullCount, DistinctCount, comp, enc, cSize, uSize)
+// writeDocument writes the document in json or yaml to stdout, the text format is printed by the caller
+func writeDocument(document interface{}, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(document)
+	case "yaml":
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(document); err != nil {
+			return err
 		}
+		return encoder.Close()
+	default:
+		return nil
 	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// maxCellDisplay is the width of the widest cell of the preview table, longer values are truncated
+const maxCellDisplay = 40
+
+// printRecord prints the rows of the preview as an aligned table, as CSV or as one JSON object per line
+func printRecord(record arrow.Record, format string) error {
+	switch format {
+	case "json":
+		return array.RecordToJSON(record, os.Stdout)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		header := make([]string, record.NumCols())
+		for i, field := range record.Schema().Fields() {
+			header[i] = field.Name
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for row := 0; row < int(record.NumRows()); row++ {
+			line := make([]string, record.NumCols())
+			for i, col := range record.Columns() {
+				if !col.IsNull(row) {
+					line[i] = col.ValueStr(row)
+				}
+			}
+			if err := w.Write(line); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		printTable(record)
+		return nil
+	}
+}
+
+// printTable prints the rows with the columns padded to their widest value
+func printTable(record arrow.Record) {
+	numRows := int(record.NumRows())
+	cells := make([][]string, numRows+1)
+	cells[0] = make([]string, record.NumCols())
+	widths := make([]int, record.NumCols())
+	for i, field := range record.Schema().Fields() {
+		cells[0][i] = field.Name
+	}
+	for row := 0; row < numRows; row++ {
+		cells[row+1] = make([]string, record.NumCols())
+		for i, col := range record.Columns() {
+			value := "NULL"
+			if !col.IsNull(row) {
+				value = truncateCell(col.ValueStr(row))
+			}
+			cells[row+1][i] = value
+		}
+	}
+	for _, line := range cells {
+		for i, value := range line {
+			widths[i] = max(widths[i], utf8.RuneCountInString(value))
+		}
+	}
+	for n, line := range cells {
+		padded := make([]string, len(line))
+		for i, value := range line {
+			padded[i] = value + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(value))
+		}
+		fmt.Println(strings.TrimRight(strings.Join(padded, " | "), " "))
+		if n == 0 {
+			separators := make([]string, len(widths))
+			for i, width := range widths {
+				separators[i] = strings.Repeat("-", width)
+			}
+			fmt.Println(strings.Join(separators, "-+-"))
+		}
+	}
+	fmt.Printf("(%d rows)\n", numRows)
+}
+
+// truncateCell shortens a value to maxCellDisplay runes and keeps it on one line
+func truncateCell(value string) string {
+	value = strings.NewReplacer("\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(value)
+	if utf8.RuneCountInString(value) > maxCellDisplay {
+		return string([]rune(value)[:maxCellDisplay-1]) + "…"
+	}
+	return value
+}
+
+// splitColumns returns the trimmed column names of a comma separated list
+func splitColumns(list string) []string {
+	if list == "" {
+		return nil
+	}
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	return columns
+}
+
+// parseRowGroups returns the row group indices of a comma separated list
+func parseRowGroups(list string) ([]int, error) {
+	var rowGroups []int
+	for _, item := range splitColumns(list) {
+		rg, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid row group %q", item)
+		}
+		rowGroups = append(rowGroups, rg)
+	}
+	return rowGroups, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
+)
+
+// printProfile prints a summary table of the columns followed by the details of each column
+func printProfile(info *parquetinfo.ProfileInfo) {
+	fmt.Printf("##  --- Profile of %s, %d rows ---\n", info.Location, info.NumRows)
+	fmt.Printf("%24s | %24s | %8s | %12s | %18s | %18s\n", "Column", "Type", "Nulls", "~Distinct", "Min", "Max")
+	fmt.Printf("%s\n", strings.Repeat("-", 118))
+	for _, c := range info.Columns {
+		fmt.Printf("%24s | %24s | %7.2f%% | %12d | %18v | %18v\n", c.Name, truncateValue(c.Type), 100*c.NullRatio, c.DistinctCount,
+			truncateValue(formatProfileValue(c.Min)), truncateValue(formatProfileValue(c.Max)))
+	}
+	for _, c := range info.Columns {
+		if c.Numeric == nil && len(c.TopValues) == 0 && c.Lengths == nil {
+			continue
+		}
+		fmt.Printf("\n##  --- %s ---\n", c.Name)
+		if n := c.Numeric; n != nil {
+			fmt.Printf("mean: %g, stddev: %g", n.Mean, n.StdDev)
+			if n.NaNCount > 0 {
+				fmt.Printf(", NaN: %d", n.NaNCount)
+			}
+			fmt.Printf("\n")
+			if len(n.Quantiles) > 0 {
+				quantiles := make([]string, len(n.Quantiles))
+				for i, q := range n.Quantiles {
+					quantiles[i] = fmt.Sprintf("p%g: %g", 100*q.Quantile, q.Value)
+				}
+				fmt.Printf("quantiles of %d values: %s\n", n.SampleSize, strings.Join(quantiles, ", "))
+			}
+		}
+		if len(c.TopValues) > 0 {
+			approximate := ""
+			if !c.TopValuesExact {
+				approximate = " (approximate counts)"
+			}
+			fmt.Printf("top values%s:\n", approximate)
+			for _, v := range c.TopValues {
+				fmt.Printf("%12d  %s\n", v.Count, truncateCell(v.Value))
+			}
+		}
+		if lengths := c.Lengths; lengths != nil {
+			fmt.Printf("lengths: min %d, max %d, mean %.2f\n", lengths.Min, lengths.Max, lengths.Mean)
+			for _, bucket := range lengths.Histogram {
+				fmt.Printf("%12s  %d\n", fmt.Sprintf("%d-%d", bucket.Min, bucket.Max), bucket.Count)
+			}
+		}
+	}
+}
+
+// formatProfileValue formats the bounds of a column, the times in RFC 3339 and nothing when absent
+func formatProfileValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return parquetinfo.FormatStatValue(v)
+}
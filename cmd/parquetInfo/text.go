@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
+)
+
+// printText prints the metadata as a header followed by a table of the column chunks of each row group
+func printText(info *parquetinfo.FileInfo) {
+	fmt.Printf("parquet file path : %s\n", info.Location)
+	fmt.Printf("Version : %d\n", info.Version)
+	fmt.Printf("Created by : %s\n", info.CreatedBy)
+
+	fmt.Printf("Number of rows : %d\n", info.NumRows)
+	fmt.Printf("Number of row groups: %d\n", info.NumRowGroups)
+	if info.Encryption != "" {
+		fmt.Printf("Encryption : %s\n", info.Encryption)
+	}
+
+	fmt.Printf("Schema:\n%s\n\n", info.Schema.Text)
+
+	for _, rg := range info.RowGroups {
+		fmt.Printf("##  --- Row Group %d ---\n", rg.Index)
+		fmt.Printf("##  Number of rows: %d\n", rg.NumRows)
+		fmt.Printf("##  Total byte size: %d\n", rg.TotalByteSize)
+		fmt.Printf("##  Number of columns: %d\n", len(rg.Columns))
+		fmt.Println("  Columns info:")
+		fmt.Printf("%5s | %20s | %10s | %8s | %18s | %18s | %8s| %8s | %12s | %16s | %8s | %8s\n",
+			"Col", "Path", "Type", "Values", "Min", "Max", "NullCount", "Distinct", "Comp", "Encodings", "CSize", "USize")
+		fmt.Printf("%s\n", strings.Repeat("-", 172))
+		for idx, cm := range rg.Columns {
+			if cm.Encrypted {
+				fmt.Printf("%5d | %20s | encrypted, its key is needed in --column-keys\n", idx+1, cm.Path)
+				continue
+			}
+			typString := cm.PhysicalType
+			if typString == "BYTE_ARRAY" && info.Schema.Columns[idx].LogicalType == "String" {
+				typString = "VARCHAR"
+			}
+			enc := fmt.Sprintf("%v", cm.Encodings)
+			switch enc {
+			case "[PLAIN RLE_DICTIONARY]":
+				enc = "[PLAIN RLE_DICT]"
+			case "[PLAIN_DICTIONARY PLAIN RLE]":
+				enc = "[PLAIN_DICT RLE]"
+			case "[RLE_DICTIONARY PLAIN RLE]":
+				enc = "[RLE_DICT PLAIN RLE]"
+			default:
+
+			}
+			NullCount := int64(0)
+			DistinctCount := int64(0)
+			var minVal, maxVal interface{}
+			if stats := cm.Statistics; stats != nil {
+				if stats.NullCount != nil {
+					NullCount = *stats.NullCount
+				}
+				if stats.DistinctCount != nil {
+					DistinctCount = *stats.DistinctCount
+				}
+				minVal, maxVal = truncateValue(stats.Min), truncateValue(stats.Max)
+			}
+			fmt.Printf("%5d | %20s | %10s | %8d | %18v | %18v | %8d | %8d | %12s | %16s | %8d | %8d\n",
+				idx+1, cm.Path, typString, cm.NumValues, minVal, maxVal, NullCount, DistinctCount, cm.Compression, enc, cm.CompressedSize, cm.UncompressedSize)
+		}
+	}
+}
+
+// arrowSchemaKey is the key-value metadata entry of the Arrow schema, decoded in parquetinfo.FileInfo.ArrowSchema
+const arrowSchemaKey = "ARROW:schema"
+
+// truncateValue shortens the strings to fit in the Min and Max columns of the table
+func truncateValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok && len(s) > maxStringDisplay {
+		return s[0:maxStringDisplay-1] + "…"
+	}
+	return v
+}
+
+// printSchemaDetails prints the types and the levels of the leaf columns
+func printSchemaDetails(info *parquetinfo.FileInfo) {
+	fmt.Printf("\n##  --- Schema details ---\n")
+	fmt.Printf("%5s | %24s | %20s | %40s | %12s | %10s | %6s | %6s\n",
+		"Col", "Path", "Physical", "Logical", "Converted", "Repetition", "MaxDef", "MaxRep")
+	fmt.Printf("%s\n", strings.Repeat("-", 148))
+	for _, c := range info.Schema.Columns {
+		physical := c.PhysicalType
+		if c.TypeLength > 0 {
+			physical = fmt.Sprintf("%s(%d)", physical, c.TypeLength)
+		}
+		fmt.Printf("%5d | %24s | %20s | %40s | %12s | %10s | %6d | %6d\n",
+			c.Index+1, c.Path, physical, c.LogicalType, c.ConvertedType, c.Repetition, c.MaxDefinitionLevel, c.MaxRepetitionLevel)
+	}
+}
+
+// printKeyValueMetadata prints the key-value metadata, the ARROW:schema entry is printed decoded
+func printKeyValueMetadata(info *parquetinfo.FileInfo) {
+	fmt.Printf("\n##  --- Key-value metadata ---\n")
+	for _, key := range sortedKeys(info.KeyValueMetadata) {
+		if key == arrowSchemaKey && info.ArrowSchema != nil {
+			fmt.Printf("%s: (%d bytes, decoded below)\n", key, len(info.KeyValueMetadata[key]))
+			continue
+		}
+		fmt.Printf("%s: %s\n", key, info.KeyValueMetadata[key])
+	}
+	if info.ArrowSchema == nil {
+		return
+	}
+	fmt.Printf("\n##  --- Arrow schema (%s) ---\n", arrowSchemaKey)
+	for _, field := range info.ArrowSchema {
+		nullable := "not null"
+		if field.Nullable {
+			nullable = "nullable"
+		}
+		fmt.Printf("%s: %s, %s\n", field.Name, field.Type, nullable)
+		for _, key := range sortedKeys(field.Metadata) {
+			fmt.Printf("    %s: %s\n", key, field.Metadata[key])
+		}
+	}
+}
+
+// printBloomFilters prints the bloom filters of the column chunks
+func printBloomFilters(info *parquetinfo.FileInfo) {
+	fmt.Printf("\n##  --- Bloom filters ---\n")
+	fmt.Printf("%8s | %24s | %12s | %10s | %10s\n", "RowGroup", "Path", "Offset", "Length", "Bitset")
+	fmt.Printf("%s\n", strings.Repeat("-", 76))
+	found := false
+	for _, rg := range info.RowGroups {
+		for _, cm := range rg.Columns {
+			if cm.BloomFilter == nil {
+				continue
+			}
+			found = true
+			fmt.Printf("%8d | %24s | %12d | %10d | %10d\n", rg.Index, cm.Path, cm.BloomFilter.Offset, cm.BloomFilter.Length, cm.BloomFilter.NumBytes)
+		}
+	}
+	if !found {
+		fmt.Println("no bloom filter in the file")
+	}
+}
+
+// printPageIndex prints the pages of the column chunks that have a page index
+func printPageIndex(info *parquetinfo.FileInfo) {
+	fmt.Printf("\n##  --- Page index ---\n")
+	found := false
+	for _, rg := range info.RowGroups {
+		for _, cm := range rg.Columns {
+			if cm.PageIndex == nil {
+				continue
+			}
+			found = true
+			fmt.Printf("##  Row group %d, column %s, %d pages, boundary order %s\n", rg.Index, cm.Path, len(cm.PageIndex.Pages), cm.PageIndex.BoundaryOrder)
+			fmt.Printf("%6s | %12s | %8s | %12s | %8s | %18s | %18s\n", "Page", "Offset", "CSize", "FirstRow", "Nulls", "Min", "Max")
+			for i, page := range cm.PageIndex.Pages {
+				nulls := ""
+				if page.NullCount != nil {
+					nulls = fmt.Sprint(*page.NullCount)
+				}
+				var minVal, maxVal interface{} = truncateValue(page.Min), truncateValue(page.Max)
+				if page.NullPage {
+					minVal, maxVal = "(null page)", ""
+				}
+				fmt.Printf("%6d | %12d | %8d | %12d | %8s | %18v | %18v\n", i, page.Offset, page.CompressedSize, page.FirstRowIndex, nulls, minVal, maxVal)
+			}
+		}
+	}
+	if !found {
+		fmt.Println("no page index in the file")
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// printDataset prints the summary of a dataset, its schema differences, its partitions and its columns
+func printDataset(dataset *parquetinfo.DatasetInfo) {
+	fmt.Printf("dataset root : %s\n", dataset.Root)
+	fmt.Printf("Number of files : %d\n", dataset.NumFiles)
+	fmt.Printf("Number of rows : %d\n", dataset.NumRows)
+	fmt.Printf("Number of row groups: %d\n", dataset.NumRowGroups)
+	fmt.Printf("Files size : %d\n", dataset.FileSize)
+	fmt.Printf("Compressed size : %d, uncompressed size : %d, compression ratio : %.2f\n",
+		dataset.CompressedSize, dataset.UncompressedSize, dataset.CompressionRatio)
+	if len(dataset.PartitionColumns) > 0 {
+		fmt.Printf("Partition columns : %s\n", strings.Join(dataset.PartitionColumns, ", "))
+	}
+
+	fmt.Printf("\n##  --- Schema compatibility ---\n")
+	if dataset.Compatible() {
+		fmt.Printf("the %d files share the schema of %s\n", dataset.NumFiles, dataset.Files[0].Path)
+	} else {
+		fmt.Printf("the schema of %s is the reference\n", dataset.Files[0].Path)
+		for _, difference := range dataset.SchemaDifferences {
+			fmt.Printf("%s: %s\n", difference.File, formatSchemaDifference(difference))
+		}
+	}
+
+	if len(dataset.Partitions) > 0 {
+		fmt.Printf("\n##  --- Partitions ---\n")
+		fmt.Printf("%40s | %6s | %12s | %14s | %14s | %6s\n", "Partition", "Files", "Rows", "CSize", "USize", "Ratio")
+		fmt.Printf("%s\n", strings.Repeat("-", 106))
+		for _, p := range dataset.Partitions {
+			fmt.Printf("%40s | %6d | %12d | %14d | %14d | %6.2f\n", p.Path, p.NumFiles, p.NumRows, p.CompressedSize, p.UncompressedSize,
+				float64(p.UncompressedSize)/float64(max(p.CompressedSize, 1)))
+		}
+	}
+
+	fmt.Printf("\n##  --- Columns ---\n")
+	fmt.Printf("%24s | %6s | %12s | %12s | %14s | %14s | %6s\n", "Path", "Files", "Values", "Nulls", "CSize", "USize", "Ratio")
+	fmt.Printf("%s\n", strings.Repeat("-", 104))
+	for _, c := range dataset.Columns {
+		nulls := "?"
+		if c.NullCount != nil {
+			nulls = fmt.Sprint(*c.NullCount)
+		}
+		fmt.Printf("%24s | %6d | %12d | %12s | %14d | %14d | %6.2f\n", c.Path, c.NumFiles, c.NumValues, nulls, c.CompressedSize, c.UncompressedSize, c.CompressionRatio)
+	}
+}
+
+// formatSchemaDifference describes a schema difference on one line
+func formatSchemaDifference(difference parquetinfo.SchemaDifference) string {
+	switch difference.Kind {
+	case parquetinfo.SchemaColumnRemoved:
+		return fmt.Sprintf("column %s (%s) is missing", difference.Column, difference.Reference)
+	case parquetinfo.SchemaColumnAdded:
+		return fmt.Sprintf("column %s (%s) is added", difference.Column, difference.Actual)
+	default:
+		return fmt.Sprintf("column %s changed from %s to %s", difference.Column, difference.Reference, difference.Actual)
+	}
+}
+
+// printComparison prints the schema differences, the row counts and the columns with other statistics
+func printComparison(comparison *parquetinfo.Comparison) {
+	fmt.Printf("##  --- Diff %s -> %s ---\n", comparison.Old, comparison.New)
+	fmt.Printf("Rows: %d -> %d (%+.2f%%)\n", comparison.OldRows, comparison.NewRows, 100*comparison.RowCountChange())
+
+	fmt.Printf("\n##  --- Schema differences ---\n")
+	if len(comparison.SchemaDifferences) == 0 {
+		fmt.Printf("none\n")
+	}
+	for _, difference := range comparison.SchemaDifferences {
+		marker := ""
+		if difference.Breaking {
+			marker = "BREAKING "
+		}
+		fmt.Printf("%s%s\n", marker, formatSchemaDifference(difference))
+	}
+
+	fmt.Printf("\n##  --- Column statistics changes ---\n")
+	if len(comparison.Columns) == 0 {
+		fmt.Printf("none\n")
+		return
+	}
+	fmt.Printf("%24s | %21s | %41s | %41s\n", "Path", "Null ratio", "Min", "Max")
+	fmt.Printf("%s\n", strings.Repeat("-", 136))
+	for _, c := range comparison.Columns {
+		fmt.Printf("%24s | %21s | %41s | %41s\n", c.Column,
+			formatRatio(c.OldNullRatio)+" -> "+formatRatio(c.NewNullRatio),
+			formatStatChange(c.OldMin, c.NewMin), formatStatChange(c.OldMax, c.NewMax))
+	}
+}
+
+func formatRatio(ratio *float64) string {
+	if ratio == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%.2f%%", 100**ratio)
+}
+
+// formatStatChange formats a min or max change with each value truncated like the other tables
+func formatStatChange(older, newer interface{}) string {
+	return fmt.Sprintf("%v -> %v", truncateValue(parquetinfo.FormatStatValue(older)), truncateValue(parquetinfo.FormatStatValue(newer)))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/csv"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetquery"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	APP           = "queryParquet"
+	defaultFormat = "text"
+)
+
+func main() {
+	l, err := golog.NewLogger("zap", golog.TraceLevel, APP)
+	if err != nil {
+		panic(fmt.Sprintf("💥💥 error log.NewLogger error: %v'\n", err))
+	}
+	// the rows are written to stdout, the log messages go to stderr
+	if stdLogger, err := l.GetDefaultLogger(); err == nil {
+		stdLogger.SetOutput(os.Stderr)
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
+
+	format := flag.String("format", defaultFormat, "output format of the rows: text (aligned columns), csv, json (one object per line) or arrow (Arrow IPC stream)")
+	table := flag.String("table", parquetquery.DefaultTable, "name of the view reading all the parquet files in the query")
+	threads := flag.Int("threads", 0, "number of threads used by DuckDB (default: the number of cpus)")
+	memoryLimit := flag.String("memory-limit", "", "maximum memory used by DuckDB, like 512MB or 4GB (default: 80% of the memory)")
+	maxRows := flag.Int64("max-rows", 0, "stop after this number of rows (default: all)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 2 {
+		l.Fatal("💥💥 error expected arguments: SQL location..., each location is a parquet file, a directory or a glob pattern, - as SQL reads it from stdin")
+	}
+	switch *format {
+	case "text", "csv", "json", "arrow":
+	default:
+		l.Fatal("💥💥 error invalid --format %s, expected text, csv, json or arrow", *format)
+	}
+	query := args[0]
+	if query == "-" {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			l.Fatal("💥💥 error reading the query from stdin: %v", err)
+		}
+		query = string(content)
+	}
+	files, err := parquetquery.ResolveFiles(args[1:])
+	if err != nil {
+		l.Fatal("💥💥 error listing the parquet files: %v", err)
+	}
+	options := parquetquery.Options{Table: *table, Threads: *threads, MemoryLimit: *memoryLimit, MaxRows: *maxRows}
+
+	l.Info("querying the view %s of %d parquet files", options.Table, len(files))
+	// SIGINT interrupts the query
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	start := time.Now()
+	result, err := parquetquery.Query(ctx, files, query, options, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing parquetquery.Query() : %v", err)
+	}
+	defer result.Close()
+	if err := writeResult(result, *format); err != nil {
+		l.Fatal("💥💥 error writing the rows: %v", err)
+	}
+	l.Info("🚀🚀 %d rows returned in %s", result.Rows(), time.Since(start).Round(time.Millisecond))
+}
+
+// writeResult writes the records of the result to stdout in the format
+func writeResult(result *parquetquery.Result, format string) error {
+	schema := result.Schema()
+	var write func(arrow.Record) error
+	var flush func() error
+	switch format {
+	case "arrow":
+		w := ipc.NewWriter(os.Stdout, ipc.WithSchema(schema))
+		write, flush = w.Write, w.Close
+	case "csv":
+		w := csv.NewWriter(os.Stdout, schema, csv.WithHeader(true), csv.WithNullWriter(""))
+		write, flush = w.Write, w.Flush
+	case "json":
+		write = func(record arrow.Record) error {
+			return array.RecordToJSON(record, os.Stdout)
+		}
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		header := make([]string, schema.NumFields())
+		for i, field := range schema.Fields() {
+			header[i] = field.Name
+		}
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		write = func(record arrow.Record) error {
+			return printRows(w, record)
+		}
+		flush = w.Flush
+	}
+	for result.Next() {
+		if err := write(result.Record()); err != nil {
+			return err
+		}
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+	if flush != nil {
+		return flush()
+	}
+	return nil
+}
+
+// printRows writes the rows of the record as tab separated cells, the tab writer aligns the columns
+func printRows(w io.Writer, record arrow.Record) error {
+	cells := make([]string, record.NumCols())
+	for row := 0; row < int(record.NumRows()); row++ {
+		for i, col := range record.Columns() {
+			if col.IsNull(row) {
+				cells[i] = "NULL"
+			} else {
+				cells[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(col.ValueStr(row))
+			}
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(cells, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
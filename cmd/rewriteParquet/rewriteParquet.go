@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetrewrite"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	APP                = "rewriteParquet"
+	defaultCompression = "snappy"
+)
+
+func main() {
+	l, err := golog.NewLogger("zap", golog.TraceLevel, APP)
+	if err != nil {
+		panic(fmt.Sprintf("💥💥 error log.NewLogger error: %v'\n", err))
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
+
+	compression := flag.String("compression", defaultCompression, "compression codec of the pages: none, snappy, gzip, brotli, zstd or lz4")
+	compressionLevel := flag.Int("compression-level", 0, "level of the gzip, brotli and zstd codecs (default: the default level of the codec)")
+	rowGroupRows := flag.Int64("row-group-rows", parquetrewrite.DefaultRowGroupRows, "number of rows of each row group")
+	targetFileSizeMb := flag.Int64("target-file-size-mb", 0, "write files of about this size in MiB in the output directory (0 writes one file at the output path)")
+	sortBy := flag.String("sort-by", "", "comma separated list of columns sorting the rows, each one optionally followed by :asc or :desc")
+	sortRunRows := flag.Int64("sort-run-rows", parquetrewrite.DefaultSortRunRows, "number of rows sorted in memory by --sort-by, more rows are sorted in runs spilled to --temp-dir")
+	tempDir := flag.String("temp-dir", "", "directory of the sorted runs of --sort-by (default: the temporary directory)")
+	columns := flag.String("columns", "", "comma separated list of the columns kept, in this order (default: all)")
+	noDictionary := flag.Bool("no-dictionary", false, "disable the dictionary encoding of the columns")
+	dictionaryColumns := flag.String("dictionary-columns", "", "comma separated list of the column paths always dictionary encoded, even with --no-dictionary")
+	noDictionaryColumns := flag.String("no-dictionary-columns", "", "comma separated list of the column paths never dictionary encoded")
+	batchRows := flag.Int64("batch-rows", parquetrewrite.DefaultBatchRows, "number of rows read at once from the input files")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 2 {
+		l.Fatal("💥💥 error expected arguments: input output, the input is a parquet file, a directory or a glob pattern")
+	}
+	input, output := args[0], args[1]
+	options := parquetrewrite.Options{
+		CompressionLevel: *compressionLevel,
+		RowGroupRows:     *rowGroupRows,
+		TargetFileSize:   *targetFileSizeMb * 1024 * 1024,
+		SortRunRows:      *sortRunRows,
+		TempDir:          *tempDir,
+		Columns:          splitColumns(*columns),
+		NoDictionary:     *noDictionary,
+		Dictionary:       make(map[string]bool),
+		BatchRows:        *batchRows,
+	}
+	if options.Compression, err = parquetrewrite.ParseCompression(*compression); err != nil {
+		l.Fatal("💥💥 error %v", err)
+	}
+	if options.SortBy, err = parquetrewrite.ParseSortKeys(*sortBy); err != nil {
+		l.Fatal("💥💥 error invalid --sort-by: %v", err)
+	}
+	for _, path := range splitColumns(*dictionaryColumns) {
+		options.Dictionary[path] = true
+	}
+	for _, path := range splitColumns(*noDictionaryColumns) {
+		options.Dictionary[path] = false
+	}
+
+	inputs := []string{input}
+	if parquetinfo.IsDataset(input) {
+		// the partition columns of a Hive dataset are only in the directory names, they are added back
+		if options.DatasetRoot, inputs, err = parquetinfo.ListDatasetFiles(input); err != nil {
+			l.Fatal("💥💥 error listing the input files: %v", err)
+		}
+	}
+	l.Info("rewriting %d parquet files of %s to %s", len(inputs), input, output)
+	// SIGINT stops the rewrite, the file being written and the sorted runs are removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	start := time.Now()
+	result, err := parquetrewrite.Rewrite(ctx, inputs, output, options, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing parquetrewrite.Rewrite() : %v", err)
+	}
+	l.Info("🚀🚀 %d rows of %d files rewritten in %d files with %d row groups, %d bytes, in %s",
+		result.Rows, result.InputFiles, len(result.Files), result.RowGroups, result.Bytes, time.Since(start).Round(time.Millisecond))
+}
+
+// splitColumns returns the trimmed column names of a comma separated list
+func splitColumns(list string) []string {
+	if list == "" {
+		return nil
+	}
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	return columns
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquet2db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/config"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/tools"
+)
+
+const (
+	APP              = "validateParquetPgDb"
+	defaultDBPort    = 5432
+	defaultDBIp      = "127.0.0.1"
+	defaultDBSslMode = "prefer"
+	// exitViolations is the exit status when the file cannot be loaded in the table, errors exit with 1
+	exitViolations = 3
+)
+
+func main() {
+	l, err := golog.NewLogger("zap", golog.TraceLevel, APP)
+	if err != nil {
+		panic(fmt.Sprintf("💥💥 error log.NewLogger error: %v'\n", err))
+	}
+	// the violations are written to stdout, the log messages go to stderr
+	if stdLogger, err := l.GetDefaultLogger(); err == nil {
+		stdLogger.SetOutput(os.Stderr)
+	}
+	l.Info("🚀🚀 Starting App:'%s', ver:%s, from: %s", APP, version.VERSION, version.REPOSITORY)
+
+	output := flag.String("output", "text", "format of the violations: text or json (one object per line)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 3 {
+		l.Fatal("💥💥 error expected arguments: schema table parquet_file_path")
+	}
+	schemaName, tableName, parquetFilePath := args[0], args[1], args[2]
+	if *output != "text" && *output != "json" {
+		l.Fatal("💥💥 error invalid --output %s, expected text or json", *output)
+	}
+	l.Info("validating parquet file %s against table %s.%s", parquetFilePath, schemaName, tableName)
+
+	dbDsn := config.GetPgDbDsnUrlFromEnvOrPanic(defaultDBIp, defaultDBPort, tools.ToSnakeCase(version.APP), version.AppSnake, defaultDBSslMode)
+	dbInstance, err := database.GetInstance("pgx", dbDsn, runtime.NumCPU(), l)
+	if err != nil {
+		l.Fatal("💥💥 error doing database.GetInstance(pgx ...) error: %v", err)
+	}
+	defer dbInstance.Close()
+
+	dbVersion, err := dbInstance.GetVersion()
+	if err != nil {
+		l.Fatal("💥💥 error doing dbConn.GetVersion() error: %v", err)
+	}
+	l.Info("connected to db version : %s", dbVersion)
+
+	dbStore := db.GetStorageInstanceOrPanic("pgx", dbInstance, l)
+	tableColumns, err := dbStore.GetTableSchema(schemaName, tableName)
+	if err != nil {
+		l.Fatal("💥💥 error doing dbStore.GetTableSchema() : %v", err)
+	}
+	if len(tableColumns) == 0 {
+		l.Fatal("💥💥 error no columns found for table %s.%s", schemaName, tableName)
+	}
+	result, err := parquet2db.ValidateParquetFile(context.Background(), parquetFilePath, tableColumns, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing parquet2db.ValidateParquetFile() : %v", err)
+	}
+	if result.Valid() {
+		l.Info("🚀🚀 the %d rows of %s can be loaded in table %s.%s", result.Rows, parquetFilePath, schemaName, tableName)
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, violation := range result.Violations {
+		if *output == "json" {
+			if err := encoder.Encode(violation); err != nil {
+				l.Fatal("💥💥 error writing the violations: %v", err)
+			}
+			continue
+		}
+		fmt.Println(formatViolation(violation))
+	}
+	l.Warn("%d violations found, %s cannot be loaded in table %s.%s as is", len(result.Violations), parquetFilePath, schemaName, tableName)
+	dbInstance.Close()
+	os.Exit(exitViolations)
+}
+
+// formatViolation returns the text line of a violation
+func formatViolation(violation parquet2db.Violation) string {
+	if violation.Count == 0 {
+		return fmt.Sprintf("%s %s: %s", violation.Kind, violation.Column, violation.Message)
+	}
+	line := fmt.Sprintf("%s %s: %s (%d rows", violation.Kind, violation.Column, violation.Message, violation.Count)
+	if violation.Example != "" {
+		line += fmt.Sprintf(", first value %s", violation.Example)
+	}
+	return line + ")"
+}
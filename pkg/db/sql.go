@@ -118,12 +118,25 @@ WHERE c.relkind IN ('r', 'v', 'm') AND n.nspname NOT IN ('pg_catalog', 'informat
 `
 
 	tableSchema = `
-SELECT column_name as name, data_type, is_nullable::bool as nullable
-        FROM information_schema.columns
+SELECT c.column_name as name, c.data_type, c.is_nullable::bool as nullable,
+       format_type(a.atttypid, a.atttypmod) as pg_type,
+       col_description(a.attrelid, a.attnum) as comment,
+       EXISTS (SELECT 1 FROM pg_constraint pk
+               WHERE pk.conrelid = a.attrelid AND pk.contype = 'p' AND a.attnum = ANY (pk.conkey)) as is_primary_key,
+       (SELECT string_agg(format('%s.%s.%s', rn.nspname, rc.relname, ra.attname), ', ')
+        FROM pg_constraint fk
+                 JOIN LATERAL unnest(fk.conkey, fk.confkey) AS k(attnum, ref_attnum) ON k.attnum = a.attnum
+                 JOIN pg_class rc ON rc.oid = fk.confrelid
+                 JOIN pg_namespace rn ON rn.oid = rc.relnamespace
+                 JOIN pg_attribute ra ON ra.attrelid = fk.confrelid AND ra.attnum = k.ref_attnum
+        WHERE fk.conrelid = a.attrelid AND fk.contype = 'f') as foreign_key
+        FROM information_schema.columns c
+                 JOIN pg_attribute a ON a.attrelid = format('%I.%I', c.table_schema, c.table_name)::regclass
+                                    AND a.attname = c.column_name
         WHERE
-            table_schema = $1
-            AND table_name = $2
-ORDER BY ordinal_position;
+            c.table_schema = $1
+            AND c.table_name = $2
+ORDER BY c.ordinal_position;
 `
 
 	schemasList = `SELECT  DISTINCT(n.nspname) as schema_name FROM pg_class c 
@@ -27,6 +27,12 @@ type ColumnInfo struct {
 	Name     string `json:"name"`
 	DataType string `json:"data_type"`
 	Nullable bool   `json:"nullable"`
+	// PgType is the complete PostgresSQL type with its modifiers, like character varying(50) or numeric(10,2)
+	PgType       string  `json:"pg_type"`
+	Comment      *string `json:"comment"`
+	IsPrimaryKey bool    `json:"is_primary_key"`
+	// ForeignKey lists the referenced schema.table.column separated by a comma
+	ForeignKey *string `json:"foreign_key"`
 }
 
 func GetStorageInstanceOrPanic(dbDriver string, db database.DB, l golog.MyLogger) Storage {
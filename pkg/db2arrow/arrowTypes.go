@@ -0,0 +1,94 @@
+package db2arrow
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// pgTypeSyntax matches the type names written by format_type: a name, optionally schema qualified or quoted,
+// with a typmod, or one of the SQL standard types of several words, followed by the array dimensions, like
+// numeric(12,2), timestamp(3) with time zone, interval day to second(3) or public."My Type"[].
+// No other word is accepted, a type cannot be followed by a clause like NOT NULL or DEFAULT.
+var pgTypeSyntax = regexp.MustCompile(`^(?:` +
+	`(?:double precision|character varying|bit varying)(?:\([0-9]+\))?` +
+	`|(?:timestamp|time)(?:\([0-9]+\))? with(?:out)? time zone` +
+	`|interval (?:year|month|day|hour|minute|second)(?: to (?:month|hour|minute|second))?(?:\([0-9]+\))?` +
+	`|(?:[A-Za-z_][A-Za-z0-9_$]*|"(?:[^"\x00]|"")+")(?:\.(?:[A-Za-z_][A-Za-z0-9_$]*|"(?:[^"\x00]|"")+"))?(?:\([0-9]+(?:, ?[0-9]+)?\))?` +
+	`)(?:\[[0-9]*\])*$`)
+
+// MapArrowDataType converts Apache Arrow data types to PostgresSQL data types, it is the inverse of MapDataType.
+func MapArrowDataType(dt arrow.DataType) (string, error) {
+	switch t := dt.(type) {
+	case *arrow.Int8Type, *arrow.Uint8Type, *arrow.Int16Type:
+		return "smallint", nil
+	case *arrow.Uint16Type, *arrow.Int32Type:
+		return "integer", nil
+	case *arrow.Uint32Type, *arrow.Int64Type:
+		return "bigint", nil
+	case *arrow.Uint64Type:
+		// no unsigned type in PostgresSQL, numeric(20,0) holds the whole uint64 range
+		return "numeric(20,0)", nil
+	case *arrow.Float16Type, *arrow.Float32Type:
+		return "real", nil
+	case *arrow.Float64Type:
+		return "double precision", nil
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("numeric(%d,%d)", t.Precision, t.Scale), nil
+	case *arrow.Decimal256Type:
+		return fmt.Sprintf("numeric(%d,%d)", t.Precision, t.Scale), nil
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		return "text", nil
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.BinaryViewType, *arrow.FixedSizeBinaryType:
+		return "bytea", nil
+	case *arrow.BooleanType:
+		return "boolean", nil
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "date", nil
+	case *arrow.TimestampType:
+		if t.TimeZone != "" {
+			return "timestamp with time zone", nil
+		}
+		return "timestamp without time zone", nil
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return "time without time zone", nil
+	case *arrow.NullType:
+		return "text", nil
+	case *arrow.DictionaryType:
+		return MapArrowDataType(t.ValueType)
+	case *arrow.ListType:
+		return mapArrowListType(t.Elem())
+	case *arrow.LargeListType:
+		return mapArrowListType(t.Elem())
+	case *arrow.FixedSizeListType:
+		return mapArrowListType(t.Elem())
+	default:
+		return "", fmt.Errorf("unsupported Arrow data type: %s", dt)
+	}
+}
+
+// mapArrowListType converts the element type of an Arrow list to a PostgresSQL array type
+func mapArrowListType(elem arrow.DataType) (string, error) {
+	pgType, err := MapArrowDataType(elem)
+	if err != nil {
+		return "", err
+	}
+	return pgType + "[]", nil
+}
+
+// MapArrowField returns the PostgresSQL type of an Arrow field, using the original type stored
+// in the field metadata by MapToArrowSchema when it is present. The metadata comes from the file, a stored
+// type that is not a plain type name (see IsValidPgType) is ignored and the Arrow type is mapped instead.
+func MapArrowField(field arrow.Field) (string, error) {
+	if pgType, found := field.Metadata.GetValue(MetadataKeyPgType); found && IsValidPgType(pgType) {
+		return pgType, nil
+	}
+	return MapArrowDataType(field.Type)
+}
+
+// IsValidPgType returns true when the text is a type name in the syntax of format_type, it cannot hold
+// anything else than the type so that it can be written in a statement
+func IsValidPgType(pgType string) bool {
+	return pgTypeSyntax.MatchString(pgType)
+}
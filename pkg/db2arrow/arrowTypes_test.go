@@ -0,0 +1,58 @@
+package db2arrow
+
+import (
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+func TestIsValidPgType(t *testing.T) {
+	valid := []string{
+		"integer", "double precision", "character varying(255)", "numeric(12,2)", "numeric(12, 2)",
+		"timestamp(3) with time zone", "timestamp without time zone", "interval day to second(3)",
+		"bit varying(5)", "integer[]", "character varying(20)[][]", `"char"`, "public.my_enum",
+		`myschema."Odd ""Type"""`, "time(6) without time zone", "interval", "interval(3)", "interval year to month",
+		"interval minute", "timestamp(3)", "bit(8)",
+	}
+	for _, pgType := range valid {
+		if !IsValidPgType(pgType) {
+			t.Errorf("IsValidPgType(%q) = false, want true", pgType)
+		}
+	}
+	invalid := []string{
+		"", "int); DROP TABLE x; --", "integer; DROP TABLE x", "numeric(12,2) DEFAULT 1", "text COLLATE \"C\"",
+		"integer -- comment", `"unterminated`, "integer\nNOT NULL", "numeric(a)", "int4 CHECK (true)",
+		"integer not null", "text default current_user", "timestamp with time zone not null",
+		"double precision references users", "interval day to second generated always", "text collate c",
+	}
+	for _, pgType := range invalid {
+		if IsValidPgType(pgType) {
+			t.Errorf("IsValidPgType(%q) = true, want false", pgType)
+		}
+	}
+}
+
+func TestMapArrowField(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  arrow.Field
+		pgType string
+	}{
+		{"arrow type", arrow.Field{Name: "a", Type: arrow.PrimitiveTypes.Int64}, "bigint"},
+		{"stored type", arrow.Field{Name: "a", Type: arrow.BinaryTypes.String,
+			Metadata: arrow.NewMetadata([]string{MetadataKeyPgType}, []string{"character varying(20)"})}, "character varying(20)"},
+		{"injected type", arrow.Field{Name: "a", Type: arrow.PrimitiveTypes.Int32,
+			Metadata: arrow.NewMetadata([]string{MetadataKeyPgType}, []string{"int); DROP TABLE x; --"})}, "integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MapArrowField(tt.field)
+			if err != nil {
+				t.Fatalf("MapArrowField() error: %v", err)
+			}
+			if got != tt.pgType {
+				t.Errorf("MapArrowField() = %q, want %q", got, tt.pgType)
+			}
+		})
+	}
+}
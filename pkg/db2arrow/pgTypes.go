@@ -7,6 +7,14 @@ import (
 	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
 )
 
+// Keys of the Arrow field metadata describing the original PostgresSQL column
+const (
+	MetadataKeyPgType     = "pg.type"
+	MetadataKeyComment    = "pg.comment"
+	MetadataKeyPrimaryKey = "pg.primary_key"
+	MetadataKeyForeignKey = "pg.foreign_key"
+)
+
 // MapDataType converts PostgresSQL data types to Apache Arrow data types.
 func MapDataType(pgType string) (arrow.DataType, error) {
 	switch pgType {
@@ -41,16 +49,39 @@ func MapDataType(pgType string) (arrow.DataType, error) {
 
 // MapToArrowSchema creates an Arrow schema from PostgresSQL column metadata.
 func MapToArrowSchema(columns []db.ColumnInfo) (*arrow.Schema, error) {
-	fields := make([]arrow.Field, len(columns))
-	for i, col := range columns {
+	fields := make([]arrow.Field, 0, len(columns))
+	for _, col := range columns {
 		// discard fields with unsupported data types
 		if col.DataType != "tsvector" && col.DataType != "USER-DEFINED" {
 			dt, err := MapDataType(col.DataType)
 			if err != nil {
 				return nil, fmt.Errorf("failed to map column %s: %w", col.Name, err)
 			}
-			fields[i] = arrow.Field{Name: col.Name, Type: dt, Nullable: col.Nullable}
+			fields = append(fields, arrow.Field{Name: col.Name, Type: dt, Nullable: col.Nullable, Metadata: columnMetadata(col)})
 		}
 	}
 	return arrow.NewSchema(fields, nil), nil
 }
+
+// columnMetadata returns the Arrow field metadata with the original type, comment and keys of the column
+func columnMetadata(col db.ColumnInfo) arrow.Metadata {
+	pgType := col.PgType
+	if pgType == "" {
+		pgType = col.DataType
+	}
+	keys := []string{MetadataKeyPgType}
+	values := []string{pgType}
+	if col.Comment != nil {
+		keys = append(keys, MetadataKeyComment)
+		values = append(values, *col.Comment)
+	}
+	if col.IsPrimaryKey {
+		keys = append(keys, MetadataKeyPrimaryKey)
+		values = append(values, "true")
+	}
+	if col.ForeignKey != nil {
+		keys = append(keys, MetadataKeyForeignKey)
+		values = append(values, *col.ForeignKey)
+	}
+	return arrow.NewMetadata(keys, values)
+}
@@ -0,0 +1,124 @@
+package db2parquet
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/jackc/pgx/v5"
+)
+
+// newBuilders returns one Arrow builder for each field of the given schema
+func newBuilders(mem memory.Allocator, schema *arrow.Schema) []array.Builder {
+	builders := make([]array.Builder, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		builders[i] = array.NewBuilder(mem, field.Type)
+	}
+	return builders
+}
+
+// releaseBuilders releases the builders and the buffers of the values they still hold
+func releaseBuilders(builders []array.Builder) {
+	for _, builder := range builders {
+		builder.Release()
+	}
+}
+
+// newRecordFromBuilders creates an Arrow RecordBatch with the content of the builders, which are reset
+func newRecordFromBuilders(schema *arrow.Schema, builders []array.Builder, numRows int) arrow.Record {
+	arrays := make([]arrow.Array, len(builders))
+	for i, builder := range builders {
+		arrays[i] = builder.NewArray()
+	}
+	record := array.NewRecord(schema, arrays, int64(numRows))
+	for _, arr := range arrays {
+		arr.Release()
+	}
+	return record
+}
+
+// selectTableQuery returns the sql query used to retrieve all the columns of the arrow schema from the table
+func selectTableQuery(schemaName string, tableName string, schema *arrow.Schema) string {
+	columns := make([]string, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		columns[i] = pgx.Identifier{field.Name}.Sanitize()
+	}
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), pgx.Identifier{schemaName, tableName}.Sanitize())
+}
+
+// appendValue appends a value returned by pgx to the Arrow builder of the given field
+func appendValue(builder array.Builder, field arrow.Field, val interface{}) error {
+	if val == nil {
+		builder.AppendNull()
+		return nil
+	}
+	switch b := builder.(type) {
+	case *array.Int16Builder:
+		v, ok := val.(int16)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected int16", field.Name)
+		}
+		b.Append(v)
+	case *array.Int32Builder:
+		v, ok := val.(int32)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected int32", field.Name)
+		}
+		b.Append(v)
+	case *array.Int64Builder:
+		v, ok := val.(int64)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected int64", field.Name)
+		}
+		b.Append(v)
+	case *array.Float32Builder:
+		v, ok := val.(float32)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected float32", field.Name)
+		}
+		b.Append(v)
+	case *array.Float64Builder:
+		v, ok := val.(float64)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected float64", field.Name)
+		}
+		b.Append(v)
+	case *array.StringBuilder:
+		v, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected string", field.Name)
+		}
+		b.Append(v)
+	case *array.BinaryBuilder:
+		v, ok := val.([]byte)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected []byte", field.Name)
+		}
+		b.Append(v)
+	case *array.BooleanBuilder:
+		v, ok := val.(bool)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected bool", field.Name)
+		}
+		b.Append(v)
+	case *array.Date32Builder:
+		v, ok := val.(time.Time)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected time.Time", field.Name)
+		}
+		days := int32(v.Sub(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+		b.Append(arrow.Date32(days))
+	case *array.TimestampBuilder:
+		v, ok := val.(time.Time)
+		if !ok {
+			return fmt.Errorf("type mismatch for %s: expected time.Time", field.Name)
+		}
+		b.Append(arrow.Timestamp(v.UnixNano() / 1000)) // Microseconds
+	default:
+		return fmt.Errorf("unsupported type for column %s", field.Name)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package db2parquet
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/csv"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// CsvOptions defines the layout of the CSV files, they are also used for the timestamps of NDJSON files
+type CsvOptions struct {
+	// Delimiter separates the fields, the default is a comma
+	Delimiter rune
+	// Header writes the column names in the first line
+	Header bool
+	// NullString is written for the NULL values, the default is an empty string
+	NullString string
+	// TimestampLayout is the Go time layout of the timestamps, the default is "2006-01-02 15:04:05.999999999"
+	TimestampLayout string
+}
+
+// csvRecordWriter writes the records in a CSV file
+type csvRecordWriter struct {
+	writer  *csv.Writer
+	schema  *arrow.Schema
+	header  bool
+	written bool
+	mem     memory.Allocator
+}
+
+func newCsvRecordWriter(w io.Writer, schema *arrow.Schema, options CsvOptions, mem memory.Allocator) *csvRecordWriter {
+	delimiter := options.Delimiter
+	if delimiter == 0 {
+		delimiter = ','
+	}
+	opts := []csv.Option{
+		csv.WithComma(delimiter),
+		csv.WithHeader(options.Header),
+		csv.WithNullWriter(options.NullString),
+	}
+	if options.TimestampLayout != "" {
+		opts = append(opts, csv.WithCustomTypeConverter(func(typ arrow.DataType, col arrow.Array) ([]string, bool) {
+			ts, ok := col.(*array.Timestamp)
+			if !ok {
+				return nil, false
+			}
+			result := make([]string, ts.Len())
+			for i := range result {
+				if ts.IsNull(i) {
+					result[i] = options.NullString
+				} else {
+					result[i] = formatTimestamp(ts, i, options.TimestampLayout)
+				}
+			}
+			return result, true
+		}))
+	}
+	return &csvRecordWriter{writer: csv.NewWriter(w, schema, opts...), schema: schema, header: options.Header, mem: mem}
+}
+
+func (c *csvRecordWriter) Write(record arrow.Record) error {
+	c.written = true
+	if err := c.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write RecordBatch in CSV: %w", err)
+	}
+	return nil
+}
+
+func (c *csvRecordWriter) Close() error {
+	if c.header && !c.written {
+		// the CSV writer writes the header with the first record, an empty table still gets its header
+		record := emptyRecord(c.mem, c.schema)
+		defer record.Release()
+		if err := c.Write(record); err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	if err := c.writer.Error(); err != nil {
+		return fmt.Errorf("failed to flush CSV writer: %w", err)
+	}
+	return nil
+}
+
+// formatTimestamp returns the timestamp at index i with the Go time layout
+func formatTimestamp(ts *array.Timestamp, i int, layout string) string {
+	return ts.Value(i).ToTime(ts.DataType().(*arrow.TimestampType).Unit).Format(layout)
+}
+
+// emptyRecord returns a RecordBatch without rows
+func emptyRecord(mem memory.Allocator, schema *arrow.Schema) arrow.Record {
+	columns := make([]arrow.Array, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		columns[i] = array.MakeArrayOfNull(mem, field.Type, 0)
+		defer columns[i].Release()
+	}
+	return array.NewRecord(schema, columns, 0)
+}
@@ -0,0 +1,85 @@
+package db2parquet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	cursorName         = "convert_cursor"
+	cursorCloseTimeout = 5 * time.Second
+)
+
+// fetchRowsWithCursor declares a cursor for the given query (using args as parameters) inside the transaction
+// and fetch the rows in batches of the size returned by nextBatchSize before each FETCH.
+// handleRow is called for every row and handleBatch at the end of every non-empty batch with the number of rows in it.
+// When an error stops the fetch, the cursor is closed if the transaction is still usable.
+func fetchRowsWithCursor(
+	ctx context.Context,
+	tx pgx.Tx,
+	query string,
+	args []interface{},
+	nextBatchSize func() int,
+	log golog.MyLogger,
+	handleRow func(values []interface{}) error,
+	handleBatch func(numRows int) error) (err error) {
+	_, err = tx.Exec(ctx, fmt.Sprintf("DECLARE %s CURSOR FOR %s", cursorName, query), args...)
+	if err != nil {
+		return fmt.Errorf("failed to declare cursor: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			// the context may be canceled, the cursor is closed with a context that is not
+			closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cursorCloseTimeout)
+			defer cancel()
+			tx.Exec(closeCtx, fmt.Sprintf("CLOSE %s", cursorName))
+		}
+	}()
+	batchNumber := 0
+	for {
+		batchNumber++
+		batchSize := nextBatchSize()
+		rows, err := tx.Query(ctx, fmt.Sprintf("FETCH %d FROM %s", batchSize, cursorName))
+		if err != nil {
+			return fmt.Errorf("failed to fetch from cursor: %w", err)
+		}
+		log.Debug("Fetched batch %d of %d rows", batchNumber, batchSize)
+		rowCount := 0
+		for rows.Next() {
+			rowCount++
+			values, err := rows.Values()
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to get row values: %w", err)
+			}
+			if err := handleRow(values); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error processing rows: %w", err)
+		}
+		// Exit if no rows were fetched (end of data)
+		if rowCount == 0 {
+			break
+		}
+		if err := handleBatch(rowCount); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf("CLOSE %s", cursorName)); err != nil {
+		return fmt.Errorf("failed to close cursor: %w", err)
+	}
+	return nil
+}
+
+// fixedBatchSize returns a nextBatchSize function always fetching batchSize rows
+func fixedBatchSize(batchSize int) func() int {
+	return func() int { return batchSize }
+}
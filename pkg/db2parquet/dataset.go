@@ -0,0 +1,479 @@
+package db2parquet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/metadata"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	// HiveDefaultPartition is the directory value used by Hive, Spark and DuckDB for NULL partition values
+	HiveDefaultPartition   = "__HIVE_DEFAULT_PARTITION__"
+	CommonMetadataFileName = "_common_metadata"
+	MetadataFileName       = "_metadata"
+	partFileNameFormat     = "part-%04d.parquet"
+	parquetMagic           = "PAR1"
+)
+
+// DatasetOptions defines how a table is written as a directory tree of parquet files
+type DatasetOptions struct {
+	// PartitionBy lists the columns used to build the hive style partition directories (col=value)
+	PartitionBy []string
+	// MaxRowsPerFile starts a new file in the partition when reached, 0 means no limit
+	MaxRowsPerFile int64
+	// MaxBytesPerFile starts a new file in the partition when reached, 0 means no limit
+	MaxBytesPerFile int64
+	// WriteMetadataFiles adds the _common_metadata and _metadata summary files at the root of the dataset
+	WriteMetadataFiles bool
+	// Memory defines the allocator of the partition buffers and the memory budget used to size the fetches
+	Memory MemoryOptions
+	// Progress receives the rows fetched and the bytes written in the files of the dataset
+	Progress ProgressFunc
+	// Encryption encrypts each file of the dataset with the same keys, it excludes WriteMetadataFiles
+	Encryption *EncryptionOptions
+}
+
+// CreateParquetDatasetFromDbTable create a directory of parquet files from a db schema and table,
+// partitioned by the columns listed in options.PartitionBy, the files already written are removed when it fails
+func CreateParquetDatasetFromDbTable(
+	ctx context.Context,
+	dbConn *pgxpool.Pool,
+	schemaName string,
+	tableName string,
+	tableColumns []db.ColumnInfo,
+	datasetDir string,
+	batchSize int,
+	options DatasetOptions,
+	log golog.MyLogger) error {
+	schema, err := db2arrow.MapToArrowSchema(tableColumns)
+	if err != nil {
+		return fmt.Errorf("error doing db2arrow.MapToArrowSchema() : %v", err)
+	}
+	dw, err := newDatasetWriter(ctx, datasetDir, schema, batchSize, options, options.Memory.allocator(), log)
+	if err != nil {
+		return err
+	}
+	completed := false
+	defer func() {
+		dw.release()
+		if !completed {
+			dw.removeFiles()
+		}
+	}()
+	log.Info("Dataset writer created in %s for table %s.%s", datasetDir, schemaName, tableName)
+
+	tx, err := dbConn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func(tx pgx.Tx, ctx context.Context) {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Error("failed to rollback transaction: %v", err)
+		}
+	}(tx, context.WithoutCancel(ctx)) // Rollback if not committed, even when the export was canceled
+
+	if dw.kvMetadata, err = exportMetadata(ctx, tx, schemaName, tableName); err != nil {
+		return err
+	}
+	progress, err := newProgressTracker(ctx, tx, schemaName, tableName, options.Progress, true)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		progress.bytes = dw.bytesWritten
+	}
+	query := selectTableQuery(schemaName, tableName, schema)
+	err = fetchRowsWithCursor(ctx, tx, query, nil, dw.sizer.next, log, dw.writeRow, func(numRows int) error {
+		progress.add(int64(numRows))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("All rows processed for table %s.%s", schemaName, tableName)
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if err := dw.close(); err != nil {
+		return err
+	}
+	completed = true
+	progress.done()
+	log.Info("Dataset %s closed with %d files for table %s.%s", datasetDir, len(dw.files), schemaName, tableName)
+	return nil
+}
+
+// datasetFile is a parquet file written in the dataset with its path relative to the dataset root
+type datasetFile struct {
+	relPath  string
+	metadata *metadata.FileMetaData
+}
+
+// partitionWriter holds the buffered rows and the currently open parquet file of one partition
+type partitionWriter struct {
+	relDir     string
+	builders   []array.Builder
+	numRows    int
+	partNumber int
+	out        sink.Sink
+	counter    *countingWriter
+	writer     *pqarrow.FileWriter
+	fileName   string
+	fileRows   int64
+}
+
+type datasetWriter struct {
+	ctx              context.Context
+	dir              string
+	schema           *arrow.Schema
+	dataSchema       *arrow.Schema
+	partitionColumns []int
+	dataColumns      []int
+	batchSize        int
+	options          DatasetOptions
+	mem              memory.Allocator
+	sizer            *fetchSizer
+	partitions       map[string]*partitionWriter
+	files            []datasetFile
+	closedBytes      int64
+	kvMetadata       map[string]string
+	log              golog.MyLogger
+}
+
+func newDatasetWriter(ctx context.Context, dir string, schema *arrow.Schema, batchSize int, options DatasetOptions, mem memory.Allocator, log golog.MyLogger) (*datasetWriter, error) {
+	if err := options.Encryption.check(); err != nil {
+		return nil, err
+	}
+	if options.Encryption != nil && options.WriteMetadataFiles {
+		// the summary files would hold the footers of the encrypted files in clear
+		return nil, errors.New("the _metadata summary files cannot be written for an encrypted dataset")
+	}
+	dw := &datasetWriter{
+		ctx:        ctx,
+		dir:        dir,
+		schema:     schema,
+		batchSize:  batchSize,
+		options:    options,
+		mem:        mem,
+		sizer:      newFetchSizer(batchSize, options.Memory.Budget, 1),
+		partitions: make(map[string]*partitionWriter),
+		log:        log,
+	}
+	for _, name := range options.PartitionBy {
+		indices := schema.FieldIndices(name)
+		if len(indices) == 0 {
+			return nil, fmt.Errorf("partition column %s does not exist in table", name)
+		}
+		dw.partitionColumns = append(dw.partitionColumns, indices[0])
+	}
+	var dataFields []arrow.Field
+	for i, field := range schema.Fields() {
+		if !slices.Contains(dw.partitionColumns, i) {
+			dw.dataColumns = append(dw.dataColumns, i)
+			dataFields = append(dataFields, field)
+		}
+	}
+	if len(dataFields) == 0 {
+		return nil, fmt.Errorf("at least one column must not be a partition column")
+	}
+	dw.dataSchema = arrow.NewSchema(dataFields, nil)
+	if err := sink.MkdirAll(dir); err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+// writeRow appends the values of one row fetched from the table to the builders of its partition
+func (dw *datasetWriter) writeRow(values []interface{}) error {
+	relDir := dw.partitionPath(values)
+	pw, found := dw.partitions[relDir]
+	if !found {
+		pw = &partitionWriter{relDir: relDir, builders: newBuilders(dw.mem, dw.dataSchema)}
+		dw.partitions[relDir] = pw
+	}
+	for i, col := range dw.dataColumns {
+		if err := appendValue(pw.builders[i], dw.schema.Field(col), values[col]); err != nil {
+			return err
+		}
+	}
+	pw.numRows++
+	if pw.numRows >= dw.flushThreshold(pw) {
+		return dw.flush(pw)
+	}
+	return nil
+}
+
+// flushThreshold returns the number of buffered rows that triggers a write, never crossing MaxRowsPerFile
+func (dw *datasetWriter) flushThreshold(pw *partitionWriter) int {
+	threshold := int64(dw.batchSize)
+	if dw.options.MaxRowsPerFile > 0 && dw.options.MaxRowsPerFile-pw.fileRows < threshold {
+		threshold = dw.options.MaxRowsPerFile - pw.fileRows
+	}
+	return int(threshold)
+}
+
+// flush writes the buffered rows of the partition as a RecordBatch and rolls over to a new file when a limit is reached
+func (dw *datasetWriter) flush(pw *partitionWriter) error {
+	if pw.numRows == 0 {
+		return nil
+	}
+	if pw.writer == nil {
+		if err := dw.openFile(pw); err != nil {
+			return err
+		}
+	}
+	record := newRecordFromBuilders(dw.dataSchema, pw.builders, pw.numRows)
+	defer record.Release()
+	dw.sizer.observe(record)
+	if err := pw.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write RecordBatch in %s: %w", pw.fileName, err)
+	}
+	pw.fileRows += int64(pw.numRows)
+	pw.numRows = 0
+	if (dw.options.MaxRowsPerFile > 0 && pw.fileRows >= dw.options.MaxRowsPerFile) ||
+		(dw.options.MaxBytesPerFile > 0 && pw.counter.n >= dw.options.MaxBytesPerFile) {
+		return dw.closeFile(pw)
+	}
+	return nil
+}
+
+func (dw *datasetWriter) openFile(pw *partitionWriter) error {
+	dir := sink.Join(dw.dir, pw.relDir)
+	if err := sink.MkdirAll(dir); err != nil {
+		return err
+	}
+	pw.fileName = fmt.Sprintf(partFileNameFormat, pw.partNumber)
+	pw.partNumber++
+	filePath := sink.Join(dir, pw.fileName)
+	out, err := sink.Open(dw.ctx, filePath)
+	if err != nil {
+		return err
+	}
+	pw.out = out
+	pw.counter = &countingWriter{w: out}
+	props := append([]parquet.WriterProperty{parquet.WithAllocator(dw.mem)}, dw.options.Encryption.writerProperties()...)
+	pw.writer, err = pqarrow.NewFileWriter(dw.dataSchema, pw.counter, parquet.NewWriterProperties(props...), newArrowWriterProperties())
+	if err != nil {
+		out.Abort()
+		return fmt.Errorf("failed to create Parquet writer for %s: %w", filePath, err)
+	}
+	pw.fileRows = 0
+	dw.log.Info("Parquet file %s created", filePath)
+	return nil
+}
+
+func (dw *datasetWriter) closeFile(pw *partitionWriter) error {
+	if pw.writer == nil {
+		return nil
+	}
+	writer, out, counter := pw.writer, pw.out, pw.counter
+	// the rows of the next file are counted from zero, flushThreshold would stay at 0 after a rollover otherwise
+	pw.writer, pw.out, pw.counter, pw.fileRows = nil, nil, nil, 0
+	if err := appendKeyValueMetadata(writer, dw.kvMetadata); err != nil {
+		writer.Close()
+		out.Abort()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Abort()
+		return fmt.Errorf("failed to close Parquet writer for %s: %w", pw.fileName, err)
+	}
+	if err := out.Commit(); err != nil {
+		return err
+	}
+	dw.closedBytes += counter.n
+	md, err := writer.FileMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to get metadata of Parquet file %s: %w", pw.fileName, err)
+	}
+	dw.files = append(dw.files, datasetFile{relPath: path.Join(pw.relDir, pw.fileName), metadata: md})
+	return nil
+}
+
+// close flushes and closes every partition, then writes the summary metadata files if requested
+func (dw *datasetWriter) close() error {
+	keys := make([]string, 0, len(dw.partitions))
+	for key := range dw.partitions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pw := dw.partitions[key]
+		if err := dw.flush(pw); err != nil {
+			return err
+		}
+		if err := dw.closeFile(pw); err != nil {
+			return err
+		}
+	}
+	if dw.options.WriteMetadataFiles && len(dw.files) > 0 {
+		return dw.writeMetadataFiles()
+	}
+	return nil
+}
+
+// bytesWritten returns the size of the closed files and of the data already written in the open ones
+func (dw *datasetWriter) bytesWritten() int64 {
+	n := dw.closedBytes
+	for _, pw := range dw.partitions {
+		if pw.counter != nil {
+			n += pw.counter.n
+		}
+	}
+	return n
+}
+
+// release frees the builders and discards any file left open after an error
+func (dw *datasetWriter) release() {
+	for _, pw := range dw.partitions {
+		if pw.writer != nil {
+			pw.writer.Close()
+			pw.out.Abort()
+			pw.writer = nil
+		}
+		for _, b := range pw.builders {
+			b.Release()
+		}
+		pw.builders = nil
+	}
+}
+
+// removeFiles deletes the files already closed and the metadata files, a failed export leaves no partial dataset
+func (dw *datasetWriter) removeFiles() {
+	if len(dw.files) == 0 {
+		return
+	}
+	// the export may have failed because its context was canceled, the files are removed anyway
+	ctx := context.WithoutCancel(dw.ctx)
+	locations := []string{sink.Join(dw.dir, MetadataFileName), sink.Join(dw.dir, CommonMetadataFileName)}
+	for _, f := range dw.files {
+		locations = append(locations, sink.Join(dw.dir, f.relPath))
+	}
+	for _, location := range locations {
+		if err := sink.Remove(ctx, location); err != nil {
+			dw.log.Error("failed to remove %s: %v", location, err)
+		}
+	}
+	dw.log.Warn("%d files of the failed export removed from dataset %s", len(dw.files), dw.dir)
+	dw.files = nil
+}
+
+// writeMetadataFiles writes _common_metadata (schema only) and _metadata (row groups of every file)
+func (dw *datasetWriter) writeMetadataFiles() error {
+	common, err := dw.files[0].metadata.Subset([]int{})
+	if err != nil {
+		return err
+	}
+	if err := writeMetadataFile(dw.ctx, sink.Join(dw.dir, CommonMetadataFileName), common); err != nil {
+		return err
+	}
+	summary, err := dw.files[0].metadata.Subset([]int{})
+	if err != nil {
+		return err
+	}
+	for _, f := range dw.files {
+		f.metadata.SetFilePath(f.relPath)
+		if err := summary.AppendRowGroups(f.metadata); err != nil {
+			return fmt.Errorf("failed to append row groups of %s to %s: %w", f.relPath, MetadataFileName, err)
+		}
+	}
+	return writeMetadataFile(dw.ctx, sink.Join(dw.dir, MetadataFileName), summary)
+}
+
+// partitionPath returns the relative hive style directory (col1=value1/col2=value2) of the row
+func (dw *datasetWriter) partitionPath(values []interface{}) string {
+	if len(dw.partitionColumns) == 0 {
+		return ""
+	}
+	parts := make([]string, len(dw.partitionColumns))
+	for i, col := range dw.partitionColumns {
+		field := dw.schema.Field(col)
+		parts[i] = escapePartitionValue(field.Name) + "=" + partitionValue(field, values[col])
+	}
+	return strings.Join(parts, "/")
+}
+
+// partitionValue formats a column value as used in hive style directory names
+func partitionValue(field arrow.Field, val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return HiveDefaultPartition
+	case time.Time:
+		if field.Type.ID() == arrow.DATE32 {
+			return escapePartitionValue(v.Format(time.DateOnly))
+		}
+		return escapePartitionValue(v.UTC().Format("2006-01-02 15:04:05.999999"))
+	default:
+		return escapePartitionValue(fmt.Sprintf("%v", v))
+	}
+}
+
+// escapePartitionValue escapes the characters that Hive does not allow in partition directory names
+func escapePartitionValue(value string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// writeMetadataFile writes a parquet file containing only a footer with the given metadata
+func writeMetadataFile(ctx context.Context, filePath string, md *metadata.FileMetaData) error {
+	file, err := sink.Open(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+	if _, err := io.WriteString(file, parquetMagic); err != nil {
+		return fmt.Errorf("failed to write metadata file %s: %w", filePath, err)
+	}
+	n, err := md.WriteTo(file, nil)
+	if err != nil {
+		return fmt.Errorf("failed to write metadata file %s: %w", filePath, err)
+	}
+	if err := binary.Write(file, binary.LittleEndian, uint32(n)); err != nil {
+		return fmt.Errorf("failed to write metadata file %s: %w", filePath, err)
+	}
+	if _, err := io.WriteString(file, parquetMagic); err != nil {
+		return fmt.Errorf("failed to write metadata file %s: %w", filePath, err)
+	}
+	return file.Commit()
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,116 @@
+package db2parquet
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+func newTestLogger(t *testing.T) golog.MyLogger {
+	t.Helper()
+	l, err := golog.NewLogger("zap", golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	return l
+}
+
+func TestDatasetWriterRollover(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+	options := DatasetOptions{MaxRowsPerFile: 5}
+	dw, err := newDatasetWriter(context.Background(), t.TempDir(), schema, 3, options, mem, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("newDatasetWriter() error: %v", err)
+	}
+	defer dw.release()
+	for i := 0; i < 12; i++ {
+		if err := dw.writeRow([]interface{}{int64(i), "row"}); err != nil {
+			t.Fatalf("writeRow(%d) error: %v", i, err)
+		}
+	}
+	if err := dw.close(); err != nil {
+		t.Fatalf("close() error: %v", err)
+	}
+
+	// every file starts with a full batch, the rollover does not leave a one row group behind
+	want := [][]int64{{3, 2}, {3, 2}, {2}}
+	if len(dw.files) != len(want) {
+		t.Fatalf("got %d files, want %d", len(dw.files), len(want))
+	}
+	for i, file := range dw.files {
+		var rowGroups []int64
+		for rg := 0; rg < file.metadata.NumRowGroups(); rg++ {
+			rowGroups = append(rowGroups, file.metadata.RowGroup(rg).NumRows())
+		}
+		if !slices.Equal(rowGroups, want[i]) {
+			t.Errorf("file %s has row groups of %v rows, want %v", file.relPath, rowGroups, want[i])
+		}
+	}
+}
+
+func TestFlushThreshold(t *testing.T) {
+	tests := []struct {
+		name           string
+		batchSize      int
+		maxRowsPerFile int64
+		fileRows       int64
+		want           int
+	}{
+		{"no file limit", 100, 0, 250, 100},
+		{"room for a batch", 100, 1000, 200, 100},
+		{"end of the file", 100, 1000, 950, 50},
+		{"new file", 100, 1000, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dw := &datasetWriter{batchSize: tt.batchSize, options: DatasetOptions{MaxRowsPerFile: tt.maxRowsPerFile}}
+			if got := dw.flushThreshold(&partitionWriter{fileRows: tt.fileRows}); got != tt.want {
+				t.Errorf("flushThreshold() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatasetWriterRemoveFiles(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	dir := t.TempDir()
+	options := DatasetOptions{MaxRowsPerFile: 2, WriteMetadataFiles: true}
+	dw, err := newDatasetWriter(context.Background(), dir, schema, 2, options, mem, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("newDatasetWriter() error: %v", err)
+	}
+	defer dw.release()
+	for i := 0; i < 5; i++ {
+		if err := dw.writeRow([]interface{}{int64(i)}); err != nil {
+			t.Fatalf("writeRow(%d) error: %v", i, err)
+		}
+	}
+	if err := dw.close(); err != nil {
+		t.Fatalf("close() error: %v", err)
+	}
+	if len(dw.files) != 3 {
+		t.Fatalf("got %d files, want 3", len(dw.files))
+	}
+
+	// like an export failing after the files were closed, none of them stays in the dataset
+	dw.removeFiles()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error: %v", err)
+	}
+	for _, entry := range entries {
+		t.Errorf("%s left in the dataset after removeFiles()", entry.Name())
+	}
+}
@@ -0,0 +1,163 @@
+package db2parquet
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+)
+
+const (
+	// keySpecEnvPrefix reads a key from an environment variable: env:NAME
+	keySpecEnvPrefix = "env:"
+	// keySpecFilePrefix reads a key from a file: file:/path/to/key
+	keySpecFilePrefix = "file:"
+)
+
+// EncryptionOptions defines the keys of the Parquet modular encryption (AES-GCM).
+// The keys are 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+type EncryptionOptions struct {
+	// FooterKey encrypts the footer, or only signs it with PlaintextFooter, and the columns without a key of their own
+	FooterKey string
+	// ColumnKeys maps a column path (col or parent.child) to its key, when not empty only these columns are encrypted
+	ColumnKeys map[string]string
+	// PlaintextFooter keeps the footer readable by the readers without the keys, the schema and the statistics
+	// of the columns that are not encrypted stay visible, it is signed with the footer key to detect tampering
+	PlaintextFooter bool
+}
+
+// ParseColumnKeys parses a comma separated list of column:keyspec, the key specs are read with LoadEncryptionKey
+func ParseColumnKeys(list string) (map[string]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	keys := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		column, spec, found := strings.Cut(strings.TrimSpace(item), ":")
+		if !found || column == "" || spec == "" {
+			return nil, fmt.Errorf("invalid column key %q, expected column:env:NAME or column:file:path", item)
+		}
+		key, err := LoadEncryptionKey(spec)
+		if err != nil {
+			return nil, fmt.Errorf("key of column %s: %w", column, err)
+		}
+		keys[column] = key
+	}
+	return keys, nil
+}
+
+// LoadEncryptionKey returns the key of a spec env:NAME (environment variable) or file:path.
+// The value is decoded from hex or base64, or used as is when it is already 16, 24 or 32 bytes long.
+func LoadEncryptionKey(spec string) (string, error) {
+	var raw []byte
+	switch {
+	case strings.HasPrefix(spec, keySpecEnvPrefix):
+		name := strings.TrimPrefix(spec, keySpecEnvPrefix)
+		value, found := os.LookupEnv(name)
+		if !found {
+			return "", fmt.Errorf("environment variable %s of the encryption key is not set", name)
+		}
+		raw = []byte(value)
+	case strings.HasPrefix(spec, keySpecFilePrefix):
+		content, err := os.ReadFile(strings.TrimPrefix(spec, keySpecFilePrefix))
+		if err != nil {
+			return "", fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		raw = content
+	default:
+		return "", fmt.Errorf("invalid key %q, expected env:NAME or file:path", spec)
+	}
+	return decodeEncryptionKey(raw)
+}
+
+// decodeEncryptionKey tries hex then base64 on the trimmed text, then the raw bytes
+func decodeEncryptionKey(raw []byte) (string, error) {
+	text := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(text); err == nil && validKeyLength(len(key)) {
+		return string(key), nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && validKeyLength(len(key)) {
+		return string(key), nil
+	}
+	if validKeyLength(len(raw)) {
+		return string(raw), nil
+	}
+	return "", errors.New("the encryption key must be 16, 24 or 32 bytes, given raw or encoded in hex or base64")
+}
+
+func validKeyLength(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// check returns an error when a key is missing or has an invalid length
+func (e *EncryptionOptions) check() error {
+	if e == nil {
+		return nil
+	}
+	if !validKeyLength(len(e.FooterKey)) {
+		return errors.New("the footer encryption key must be 16, 24 or 32 bytes")
+	}
+	for column, key := range e.ColumnKeys {
+		if !validKeyLength(len(key)) {
+			return fmt.Errorf("the encryption key of column %s must be 16, 24 or 32 bytes", column)
+		}
+	}
+	return nil
+}
+
+// writerProperties returns the encryption properties of one parquet file, nil without encryption.
+// The properties are built for each file since the writer wipes their keys once the file is closed.
+func (e *EncryptionOptions) writerProperties() []parquet.WriterProperty {
+	if e == nil {
+		return nil
+	}
+	var opts []parquet.EncryptOption
+	if e.PlaintextFooter {
+		opts = append(opts, parquet.WithPlaintextFooter())
+	}
+	if len(e.ColumnKeys) > 0 {
+		columns := make(parquet.ColumnPathToEncryptionPropsMap, len(e.ColumnKeys))
+		for _, column := range sortedKeys(e.ColumnKeys) {
+			columns[column] = parquet.NewColumnEncryptionProperties(column, parquet.WithKey(e.ColumnKeys[column]))
+		}
+		opts = append(opts, parquet.WithEncryptedColumns(columns))
+	}
+	return []parquet.WriterProperty{parquet.WithEncryptionProperties(parquet.NewFileEncryptionProperties(e.FooterKey, opts...))}
+}
+
+// ReaderProperties returns the parquet reader properties decrypting the files written with these options,
+// a nil receiver returns the default properties
+func (e *EncryptionOptions) ReaderProperties(mem memory.Allocator) *parquet.ReaderProperties {
+	props := parquet.NewReaderProperties(mem)
+	if e == nil {
+		return props
+	}
+	var opts []parquet.FileDecryptionOption
+	if e.FooterKey != "" {
+		opts = append(opts, parquet.WithFooterKey(e.FooterKey))
+	}
+	if len(e.ColumnKeys) > 0 {
+		columns := make(parquet.ColumnPathToDecryptionPropsMap, len(e.ColumnKeys))
+		for _, column := range sortedKeys(e.ColumnKeys) {
+			columns[column] = parquet.NewColumnDecryptionProperties(column, parquet.WithDecryptKey(e.ColumnKeys[column]))
+		}
+		opts = append(opts, parquet.WithColumnKeys(columns))
+	}
+	props.FileDecryptProps = parquet.NewFileDecryptionProperties(opts...)
+	return props
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,325 @@
+package db2parquet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	// DefaultStateFileName is the name of the incremental state file created in the output directory
+	DefaultStateFileName = "_incremental_state.json"
+	xidModulo            = 1 << 32
+)
+
+var partFileNameRegexp = regexp.MustCompile(`^part-(\d+)\.parquet$`)
+
+// IncrementalOptions defines how the new rows of a table are detected since the last export
+type IncrementalOptions struct {
+	// WatermarkColumn is a monotonic column (updated_at, serial id) used to find the rows newer than the last export
+	WatermarkColumn string
+	// UseXmin uses the transaction id (xmin) of the rows instead of a column, it detects inserted and updated rows
+	UseXmin bool
+	// StateFilePath is the json file keeping the last watermark, default to _incremental_state.json in the output directory
+	StateFilePath string
+	// Memory defines the allocator and the memory budget of the export
+	Memory MemoryOptions
+	// Progress receives the rows and bytes written, the number of new rows is not estimated
+	Progress ProgressFunc
+	// Encryption encrypts the part files, the same keys are needed to resume from the footer of the last one
+	Encryption *EncryptionOptions
+}
+
+// IncrementalState is the content of the state file kept between two incremental exports
+type IncrementalState struct {
+	SchemaName      string    `json:"schema_name"`
+	TableName       string    `json:"table_name"`
+	WatermarkColumn string    `json:"watermark_column,omitempty"`
+	Watermark       *string   `json:"watermark,omitempty"`
+	XminHorizon     uint64    `json:"xmin_horizon,omitempty"`
+	LastFile        string    `json:"last_file,omitempty"`
+	LastRowCount    int64     `json:"last_row_count"`
+	ExportedAt      time.Time `json:"exported_at"`
+}
+
+// CreateIncrementalParquetFileFromDbTable exports in a new part file of outputDir only the rows of the table
+// that are newer than the watermark kept in the state file, then saves the new watermark in the state file.
+// The watermark is also stored in the key-value metadata of the part file, so an export that failed before
+// saving the state file is resumed from the last complete part file.
+// In xmin mode the rows are exported at least once, a row updated during an export can be exported again by the next one.
+func CreateIncrementalParquetFileFromDbTable(
+	ctx context.Context,
+	dbConn *pgxpool.Pool,
+	schemaName string,
+	tableName string,
+	tableColumns []db.ColumnInfo,
+	outputDir string,
+	batchSize int,
+	options IncrementalOptions,
+	log golog.MyLogger) (*IncrementalState, error) {
+	if (options.WatermarkColumn == "") == !options.UseXmin {
+		return nil, errors.New("incremental export needs either a watermark column or the xmin mode")
+	}
+	schema, err := db2arrow.MapToArrowSchema(tableColumns)
+	if err != nil {
+		return nil, fmt.Errorf("error doing db2arrow.MapToArrowSchema() : %v", err)
+	}
+	watermarkType := ""
+	if options.WatermarkColumn != "" {
+		for _, col := range tableColumns {
+			if col.Name == options.WatermarkColumn {
+				watermarkType = col.DataType
+			}
+		}
+		if watermarkType == "" {
+			return nil, fmt.Errorf("watermark column %s does not exist in table %s.%s", options.WatermarkColumn, schemaName, tableName)
+		}
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
+	}
+	stateFilePath := options.StateFilePath
+	if stateFilePath == "" {
+		stateFilePath = filepath.Join(outputDir, DefaultStateFileName)
+	}
+	state, err := LoadIncrementalState(stateFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &IncrementalState{SchemaName: schemaName, TableName: tableName, WatermarkColumn: options.WatermarkColumn}
+	} else if state.SchemaName != schemaName || state.TableName != tableName || state.WatermarkColumn != options.WatermarkColumn {
+		return nil, fmt.Errorf("state file %s belongs to %s.%s with watermark column '%s'",
+			stateFilePath, state.SchemaName, state.TableName, state.WatermarkColumn)
+	}
+	if err := resumeFromLastPartFile(state, outputDir, options.Encryption, log); err != nil {
+		return nil, err
+	}
+
+	// a repeatable read transaction gives the same snapshot to the watermark query and to the export
+	tx, err := dbConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func(tx pgx.Tx, ctx context.Context) {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Error("failed to rollback transaction: %v", err)
+		}
+	}(tx, context.WithoutCancel(ctx)) // Rollback if not committed, even when the export was canceled
+
+	newState := *state
+	table := pgx.Identifier{schemaName, tableName}.Sanitize()
+	query := selectTableQuery(schemaName, tableName, schema)
+	var args []interface{}
+	kvMetadata := make(map[string]string)
+	if options.UseXmin {
+		err = tx.QueryRow(ctx, "SELECT txid_snapshot_xmin(txid_current_snapshot())").Scan(&newState.XminHorizon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve the transaction id horizon: %w", err)
+		}
+		if state.XminHorizon > 0 {
+			// age() compares the 32 bits xmin of the rows with the previous horizon across xid wraparound
+			query += " WHERE age(xmin) <= age(CAST($1::text AS xid))"
+			args = append(args, strconv.FormatUint(state.XminHorizon%xidModulo, 10))
+		}
+		kvMetadata[MetadataKeyXminHorizon] = strconv.FormatUint(newState.XminHorizon, 10)
+	} else {
+		column := pgx.Identifier{options.WatermarkColumn}.Sanitize()
+		maxQuery := fmt.Sprintf("SELECT max(%s)::text FROM %s", column, table)
+		if state.Watermark != nil {
+			maxQuery += fmt.Sprintf(" WHERE %s > CAST($1::text AS %s)", column, watermarkType)
+			args = append(args, *state.Watermark)
+		}
+		var newWatermark *string
+		err = tx.QueryRow(ctx, maxQuery, args...).Scan(&newWatermark)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve the new watermark of column %s: %w", options.WatermarkColumn, err)
+		}
+		if newWatermark == nil {
+			log.Info("no new rows in table %s.%s since the last export", schemaName, tableName)
+			return state, tx.Commit(ctx)
+		}
+		newState.Watermark = newWatermark
+		if state.Watermark != nil {
+			query += fmt.Sprintf(" WHERE %s > CAST($1::text AS %s) AND %s <= CAST($2::text AS %s)", column, watermarkType, column, watermarkType)
+			kvMetadata[MetadataKeyPreviousWatermark] = *state.Watermark
+		} else {
+			query += fmt.Sprintf(" WHERE %s <= CAST($1::text AS %s)", column, watermarkType)
+		}
+		args = append(args, *newState.Watermark)
+		query += " ORDER BY " + column
+		kvMetadata[MetadataKeyWatermarkColumn] = options.WatermarkColumn
+		kvMetadata[MetadataKeyWatermark] = *newState.Watermark
+	}
+
+	sourceMetadata, err := exportMetadata(ctx, tx, schemaName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(kvMetadata, sourceMetadata)
+
+	partNumber, err := nextPartNumber(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	partFileName := fmt.Sprintf(partFileNameFormat, partNumber)
+	out, err := sink.Open(ctx, filepath.Join(outputDir, partFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer out.Abort()
+	progress, err := newProgressTracker(ctx, tx, schemaName, tableName, options.Progress, false)
+	if err != nil {
+		return nil, err
+	}
+	numRows, err := writeQueryToSink(ctx, tx, query, args, schema, out, kvMetadata, progress, batchSize, WriterOptions{Format: FormatParquet, Memory: options.Memory, Encryption: options.Encryption}, log)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	newState.ExportedAt = time.Now()
+	newState.LastRowCount = numRows
+	if numRows == 0 {
+		log.Info("no new rows in table %s.%s", schemaName, tableName)
+	} else {
+		if err := out.Commit(); err != nil {
+			return nil, err
+		}
+		newState.LastFile = partFileName
+		log.Info("%d new rows of table %s.%s exported in %s", numRows, schemaName, tableName, partFileName)
+	}
+	if err := SaveIncrementalState(stateFilePath, &newState); err != nil {
+		return nil, err
+	}
+	progress.done()
+	return &newState, nil
+}
+
+// LoadIncrementalState reads the state file, it returns nil when there is no previous export
+func LoadIncrementalState(stateFilePath string) (*IncrementalState, error) {
+	content, err := os.ReadFile(stateFilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read state file %s: %w", stateFilePath, err)
+	}
+	state := &IncrementalState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to decode state file %s: %w", stateFilePath, err)
+	}
+	return state, nil
+}
+
+// resumeFromLastPartFile updates the state with the watermark stored in the last part file of outputDir
+// when this file is more recent than the state, which happens when an export failed before saving its state
+func resumeFromLastPartFile(state *IncrementalState, outputDir string, encryption *EncryptionOptions, log golog.MyLogger) error {
+	parts, err := listPartFiles(outputDir)
+	if err != nil || len(parts) == 0 {
+		return err
+	}
+	lastPart := parts[len(parts)-1]
+	if state.LastFile != "" && partNumberOf(state.LastFile) >= partNumberOf(lastPart) {
+		return nil
+	}
+	kv, err := readKeyValueMetadata(filepath.Join(outputDir, lastPart), encryption)
+	if err != nil {
+		return err
+	}
+	if watermark, found := kv[MetadataKeyWatermark]; found {
+		if kv[MetadataKeyWatermarkColumn] != state.WatermarkColumn {
+			return fmt.Errorf("%s was exported with watermark column '%s' instead of '%s'", lastPart, kv[MetadataKeyWatermarkColumn], state.WatermarkColumn)
+		}
+		state.Watermark = &watermark
+	} else if horizon, found := kv[MetadataKeyXminHorizon]; found {
+		if state.XminHorizon, err = strconv.ParseUint(horizon, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s in %s: %w", MetadataKeyXminHorizon, lastPart, err)
+		}
+	} else {
+		return nil
+	}
+	state.LastFile = lastPart
+	log.Warn("resuming incremental export of %s.%s from the watermark stored in %s", state.SchemaName, state.TableName, lastPart)
+	return nil
+}
+
+// SaveIncrementalState writes the state file through a temporary file, so it is never left half written
+func SaveIncrementalState(stateFilePath string, state *IncrementalState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := sink.WriteFile(context.Background(), stateFilePath, content); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// readKeyValueMetadata returns the key-value metadata stored in the footer of a parquet file
+func readKeyValueMetadata(filePath string, encryption *EncryptionOptions) (map[string]string, error) {
+	reader, err := file.OpenParquetFile(filePath, false, file.WithReadProps(encryption.ReaderProperties(memory.DefaultAllocator)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Parquet file %s: %w", filePath, err)
+	}
+	defer reader.Close()
+	kv := reader.MetaData().KeyValueMetadata()
+	res := make(map[string]string, kv.Len())
+	for i, key := range kv.Keys() {
+		res[key] = kv.Values()[i]
+	}
+	return res, nil
+}
+
+// listPartFiles returns the part-NNNN.parquet files of the directory ordered by part number
+func listPartFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory %s: %w", dir, err)
+	}
+	var parts []string
+	for _, entry := range entries {
+		if !entry.IsDir() && partFileNameRegexp.MatchString(entry.Name()) {
+			parts = append(parts, entry.Name())
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return partNumberOf(parts[i]) < partNumberOf(parts[j]) })
+	return parts, nil
+}
+
+// nextPartNumber returns the number following the last part file of the directory
+func nextPartNumber(dir string) (int, error) {
+	parts, err := listPartFiles(dir)
+	if err != nil || len(parts) == 0 {
+		return 0, err
+	}
+	return partNumberOf(parts[len(parts)-1]) + 1, nil
+}
+
+func partNumberOf(fileName string) int {
+	match := partFileNameRegexp.FindStringSubmatch(fileName)
+	if match == nil {
+		return -1
+	}
+	n, _ := strconv.Atoi(match[1])
+	return n
+}
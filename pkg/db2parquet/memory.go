@@ -0,0 +1,145 @@
+package db2parquet
+
+import (
+	"math/bits"
+	"sync"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/arrow/util"
+)
+
+const (
+	// batchBudgetShares is the memory held by a batch in flight in multiple of the size of its Arrow values:
+	// the builders double their capacity, so a record holds up to twice the bytes of its values,
+	// and the rows decoded by pgx take about as much memory as the values
+	batchBudgetShares = 3
+	// writerBudgetShares is the memory of the row group encoded by the parquet writer from the record being written
+	writerBudgetShares = 1
+	// maxFetchSize bounds the number of rows of a FETCH whatever the row width
+	maxFetchSize = 1_000_000
+	// minPoolClass and maxPoolClass are the log2 of the smallest and largest buffers kept by the PoolAllocator
+	minPoolClass = 6
+	maxPoolClass = 30
+)
+
+// MemoryOptions bounds the memory used by the Arrow buffers of an export
+type MemoryOptions struct {
+	// Allocator allocates the Arrow buffers, the default is a PoolAllocator shared by the exports.
+	// A memory.CheckedAllocator can be used to verify that every buffer is released.
+	Allocator memory.Allocator
+	// Budget is the approximate number of bytes held by an export, the number of rows of each FETCH
+	// is adapted to the width of the rows already fetched to stay within it. 0 keeps the fixed batch size.
+	Budget int64
+}
+
+var defaultAllocator = NewPoolAllocator()
+
+// allocator returns the configured allocator or the shared PoolAllocator
+func (m MemoryOptions) allocator() memory.Allocator {
+	if m.Allocator != nil {
+		return m.Allocator
+	}
+	return defaultAllocator
+}
+
+// PoolAllocator is a memory.Allocator reusing the buffers freed by the released Arrow arrays,
+// the buffers are grouped by power of two sizes so that the batches of an export share them.
+type PoolAllocator struct {
+	mem   memory.Allocator
+	pools [maxPoolClass + 1]sync.Pool
+}
+
+// NewPoolAllocator returns a PoolAllocator getting its new buffers from a memory.GoAllocator
+func NewPoolAllocator() *PoolAllocator {
+	return &PoolAllocator{mem: memory.NewGoAllocator()}
+}
+
+// sizeClass returns the log2 of the smallest pooled buffer holding size bytes
+func sizeClass(size int) int {
+	if size <= 1<<minPoolClass {
+		return minPoolClass
+	}
+	return bits.Len(uint(size - 1))
+}
+
+func (p *PoolAllocator) Allocate(size int) []byte {
+	class := sizeClass(size)
+	if class > maxPoolClass {
+		return p.mem.Allocate(size)
+	}
+	if buf, ok := p.pools[class].Get().(*[]byte); ok {
+		b := (*buf)[:size]
+		// the Arrow builders expect zeroed memory like the one returned by make
+		clear(b)
+		return b
+	}
+	return p.mem.Allocate(1 << class)[:size]
+}
+
+func (p *PoolAllocator) Reallocate(size int, b []byte) []byte {
+	if cap(b) >= size {
+		return b[:size]
+	}
+	newBuf := p.Allocate(size)
+	copy(newBuf, b)
+	p.Free(b)
+	return newBuf
+}
+
+func (p *PoolAllocator) Free(b []byte) {
+	c := cap(b)
+	// only the buffers allocated by the pools have a power of two capacity in the pooled range
+	if c == 0 || c&(c-1) != 0 || c < 1<<minPoolClass || c > 1<<maxPoolClass {
+		return
+	}
+	b = b[:c]
+	p.pools[bits.Len(uint(c))-1].Put(&b)
+}
+
+// fetchSizer returns the number of rows of each FETCH, with a memory budget it is computed
+// from the average width of the rows of the previous batches and the number of batches in flight,
+// the budget is shared by the batches in flight and the row group buffered by the writer
+type fetchSizer struct {
+	mu          sync.Mutex
+	batchSize   int
+	budget      int64
+	inFlight    int
+	rowWidth    float64
+	initialized bool
+}
+
+func newFetchSizer(batchSize int, budget int64, inFlight int) *fetchSizer {
+	return &fetchSizer{batchSize: batchSize, budget: budget, inFlight: max(1, inFlight)}
+}
+
+// next returns the number of rows of the next FETCH
+func (f *fetchSizer) next() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.budget <= 0 || !f.initialized {
+		return f.batchSize
+	}
+	shares := int64(batchBudgetShares*f.inFlight + writerBudgetShares)
+	rows := int(float64(f.budget/shares) / f.rowWidth)
+	return max(1, min(rows, maxFetchSize))
+}
+
+// observe updates the row width with the size of the Arrow buffers of a record
+func (f *fetchSizer) observe(record arrow.Record) {
+	if f.budget <= 0 || record.NumRows() == 0 {
+		return
+	}
+	width := float64(util.TotalRecordSize(record)) / float64(record.NumRows())
+	if width < 1 {
+		width = 1
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.initialized {
+		f.rowWidth, f.initialized = width, true
+		return
+	}
+	// the widest recent batches weigh more to avoid overshooting the budget after narrow rows
+	f.rowWidth = max(width, (f.rowWidth+width)/2)
+}
@@ -0,0 +1,69 @@
+package db2parquet
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/jackc/pgx/v5"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/version"
+)
+
+// Keys of the parquet key-value metadata written by the exports
+const (
+	MetadataKeySourceHost     = "arrowflightpg.source_host"
+	MetadataKeySourceDatabase = "arrowflightpg.source_database"
+	MetadataKeySourceTable    = "arrowflightpg.source_table"
+	MetadataKeyTableComment   = "arrowflightpg.table_comment"
+	MetadataKeyExportedAt     = "arrowflightpg.exported_at"
+	MetadataKeyVersion        = "arrowflightpg.version"
+	// MetadataKeyWatermarkColumn is the column used as watermark by an incremental export
+	MetadataKeyWatermarkColumn = "arrowflightpg.watermark_column"
+	// MetadataKeyWatermark is the highest watermark value exported in the file
+	MetadataKeyWatermark = "arrowflightpg.watermark"
+	// MetadataKeyPreviousWatermark is the watermark of the previous incremental export
+	MetadataKeyPreviousWatermark = "arrowflightpg.previous_watermark"
+	// MetadataKeyXminHorizon is the transaction id horizon of an incremental export in xmin mode
+	MetadataKeyXminHorizon = "arrowflightpg.xmin_horizon"
+
+	tableCommentQuery = "SELECT obj_description(to_regclass($1), 'pg_class')"
+)
+
+// exportMetadata returns the key-value metadata describing the source table of an export
+func exportMetadata(ctx context.Context, tx pgx.Tx, schemaName string, tableName string) (map[string]string, error) {
+	table := pgx.Identifier{schemaName, tableName}.Sanitize()
+	var tableComment *string
+	if err := tx.QueryRow(ctx, tableCommentQuery, table).Scan(&tableComment); err != nil {
+		return nil, fmt.Errorf("failed to retrieve the comment of table %s: %w", table, err)
+	}
+	connConfig := tx.Conn().Config()
+	kv := map[string]string{
+		MetadataKeySourceHost:     connConfig.Host,
+		MetadataKeySourceDatabase: connConfig.Database,
+		MetadataKeySourceTable:    schemaName + "." + tableName,
+		MetadataKeyExportedAt:     time.Now().UTC().Format(time.RFC3339),
+		MetadataKeyVersion:        version.APP + " " + version.VERSION,
+	}
+	if tableComment != nil {
+		kv[MetadataKeyTableComment] = *tableComment
+	}
+	return kv, nil
+}
+
+// appendKeyValueMetadata adds the key-value metadata to the footer of the parquet file, ordered by key
+func appendKeyValueMetadata(writer *pqarrow.FileWriter, kv map[string]string) error {
+	for _, key := range slices.Sorted(maps.Keys(kv)) {
+		if err := writer.AppendKeyValueMetadata(key, kv[key]); err != nil {
+			return fmt.Errorf("failed to append key-value metadata %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// newArrowWriterProperties stores the Arrow schema in the parquet file to keep the field metadata
+func newArrowWriterProperties() pqarrow.ArrowWriterProperties {
+	return pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema())
+}
@@ -0,0 +1,104 @@
+package db2parquet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// ndjsonRecordWriter writes every row of the records as a JSON object on its own line,
+// the keys keep the order of the columns in the schema
+type ndjsonRecordWriter struct {
+	writer          *bufio.Writer
+	keys            [][]byte
+	timestampLayout string
+}
+
+func newNdjsonRecordWriter(w io.Writer, schema *arrow.Schema, timestampLayout string) (*ndjsonRecordWriter, error) {
+	keys := make([][]byte, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		key, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode column name %s: %w", field.Name, err)
+		}
+		keys[i] = append(key, ':')
+	}
+	return &ndjsonRecordWriter{writer: bufio.NewWriter(w), keys: keys, timestampLayout: timestampLayout}, nil
+}
+
+func (n *ndjsonRecordWriter) Write(record arrow.Record) error {
+	for row := 0; row < int(record.NumRows()); row++ {
+		n.writer.WriteByte('{')
+		for i, col := range record.Columns() {
+			if i > 0 {
+				n.writer.WriteByte(',')
+			}
+			n.writer.Write(n.keys[i])
+			value, err := json.Marshal(n.jsonValue(col, row))
+			if err != nil {
+				return fmt.Errorf("failed to encode column %s at row %d in JSON: %w", record.ColumnName(i), row, err)
+			}
+			n.writer.Write(value)
+		}
+		if _, err := n.writer.WriteString("}\n"); err != nil {
+			return fmt.Errorf("failed to write NDJSON row: %w", err)
+		}
+	}
+	return nil
+}
+
+// jsonValue returns the value at index i of the column in the form used by Arrow to marshal it in JSON,
+// except for the NaN and infinite floats written like PostgreSQL does in to_json
+func (n *ndjsonRecordWriter) jsonValue(col arrow.Array, i int) interface{} {
+	if col.IsNull(i) {
+		return nil
+	}
+	switch c := col.(type) {
+	case *array.Timestamp:
+		if n.timestampLayout != "" {
+			return formatTimestamp(c, i, n.timestampLayout)
+		}
+	case *array.Float16:
+		if f := float64(c.Value(i).Float32()); !isFinite(f) {
+			return nonFiniteJSON(f)
+		}
+	case *array.Float32:
+		if f := float64(c.Value(i)); !isFinite(f) {
+			return nonFiniteJSON(f)
+		}
+	case *array.Float64:
+		if f := c.Value(i); !isFinite(f) {
+			return nonFiniteJSON(f)
+		}
+	}
+	return col.GetOneForMarshal(i)
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// nonFiniteJSON returns the string "NaN", "Infinity" or "-Infinity" of a float that JSON has no number for,
+// the spelling of PostgreSQL which the readers of the export can parse back as a float
+func nonFiniteJSON(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case f > 0:
+		return "Infinity"
+	default:
+		return "-Infinity"
+	}
+}
+
+func (n *ndjsonRecordWriter) Close() error {
+	if err := n.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush NDJSON writer: %w", err)
+	}
+	return nil
+}
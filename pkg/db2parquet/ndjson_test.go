@@ -0,0 +1,49 @@
+package db2parquet
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestNdjsonRecordWriterNonFiniteFloats(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "ratio", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+		{Name: "score", Type: arrow.PrimitiveTypes.Float32, Nullable: true},
+	}, nil)
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	builder.Field(0).(*array.Int64Builder).AppendValues([]int64{1, 2, 3, 4, 5}, nil)
+	builder.Field(1).(*array.Float64Builder).AppendValues([]float64{1.5, math.NaN(), math.Inf(1), math.Inf(-1), 0}, []bool{true, true, true, true, false})
+	builder.Field(2).(*array.Float32Builder).AppendValues([]float32{0.1, float32(math.NaN()), float32(math.Inf(-1)), 2, 3}, nil)
+	record := builder.NewRecord()
+	defer record.Release()
+
+	var buf bytes.Buffer
+	writer, err := NewRecordWriter(&buf, schema, nil, WriterOptions{Format: FormatNDJSON, Memory: MemoryOptions{Allocator: mem}})
+	if err != nil {
+		t.Fatalf("NewRecordWriter() error: %v", err)
+	}
+	if err := writer.Write(record); err != nil {
+		t.Fatalf("Write() with a NaN row error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	want := `{"id":1,"ratio":1.5,"score":0.1}
+{"id":2,"ratio":"NaN","score":"NaN"}
+{"id":3,"ratio":"Infinity","score":"-Infinity"}
+{"id":4,"ratio":"-Infinity","score":2}
+{"id":5,"ratio":null,"score":3}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("NDJSON rows =\n%s\nwant\n%s", got, want)
+	}
+}
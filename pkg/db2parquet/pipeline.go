@@ -0,0 +1,175 @@
+package db2parquet
+
+import (
+	"context"
+	"sync"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/jackc/pgx/v5"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+// rowBatch is a batch of rows fetched from the cursor, seq gives the order of the batches
+type rowBatch struct {
+	seq  int
+	rows [][]interface{}
+}
+
+// recordBatch is the Arrow RecordBatch converted from the rowBatch with the same seq
+type recordBatch struct {
+	seq    int
+	record arrow.Record
+}
+
+// writeQueryRecords fetches the rows of the query inside tx and writes them as Arrow RecordBatch with the record writer.
+// One goroutine fetches the batches from the cursor, options.Converters goroutines convert them to Arrow and
+// the calling goroutine writes the records in the order of the query and reports them to the progress tracker. The channels between the stages are bounded,
+// so a slow writer stops the fetches. The first error cancels the other stages, the cursor is closed and the caller
+// rolls back the transaction. Every record is released once written, so the memory used does not grow with the size
+// of the table. It returns the number of rows written.
+func writeQueryRecords(
+	ctx context.Context,
+	tx pgx.Tx,
+	query string,
+	args []interface{},
+	schema *arrow.Schema,
+	writer RecordWriter,
+	progress *progressTracker,
+	batchSize int,
+	options WriterOptions,
+	log golog.MyLogger) (int64, error) {
+	converters := max(1, options.Converters)
+	mem := options.Memory.allocator()
+	// a batch can be fetched, queued and converted by every converter while one is written
+	inFlight := 2*converters + 2
+	sizer := newFetchSizer(batchSize, options.Memory.Budget, inFlight)
+	// a slot is taken by each batch handed to the converters and given back once its record is written,
+	// so the converters cannot run ahead of the writer with more batches than the sizer accounts for
+	slots := make(chan struct{}, inFlight-1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan rowBatch, converters)
+	records := make(chan recordBatch, converters)
+	fetchResult := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		var rows [][]interface{}
+		seq := 0
+		fetchResult <- fetchRowsWithCursor(ctx, tx, query, args, sizer.next, log,
+			func(values []interface{}) error {
+				rows = append(rows, values)
+				return nil
+			},
+			func(numRows int) error {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				select {
+				case batches <- rowBatch{seq: seq, rows: rows}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				seq++
+				rows = make([][]interface{}, 0, numRows)
+				return nil
+			})
+	}()
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		convertErr error
+	)
+	for i := 0; i < converters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			builders := newBuilders(mem, schema)
+			defer releaseBuilders(builders)
+			for batch := range batches {
+				record, err := convertRows(schema, builders, batch.rows)
+				if err != nil {
+					mu.Lock()
+					if convertErr == nil {
+						convertErr = err
+					}
+					mu.Unlock()
+					cancel()
+					return
+				}
+				select {
+				case records <- recordBatch{seq: batch.seq, record: record}:
+				case <-ctx.Done():
+					record.Release()
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(records)
+	}()
+
+	// the records are written in the order of the batches, the converters may finish them out of order
+	var (
+		numRowsWritten int64
+		writeErr       error
+		nextSeq        int
+	)
+	pending := make(map[int]arrow.Record)
+	for rb := range records {
+		if writeErr != nil {
+			rb.record.Release()
+			continue
+		}
+		pending[rb.seq] = rb.record
+		for record, found := pending[nextSeq]; found; record, found = pending[nextSeq] {
+			delete(pending, nextSeq)
+			nextSeq++
+			sizer.observe(record)
+			if err := writer.Write(record); err != nil {
+				writeErr = err
+				cancel()
+			} else {
+				numRowsWritten += record.NumRows()
+				progress.add(record.NumRows())
+			}
+			record.Release()
+			<-slots
+			if writeErr != nil {
+				break
+			}
+		}
+	}
+	for _, record := range pending {
+		record.Release()
+	}
+	fetchErr := <-fetchResult
+
+	// the errors of the converters and of the writer cancel the fetch, they are the cause to report
+	switch {
+	case writeErr != nil:
+		return numRowsWritten, writeErr
+	case convertErr != nil:
+		return numRowsWritten, convertErr
+	default:
+		return numRowsWritten, fetchErr
+	}
+}
+
+// convertRows appends the rows to the builders and returns them as an Arrow RecordBatch
+func convertRows(schema *arrow.Schema, builders []array.Builder, rows [][]interface{}) (arrow.Record, error) {
+	for _, values := range rows {
+		for i, val := range values {
+			if err := appendValue(builders[i], schema.Field(i), val); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return newRecordFromBuilders(schema, builders, len(rows)), nil
+}
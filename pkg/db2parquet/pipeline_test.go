@@ -0,0 +1,214 @@
+package db2parquet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeTx answers the statements of fetchRowsWithCursor with generated rows, the other methods of pgx.Tx are not used
+type fakeTx struct {
+	pgx.Tx
+	numRows int64
+	next    int64
+	row     func(i int64) []interface{}
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (tx *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	var count int64
+	if _, err := fmt.Sscanf(sql, "FETCH %d FROM", &count); err != nil {
+		return nil, fmt.Errorf("unexpected query %q", sql)
+	}
+	end := min(tx.next+count, tx.numRows)
+	rows := &fakeRows{tx: tx, current: tx.next - 1, end: end}
+	tx.next = end
+	return rows, nil
+}
+
+// fakeRows returns the rows of a FETCH of fakeTx
+type fakeRows struct {
+	pgx.Rows
+	tx      *fakeTx
+	current int64
+	end     int64
+}
+
+func (r *fakeRows) Next() bool {
+	r.current++
+	return r.current < r.end
+}
+
+func (r *fakeRows) Values() ([]interface{}, error) { return r.tx.row(r.current), nil }
+func (r *fakeRows) Close()                         {}
+func (r *fakeRows) Err() error                     { return nil }
+
+// peakAllocator counts the bytes allocated like memory.CheckedAllocator and keeps the highest value
+type peakAllocator struct {
+	*memory.CheckedAllocator
+	mu      sync.Mutex
+	current int64
+	peak    int64
+}
+
+func (p *peakAllocator) update(delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current += int64(delta)
+	p.peak = max(p.peak, p.current)
+}
+
+func (p *peakAllocator) Allocate(size int) []byte {
+	p.update(size)
+	return p.CheckedAllocator.Allocate(size)
+}
+
+func (p *peakAllocator) Reallocate(size int, b []byte) []byte {
+	p.update(size - len(b))
+	return p.CheckedAllocator.Reallocate(size, b)
+}
+
+func (p *peakAllocator) Free(b []byte) {
+	p.update(-len(b))
+	p.CheckedAllocator.Free(b)
+}
+
+var testRowsSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "payload", Type: arrow.BinaryTypes.String, Nullable: true},
+}, nil)
+
+func TestWriteQueryRecords(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	tx := &fakeTx{numRows: 10_000, row: func(i int64) []interface{} {
+		if i%10 == 0 {
+			return []interface{}{i, nil}
+		}
+		return []interface{}{i, "row " + strconv.FormatInt(i, 10)}
+	}}
+	options := WriterOptions{Memory: MemoryOptions{Allocator: mem}, Converters: 3}
+	var buf bytes.Buffer
+	writer, err := NewRecordWriter(&buf, testRowsSchema, nil, options)
+	if err != nil {
+		t.Fatalf("NewRecordWriter() error: %v", err)
+	}
+	numRows, err := writeQueryRecords(context.Background(), tx, "SELECT", nil, testRowsSchema, writer, nil, 700, options, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("writeQueryRecords() error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if numRows != tx.numRows {
+		t.Fatalf("writeQueryRecords() wrote %d rows, want %d", numRows, tx.numRows)
+	}
+
+	// the converters finish the batches in any order, the rows must be written in the order of the cursor
+	reader, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewParquetReader() error: %v", err)
+	}
+	defer reader.Close()
+	arrowReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{BatchSize: 4096}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatalf("NewFileReader() error: %v", err)
+	}
+	table, err := arrowReader.ReadTable(context.Background())
+	if err != nil {
+		t.Fatalf("ReadTable() error: %v", err)
+	}
+	defer table.Release()
+	var want int64
+	for _, chunk := range table.Column(0).Data().Chunks() {
+		ids := chunk.(*array.Int64)
+		for i := 0; i < ids.Len(); i++ {
+			if ids.Value(i) != want {
+				t.Fatalf("row %d has id %d", want, ids.Value(i))
+			}
+			want++
+		}
+	}
+}
+
+// TestWriteQueryRecordsMemoryBudget exports 2 GiB of rows, set EXPORT_BUDGET_TEST_MB to change the size,
+// the Arrow buffers held at any time must stay within the memory budget
+func TestWriteQueryRecordsMemoryBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the export of several GiB is skipped in short mode")
+	}
+	exportSize := int64(2048) << 20
+	if value := os.Getenv("EXPORT_BUDGET_TEST_MB"); value != "" {
+		mb, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			t.Fatalf("invalid EXPORT_BUDGET_TEST_MB %s: %v", value, err)
+		}
+		exportSize = mb << 20
+	}
+	const budget = 64 << 20
+	payload := strings.Repeat("x", 1024)
+	mem := &peakAllocator{CheckedAllocator: memory.NewCheckedAllocator(memory.NewGoAllocator())}
+	defer mem.AssertSize(t, 0)
+	tx := &fakeTx{numRows: exportSize / int64(len(payload)), row: func(i int64) []interface{} {
+		return []interface{}{i, payload}
+	}}
+	options := WriterOptions{Memory: MemoryOptions{Allocator: mem, Budget: budget}, Converters: 2}
+	writer, err := NewRecordWriter(io.Discard, testRowsSchema, nil, options)
+	if err != nil {
+		t.Fatalf("NewRecordWriter() error: %v", err)
+	}
+	numRows, err := writeQueryRecords(context.Background(), tx, "SELECT", nil, testRowsSchema, writer, nil, 100, options, newTestLogger(t))
+	if err != nil {
+		t.Fatalf("writeQueryRecords() error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if numRows != tx.numRows {
+		t.Fatalf("writeQueryRecords() wrote %d rows, want %d", numRows, tx.numRows)
+	}
+	t.Logf("%d MiB exported with a peak of %d MiB of Arrow buffers", exportSize>>20, mem.peak>>20)
+	if mem.peak > budget {
+		t.Errorf("peak of the Arrow buffers %d bytes is over the budget of %d bytes", mem.peak, budget)
+	}
+}
+
+func TestFetchSizer(t *testing.T) {
+	sizer := newFetchSizer(100, 4<<20, 2)
+	if got := sizer.next(); got != 100 {
+		t.Fatalf("next() before any record = %d, want the batch size 100", got)
+	}
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	builder := array.NewStringBuilder(mem)
+	defer builder.Release()
+	for i := 0; i < 1000; i++ {
+		builder.Append(strings.Repeat("y", 1000))
+	}
+	column := builder.NewArray()
+	defer column.Release()
+	record := array.NewRecord(arrow.NewSchema([]arrow.Field{{Name: "s", Type: arrow.BinaryTypes.String}}, nil), []arrow.Array{column}, 1000)
+	defer record.Release()
+	sizer.observe(record)
+	// rows of about 1 KiB, the budget shared by the two batches in flight and the row group of the writer
+	if got := sizer.next(); got < 550 || got > 650 {
+		t.Errorf("next() with rows of 1 KiB = %d, want about 599", got)
+	}
+}
@@ -0,0 +1,141 @@
+package db2parquet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Progress is the advancement of the export of one table reported to a ProgressFunc
+type Progress struct {
+	SchemaName string
+	TableName  string
+	// Rows is the number of rows written (or fetched for a partitioned dataset) so far
+	Rows int64
+	// Bytes is the number of bytes written to the output, parquet writes them when a row group is flushed
+	Bytes int64
+	// EstimatedRows is the row count estimated by PostgreSQL in pg_class.reltuples, 0 when unknown
+	EstimatedRows int64
+	Elapsed       time.Duration
+	// Done is set in the last report, sent once the export succeeded
+	Done bool
+}
+
+// Rate returns the number of rows exported per second
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Rows) / p.Elapsed.Seconds()
+}
+
+// Fraction returns the part of the estimated rows already exported between 0 and 1, or -1 without estimate
+func (p Progress) Fraction() float64 {
+	if p.EstimatedRows <= 0 {
+		return -1
+	}
+	return min(1, float64(p.Rows)/float64(p.EstimatedRows))
+}
+
+// ETA returns the estimated remaining time of the export, false when there is no row estimate or no rate yet.
+// The estimate of PostgreSQL may be outdated, so the ETA is 0 once more rows than estimated are exported.
+func (p Progress) ETA() (time.Duration, bool) {
+	rate := p.Rate()
+	if p.EstimatedRows <= 0 || rate <= 0 {
+		return 0, false
+	}
+	remaining := max(0, p.EstimatedRows-p.Rows)
+	return time.Duration(float64(remaining) / rate * float64(time.Second)), true
+}
+
+// ProgressFunc receives the progress after every batch written and a last time with Done set.
+// It is called from the goroutine writing the file, so it must return quickly. The tables of a schema
+// export are written in parallel, the function is then called concurrently for different tables.
+type ProgressFunc func(progress Progress)
+
+// ProgressChannel returns a ProgressFunc sending the reports to the channel. The intermediate reports are
+// dropped while the channel is full, so a slow reader never slows the export, but the Done report is always sent.
+func ProgressChannel(ch chan<- Progress) ProgressFunc {
+	return func(progress Progress) {
+		if progress.Done {
+			ch <- progress
+			return
+		}
+		select {
+		case ch <- progress:
+		default:
+		}
+	}
+}
+
+// progressTracker counts the rows and bytes of one table export and reports them to the ProgressFunc,
+// a nil tracker is used when no ProgressFunc is configured and all its methods do nothing.
+type progressTracker struct {
+	report   ProgressFunc
+	progress Progress
+	start    time.Time
+	bytes    func() int64
+}
+
+// newProgressTracker returns a tracker for the export of the table, the row estimate is read in the
+// transaction of the export when withEstimate is set. It returns nil when report is nil.
+func newProgressTracker(ctx context.Context, tx pgx.Tx, schemaName string, tableName string, report ProgressFunc, withEstimate bool) (*progressTracker, error) {
+	if report == nil {
+		return nil, nil
+	}
+	p := &progressTracker{
+		report:   report,
+		progress: Progress{SchemaName: schemaName, TableName: tableName},
+		start:    time.Now(),
+	}
+	if withEstimate {
+		var estimate float64
+		// reltuples is -1 for a table never vacuumed nor analyzed
+		err := tx.QueryRow(ctx, "SELECT reltuples FROM pg_class WHERE oid = $1::regclass",
+			pgx.Identifier{schemaName, tableName}.Sanitize()).Scan(&estimate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve the row estimate of table %s.%s: %w", schemaName, tableName, err)
+		}
+		p.progress.EstimatedRows = max(0, int64(estimate))
+	}
+	return p, nil
+}
+
+// countBytes returns w counting the bytes written to it as the bytes of the progress
+func (p *progressTracker) countBytes(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	counter := &countingWriter{w: w}
+	p.bytes = func() int64 { return counter.n }
+	return counter
+}
+
+// add reports numRows more rows
+func (p *progressTracker) add(numRows int64) {
+	if p == nil {
+		return
+	}
+	p.progress.Rows += numRows
+	p.send()
+}
+
+// done sends the last report of a successful export
+func (p *progressTracker) done() {
+	if p == nil {
+		return
+	}
+	p.progress.Done = true
+	p.send()
+}
+
+func (p *progressTracker) send() {
+	p.progress.Elapsed = time.Since(p.start)
+	if p.bytes != nil {
+		p.progress.Bytes = p.bytes()
+	}
+	p.report(p.progress)
+}
@@ -0,0 +1,290 @@
+package db2parquet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	// ManifestFileName is the name of the json manifest written in the output directory of a schema export
+	ManifestFileName = "manifest.json"
+	snapshotLsnQuery = "SELECT pg_export_snapshot(), (CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END)::text"
+)
+
+// SchemaExportOptions defines which tables of a schema are exported and how many are exported in parallel
+type SchemaExportOptions struct {
+	// Tables lists the tables to export, glob patterns like sales_* are accepted, empty means every table of the schema
+	Tables []string
+	// Workers is the number of tables exported in parallel, each worker uses one connection of the pool
+	Workers int
+	// Writer defines the format and the compression of the file written for each table
+	Writer WriterOptions
+}
+
+// ExportManifest describes the files written by a schema export and the snapshot they were read from
+type ExportManifest struct {
+	SchemaName  string          `json:"schema_name"`
+	SnapshotId  string          `json:"snapshot_id"`
+	SnapshotLSN string          `json:"snapshot_lsn"`
+	StartedAt   time.Time       `json:"started_at"`
+	FinishedAt  time.Time       `json:"finished_at"`
+	Tables      []ManifestTable `json:"tables"`
+}
+
+// ManifestTable is the parquet file written for one table in a schema export
+type ManifestTable struct {
+	TableName string `json:"table_name"`
+	File      string `json:"file"`
+	RowCount  int64  `json:"row_count"`
+}
+
+// CreateParquetFilesFromDbSchema exports the selected tables of a schema in one parquet file per table
+// inside outputDir. A REPEATABLE READ transaction exports its snapshot with pg_export_snapshot() and every
+// worker imports it with SET TRANSACTION SNAPSHOT, so all the files are consistent with each other.
+// A manifest.json with the row counts and the LSN of the snapshot is written when every table succeeded,
+// otherwise the files of the tables already exported are removed.
+func CreateParquetFilesFromDbSchema(
+	ctx context.Context,
+	dbConn *pgxpool.Pool,
+	store db.Storage,
+	schemaName string,
+	outputDir string,
+	batchSize int,
+	options SchemaExportOptions,
+	log golog.MyLogger) (*ExportManifest, error) {
+	tableNames, err := selectSchemaTables(store, schemaName, options.Tables)
+	if err != nil {
+		return nil, err
+	}
+	if len(tableNames) == 0 {
+		return nil, fmt.Errorf("no table found in schema %s matching %v", schemaName, options.Tables)
+	}
+	log.Info("%d tables of schema %s will be exported", len(tableNames), schemaName)
+	tablesColumns := make(map[string][]db.ColumnInfo, len(tableNames))
+	for _, tableName := range tableNames {
+		columns, err := store.GetTableSchema(schemaName, tableName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get columns of table %s.%s: %w", schemaName, tableName, err)
+		}
+		tablesColumns[tableName] = columns
+	}
+	if err := sink.MkdirAll(outputDir); err != nil {
+		return nil, err
+	}
+
+	manifest := &ExportManifest{SchemaName: schemaName, StartedAt: time.Now()}
+	completed := false
+	defer func() {
+		if !completed {
+			removeTableFiles(context.WithoutCancel(ctx), outputDir, manifest.Tables, log)
+		}
+	}()
+	// the snapshot stays valid as long as the transaction that exported it is open
+	tx, err := dbConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start snapshot transaction: %w", err)
+	}
+	defer func(tx pgx.Tx, ctx context.Context) {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Error("failed to rollback snapshot transaction: %v", err)
+		}
+	}(tx, context.WithoutCancel(ctx))
+	if err := tx.QueryRow(ctx, snapshotLsnQuery).Scan(&manifest.SnapshotId, &manifest.SnapshotLSN); err != nil {
+		return nil, fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	log.Info("snapshot %s exported at LSN %s", manifest.SnapshotId, manifest.SnapshotLSN)
+
+	workers := options.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	ctxWorkers, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tableName := range jobs {
+				fileName := tableName + options.Writer.Format.Extension()
+				numRows, err := exportTableInSnapshot(ctxWorkers, dbConn, manifest.SnapshotId, schemaName, tableName,
+					tablesColumns[tableName], sink.Join(outputDir, fileName), batchSize, options.Writer, log)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to export table %s.%s: %w", schemaName, tableName, err)
+						cancel()
+					}
+				} else {
+					manifest.Tables = append(manifest.Tables, ManifestTable{TableName: tableName, File: fileName, RowCount: numRows})
+					log.Info("table %s.%s exported in %s with %d rows", schemaName, tableName, fileName, numRows)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, tableName := range tableNames {
+		select {
+		case jobs <- tableName:
+		case <-ctxWorkers.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit snapshot transaction: %w", err)
+	}
+
+	sort.Slice(manifest.Tables, func(i, j int) bool { return manifest.Tables[i].TableName < manifest.Tables[j].TableName })
+	manifest.FinishedAt = time.Now()
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := sink.WriteFile(ctx, sink.Join(outputDir, ManifestFileName), content); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	completed = true
+	return manifest, nil
+}
+
+// removeTableFiles deletes the files of the tables exported before the export of the schema failed,
+// without a manifest they are not a consistent snapshot
+func removeTableFiles(ctx context.Context, outputDir string, tables []ManifestTable, log golog.MyLogger) {
+	for _, table := range tables {
+		location := sink.Join(outputDir, table.File)
+		if err := sink.Remove(ctx, location); err != nil {
+			log.Error("failed to remove %s: %v", location, err)
+		}
+	}
+	if len(tables) > 0 {
+		log.Warn("%d table files of the failed export removed from %s", len(tables), outputDir)
+	}
+}
+
+// exportTableInSnapshot writes one table in a file from a transaction using the exported snapshot
+func exportTableInSnapshot(
+	ctx context.Context,
+	dbConn *pgxpool.Pool,
+	snapshotId string,
+	schemaName string,
+	tableName string,
+	tableColumns []db.ColumnInfo,
+	filePath string,
+	batchSize int,
+	writerOptions WriterOptions,
+	log golog.MyLogger) (int64, error) {
+	schema, err := db2arrow.MapToArrowSchema(tableColumns)
+	if err != nil {
+		return 0, fmt.Errorf("error doing db2arrow.MapToArrowSchema() : %v", err)
+	}
+	tx, err := dbConn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func(tx pgx.Tx, ctx context.Context) {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Error("failed to rollback transaction: %v", err)
+		}
+	}(tx, context.WithoutCancel(ctx))
+	// SET TRANSACTION SNAPSHOT does not accept a parameter, the id comes from pg_export_snapshot()
+	if _, err := tx.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", snapshotId)); err != nil {
+		return 0, fmt.Errorf("failed to import snapshot %s: %w", snapshotId, err)
+	}
+	kvMetadata, err := exportMetadata(ctx, tx, schemaName, tableName)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkVerifyOptions(filePath, writerOptions); err != nil {
+		return 0, err
+	}
+	progress, err := newProgressTracker(ctx, tx, schemaName, tableName, writerOptions.Progress, true)
+	if err != nil {
+		return 0, err
+	}
+	out, err := sink.Open(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Abort()
+	query := selectTableQuery(schemaName, tableName, schema)
+	numRows, err := writeQueryToSink(ctx, tx, query, nil, schema, out, kvMetadata, progress, batchSize, writerOptions, log)
+	if err != nil {
+		return 0, err
+	}
+	var expected *TableChecksum
+	if writerOptions.Verify {
+		if expected, err = tableChecksum(ctx, tx, schemaName, tableName, schema); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if err := out.Commit(); err != nil {
+		return 0, err
+	}
+	progress.done()
+	if writerOptions.Verify {
+		if err := verifyParquetFile(ctx, filePath, schema, numRows, expected, writerOptions.Encryption, writerOptions.Memory.allocator()); err != nil {
+			return 0, err
+		}
+		log.Info("%s verified: %d rows and the checksums of %d columns match table %s.%s", filePath, numRows, len(schema.Fields()), schemaName, tableName)
+	}
+	return numRows, nil
+}
+
+// selectSchemaTables returns the names of the tables of the schema matching one of the glob patterns
+func selectSchemaTables(store db.Storage, schemaName string, patterns []string) ([]string, error) {
+	list, err := store.List(db.ListParams{SchemaName: &schemaName})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list tables of schema %s: %w", schemaName, err)
+	}
+	var tableNames []string
+	for _, table := range list {
+		if len(patterns) == 0 {
+			tableNames = append(tableNames, table.TableName)
+			continue
+		}
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, table.TableName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid table pattern %s: %w", pattern, err)
+			}
+			if matched {
+				tableNames = append(tableNames, table.TableName)
+				break
+			}
+		}
+	}
+	return tableNames, nil
+}
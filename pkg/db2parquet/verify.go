@@ -0,0 +1,434 @@
+package db2parquet
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/jackc/pgx/v5"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+)
+
+// The checksum of a column is computed on a canonical value, the same in PostgreSQL and in Go:
+// the integers are int8, the dates are days since 1970-01-01 (int4), the timestamps are microseconds
+// since the epoch (int8), the strings are compared byte by byte (COLLATE "C"). The hash of a value
+// is the first 8 bytes of the md5 of its binary representation (int8send, float8send...) read as an int8.
+const (
+	hashSumExpr = `coalesce(sum(('x' || left(md5(%s), 16))::bit(64)::int8::numeric), 0)::text`
+	// defaultReadBatchSize is the number of rows of the records read back from a parquet file
+	defaultReadBatchSize = 64 * 1024
+)
+
+// TableChecksum holds the row count and the checksums of the columns of a table or of a parquet file
+type TableChecksum struct {
+	RowCount int64
+	Columns  []ColumnChecksum
+}
+
+// ColumnChecksum holds the aggregates of the non null values of a column
+type ColumnChecksum struct {
+	Name      string
+	NullCount int64
+	// HashSum is the sum of the 64 bits hashes of the values, as a decimal number that cannot overflow
+	HashSum string
+	Min     string
+	Max     string
+}
+
+// ChecksumDifference is a check giving different results in the source table and in the written file
+type ChecksumDifference struct {
+	// Column is empty for the row count
+	Column string
+	Check  string
+	Source string
+	File   string
+}
+
+// VerificationError is returned when the file written does not match the table it was exported from
+type VerificationError struct {
+	Location    string
+	Differences []ChecksumDifference
+}
+
+func (e *VerificationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "verification of %s failed with %d differences:", e.Location, len(e.Differences))
+	for _, d := range e.Differences {
+		if d.Column == "" {
+			fmt.Fprintf(&sb, "\n  %s: table %s, file %s", d.Check, d.Source, d.File)
+		} else {
+			fmt.Fprintf(&sb, "\n  column %s %s: table %s, file %s", d.Column, d.Check, d.Source, d.File)
+		}
+	}
+	return sb.String()
+}
+
+// checkVerifyOptions returns an error when the export written at location cannot be verified
+func checkVerifyOptions(location string, options WriterOptions) error {
+	if !options.Verify {
+		return nil
+	}
+	if options.Format != "" && options.Format != FormatParquet {
+		return fmt.Errorf("only the parquet files can be verified, not the %s format", options.Format)
+	}
+	if location == sink.StdoutLocation {
+		return errors.New("an export written to the standard output cannot be verified")
+	}
+	return nil
+}
+
+// checksumExpressions returns the canonical value of the column, the bytes hashed for its checksum
+// and the aggregates returning its min and max
+func checksumExpressions(field arrow.Field) (value string, hashed string, minAgg string, maxAgg string, err error) {
+	column := pgx.Identifier{field.Name}.Sanitize()
+	switch field.Type.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64:
+		value = column + "::int8"
+		hashed = "int8send(" + value + ")"
+	case arrow.FLOAT32:
+		value = column + "::float4"
+		hashed = "float4send(" + value + ")"
+	case arrow.FLOAT64:
+		value = column + "::float8"
+		hashed = "float8send(" + value + ")"
+	case arrow.STRING, arrow.LARGE_STRING:
+		// character(n) is exported with its padding, which the cast to text removes but not its output function
+		if pgType, _ := field.Metadata.GetValue(db2arrow.MetadataKeyPgType); isPaddedCharacterType(pgType) {
+			value = fmt.Sprintf(`((CASE WHEN %s IS NOT NULL THEN concat(%s) END) COLLATE "C")`, column, column)
+		} else {
+			value = fmt.Sprintf(`(%s::text COLLATE "C")`, column)
+		}
+		hashed = "convert_to(" + value + ", 'UTF8')"
+	case arrow.BINARY, arrow.LARGE_BINARY:
+		value = column + "::bytea"
+		hashed = value
+	case arrow.BOOL:
+		value = column + "::bool"
+		return value, "boolsend(" + value + ")", "bool_and(" + value + ")", "bool_or(" + value + ")", nil
+	case arrow.DATE32:
+		value = fmt.Sprintf("(%s - DATE '1970-01-01')::int4", column)
+		hashed = "int4send(" + value + ")"
+	case arrow.TIMESTAMP:
+		value = fmt.Sprintf("(extract(epoch FROM %s) * 1000000)::int8", column)
+		hashed = "int8send(" + value + ")"
+	default:
+		return "", "", "", "", fmt.Errorf("no checksum for column %s of type %s", field.Name, field.Type)
+	}
+	return value, hashed, "min(" + value + ")", "max(" + value + ")", nil
+}
+
+// isPaddedCharacterType returns true for the blank padded character(n) type
+func isPaddedCharacterType(pgType string) bool {
+	return pgType == "character" || pgType == "bpchar" || strings.HasPrefix(pgType, "character(")
+}
+
+// tableChecksum computes the row count and the checksums of the columns of the schema in the table,
+// with one scan of the table inside the transaction of the export
+func tableChecksum(ctx context.Context, tx pgx.Tx, schemaName string, tableName string, schema *arrow.Schema) (*TableChecksum, error) {
+	selects := []string{"count(*)"}
+	for _, field := range schema.Fields() {
+		_, hashed, minAgg, maxAgg, err := checksumExpressions(field)
+		if err != nil {
+			return nil, err
+		}
+		selects = append(selects, "count("+pgx.Identifier{field.Name}.Sanitize()+")", fmt.Sprintf(hashSumExpr, hashed), minAgg, maxAgg)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), pgx.Identifier{schemaName, tableName}.Sanitize())
+	values := make([]interface{}, len(selects))
+	dest := make([]interface{}, len(selects))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := tx.QueryRow(ctx, query).Scan(dest...); err != nil {
+		return nil, fmt.Errorf("failed to compute the checksums of table %s.%s: %w", schemaName, tableName, err)
+	}
+	checksum := &TableChecksum{RowCount: values[0].(int64)}
+	for i, field := range schema.Fields() {
+		v := values[1+4*i:]
+		checksum.Columns = append(checksum.Columns, ColumnChecksum{
+			Name:      field.Name,
+			NullCount: checksum.RowCount - v[0].(int64),
+			HashSum:   v[1].(string),
+			Min:       formatCanonical(field, v[2]),
+			Max:       formatCanonical(field, v[3]),
+		})
+	}
+	return checksum, nil
+}
+
+// ParquetFileChecksum computes the row count and the checksums of the columns of the schema in the parquet file
+// at location, a local path or an s3://bucket/key object. The columns are matched by name,
+// an encrypted file is read with the keys of encryption.
+func ParquetFileChecksum(ctx context.Context, location string, schema *arrow.Schema, encryption *EncryptionOptions, mem memory.Allocator) (*TableChecksum, error) {
+	r, err := sink.OpenReader(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	pf, err := file.NewParquetReader(r, file.WithReadProps(encryption.ReaderProperties(mem)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parquet file %s: %w", location, err)
+	}
+	defer pf.Close()
+	reader, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: defaultReadBatchSize}, mem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Arrow reader for %s: %w", location, err)
+	}
+	recordReader, err := reader.GetRecordReader(ctx, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records of %s: %w", location, err)
+	}
+	defer recordReader.Release()
+
+	accumulators := make([]*checksumAccumulator, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		accumulators[i] = &checksumAccumulator{field: field}
+	}
+	checksum := &TableChecksum{}
+	for {
+		record, err := recordReader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read records of %s: %w", location, err)
+		}
+		checksum.RowCount += record.NumRows()
+		for _, acc := range accumulators {
+			indices := record.Schema().FieldIndices(acc.field.Name)
+			if len(indices) == 0 {
+				return nil, fmt.Errorf("column %s not found in %s", acc.field.Name, location)
+			}
+			if err := acc.add(record.Column(indices[0])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, acc := range accumulators {
+		checksum.Columns = append(checksum.Columns, acc.checksum())
+	}
+	return checksum, nil
+}
+
+// verifyParquetFile compares the checksums of the table computed by PostgreSQL with the ones of the file
+// and the number of rows written, it returns a *VerificationError listing the differences
+func verifyParquetFile(ctx context.Context, location string, schema *arrow.Schema, numRowsWritten int64, expected *TableChecksum, encryption *EncryptionOptions, mem memory.Allocator) error {
+	actual, err := ParquetFileChecksum(ctx, location, schema, encryption, mem)
+	if err != nil {
+		return err
+	}
+	verr := &VerificationError{Location: location}
+	if expected.RowCount != numRowsWritten {
+		verr.Differences = append(verr.Differences, ChecksumDifference{Check: "rows exported",
+			Source: strconv.FormatInt(expected.RowCount, 10), File: strconv.FormatInt(numRowsWritten, 10)})
+	}
+	if expected.RowCount != actual.RowCount {
+		verr.Differences = append(verr.Differences, ChecksumDifference{Check: "row count",
+			Source: strconv.FormatInt(expected.RowCount, 10), File: strconv.FormatInt(actual.RowCount, 10)})
+	}
+	for i, e := range expected.Columns {
+		a := actual.Columns[i]
+		checks := []struct{ name, source, file string }{
+			{"null count", strconv.FormatInt(e.NullCount, 10), strconv.FormatInt(a.NullCount, 10)},
+			{"hash sum", e.HashSum, a.HashSum},
+			{"min", e.Min, a.Min},
+			{"max", e.Max, a.Max},
+		}
+		for _, c := range checks {
+			if c.source != c.file {
+				verr.Differences = append(verr.Differences, ChecksumDifference{Column: e.Name, Check: c.name, Source: c.source, File: c.file})
+			}
+		}
+	}
+	if len(verr.Differences) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// checksumAccumulator computes the checksum of one column from the Arrow arrays read from a parquet file
+type checksumAccumulator struct {
+	field   arrow.Field
+	nulls   int64
+	hashSum big.Int
+	min     interface{}
+	max     interface{}
+	hash    big.Int
+	buf     [8]byte
+}
+
+func (c *checksumAccumulator) add(arr arrow.Array) error {
+	for i := 0; i < arr.Len(); i++ {
+		if arr.IsNull(i) {
+			c.nulls++
+			continue
+		}
+		value, hashed, err := c.canonicalValue(arr, i)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(hashed)
+		c.hash.SetInt64(int64(binary.BigEndian.Uint64(sum[:8])))
+		c.hashSum.Add(&c.hashSum, &c.hash)
+		if c.min == nil || compareCanonical(value, c.min) < 0 {
+			c.min = retainCanonical(value)
+		}
+		if c.max == nil || compareCanonical(value, c.max) > 0 {
+			c.max = retainCanonical(value)
+		}
+	}
+	return nil
+}
+
+// canonicalValue returns the canonical value of the row i of the array and the bytes hashed by PostgreSQL for it
+func (c *checksumAccumulator) canonicalValue(arr arrow.Array, i int) (interface{}, []byte, error) {
+	switch a := arr.(type) {
+	case *array.Int8:
+		return c.int64Value(int64(a.Value(i)))
+	case *array.Int16:
+		return c.int64Value(int64(a.Value(i)))
+	case *array.Int32:
+		return c.int64Value(int64(a.Value(i)))
+	case *array.Int64:
+		return c.int64Value(a.Value(i))
+	case *array.Float32:
+		binary.BigEndian.PutUint32(c.buf[:4], math.Float32bits(a.Value(i)))
+		return a.Value(i), c.buf[:4], nil
+	case *array.Float64:
+		binary.BigEndian.PutUint64(c.buf[:], math.Float64bits(a.Value(i)))
+		return a.Value(i), c.buf[:], nil
+	case *array.String:
+		return a.Value(i), []byte(a.Value(i)), nil
+	case *array.LargeString:
+		return a.Value(i), []byte(a.Value(i)), nil
+	case *array.Binary:
+		return a.Value(i), a.Value(i), nil
+	case *array.LargeBinary:
+		return a.Value(i), a.Value(i), nil
+	case *array.Boolean:
+		c.buf[0] = 0
+		if a.Value(i) {
+			c.buf[0] = 1
+		}
+		return a.Value(i), c.buf[:1], nil
+	case *array.Date32:
+		binary.BigEndian.PutUint32(c.buf[:4], uint32(a.Value(i)))
+		return int32(a.Value(i)), c.buf[:4], nil
+	case *array.Timestamp:
+		unit := a.DataType().(*arrow.TimestampType).Unit
+		return c.int64Value(a.Value(i).ToTime(unit).UnixMicro())
+	default:
+		return nil, nil, fmt.Errorf("no checksum for column %s of type %s", c.field.Name, arr.DataType())
+	}
+}
+
+func (c *checksumAccumulator) int64Value(v int64) (interface{}, []byte, error) {
+	binary.BigEndian.PutUint64(c.buf[:], uint64(v))
+	return v, c.buf[:], nil
+}
+
+func (c *checksumAccumulator) checksum() ColumnChecksum {
+	return ColumnChecksum{
+		Name:      c.field.Name,
+		NullCount: c.nulls,
+		HashSum:   c.hashSum.String(),
+		Min:       formatCanonical(c.field, c.min),
+		Max:       formatCanonical(c.field, c.max),
+	}
+}
+
+// retainCanonical copies the strings and bytes that point into the buffers of the Arrow array
+func retainCanonical(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		return strings.Clone(x)
+	case []byte:
+		return slices.Clone(x)
+	default:
+		return v
+	}
+}
+
+// compareCanonical compares two canonical values of the same column like PostgreSQL,
+// NaN is greater than any other number, false is lower than true
+func compareCanonical(a, b interface{}) int {
+	switch x := a.(type) {
+	case int64:
+		return cmp.Compare(x, b.(int64))
+	case int32:
+		return cmp.Compare(x, b.(int32))
+	case float32:
+		return compareFloat(float64(x), float64(b.(float32)))
+	case float64:
+		return compareFloat(x, b.(float64))
+	case string:
+		return strings.Compare(x, b.(string))
+	case []byte:
+		return strings.Compare(string(x), string(b.([]byte)))
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		} else if !x {
+			return -1
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return 1
+	case math.IsNaN(b):
+		return -1
+	default:
+		return cmp.Compare(a, b)
+	}
+}
+
+// formatCanonical formats a canonical value of the field for the verification report
+func formatCanonical(field arrow.Field, v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case []byte:
+		return `\x` + hex.EncodeToString(x)
+	case string:
+		return strconv.Quote(x)
+	case int32:
+		if field.Type.ID() == arrow.DATE32 {
+			return time.Unix(int64(x)*86400, 0).UTC().Format(time.DateOnly)
+		}
+	case int64:
+		if field.Type.ID() == arrow.TIMESTAMP {
+			return time.UnixMicro(x).UTC().Format("2006-01-02 15:04:05.999999")
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,231 @@
+package db2parquet
+
+import (
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// Format is the file format written by an export
+type Format string
+
+const (
+	// FormatParquet writes a parquet file
+	FormatParquet Format = "parquet"
+	// FormatArrowFile writes an Arrow IPC file (Feather v2) that can be memory-mapped by the readers
+	FormatArrowFile Format = "arrow"
+	// FormatArrowStream writes an Arrow IPC stream, it can be written to stdout
+	FormatArrowStream Format = "arrow-stream"
+	// FormatCSV writes a CSV file with the layout of the CsvOptions
+	FormatCSV Format = "csv"
+	// FormatNDJSON writes one JSON object per row and per line, the NaN and infinite floats as the strings "NaN", "Infinity" and "-Infinity"
+	FormatNDJSON Format = "ndjson"
+)
+
+// Compression is the codec used to compress the parquet pages or the Arrow IPC buffers
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionLz4  Compression = "lz4"
+	CompressionZstd Compression = "zstd"
+)
+
+// WriterOptions defines the format and the compression of the exported files
+type WriterOptions struct {
+	Format      Format
+	Compression Compression
+	// Csv defines the layout of the CSV format, its TimestampLayout is also used by the NDJSON format
+	Csv CsvOptions
+	// Memory defines the allocator and the memory budget of the export
+	Memory MemoryOptions
+	// Converters is the number of goroutines converting the fetched rows to Arrow, default 1
+	Converters int
+	// Progress receives the rows and bytes written, with the row estimate of the table, nil disables the reports
+	Progress ProgressFunc
+	// Verify compares the parquet file written with the table, using count(*) and column checksums computed
+	// by PostgreSQL in the snapshot of the export, the export fails with a *VerificationError when they differ
+	Verify bool
+	// Encryption encrypts the parquet file with the Parquet modular encryption, nil writes it in clear
+	Encryption *EncryptionOptions
+}
+
+// ParseFormat returns the Format corresponding to the given string, feather is accepted for the Arrow IPC file format
+func ParseFormat(format string) (Format, error) {
+	switch format {
+	case string(FormatParquet), string(FormatArrowFile), string(FormatArrowStream), string(FormatCSV), string(FormatNDJSON):
+		return Format(format), nil
+	case "feather":
+		return FormatArrowFile, nil
+	default:
+		return "", fmt.Errorf("invalid format %s, expected one of parquet, arrow, arrow-stream, csv, ndjson", format)
+	}
+}
+
+// ParseCompression returns the Compression corresponding to the given string
+func ParseCompression(compression string) (Compression, error) {
+	switch Compression(compression) {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionLz4, CompressionZstd:
+		return Compression(compression), nil
+	default:
+		return "", fmt.Errorf("invalid compression %s, expected one of none, lz4, zstd", compression)
+	}
+}
+
+// Extension returns the usual file extension of the format
+func (f Format) Extension() string {
+	switch f {
+	case FormatArrowFile:
+		return ".arrow"
+	case FormatArrowStream:
+		return ".arrows"
+	case FormatCSV:
+		return ".csv"
+	case FormatNDJSON:
+		return ".ndjson"
+	default:
+		return ".parquet"
+	}
+}
+
+// RecordWriter receives the Arrow RecordBatch produced from the rows of a query
+type RecordWriter interface {
+	// Write writes one RecordBatch, the record is not retained after the call
+	Write(record arrow.Record) error
+	// Close flushes the buffered data and writes the footer, it does not close the underlying io.Writer
+	Close() error
+}
+
+// NewRecordWriter returns a RecordWriter writing the records to w in the format of the options.
+// The key-value metadata is stored in the parquet footer or in the schema of the Arrow IPC formats,
+// the CSV and NDJSON formats have no place for it.
+func NewRecordWriter(w io.Writer, schema *arrow.Schema, kvMetadata map[string]string, options WriterOptions) (RecordWriter, error) {
+	mem := options.Memory.allocator()
+	if options.Encryption != nil && options.Format != "" && options.Format != FormatParquet {
+		return nil, fmt.Errorf("encryption is not supported by the %s format", options.Format)
+	}
+	switch options.Format {
+	case "", FormatParquet:
+		return newParquetRecordWriter(w, schema, kvMetadata, options.Compression, options.Encryption, mem)
+	case FormatArrowFile, FormatArrowStream:
+		return newIpcRecordWriter(w, schema, kvMetadata, options, mem)
+	case FormatCSV, FormatNDJSON:
+		if options.Compression != "" && options.Compression != CompressionNone {
+			return nil, fmt.Errorf("compression %s is not supported by the %s format", options.Compression, options.Format)
+		}
+		if options.Format == FormatCSV {
+			return newCsvRecordWriter(w, schema, options.Csv, mem), nil
+		}
+		return newNdjsonRecordWriter(w, schema, options.Csv.TimestampLayout)
+	default:
+		return nil, fmt.Errorf("unsupported format %s", options.Format)
+	}
+}
+
+// parquetRecordWriter writes each record in a new row group of a parquet file
+type parquetRecordWriter struct {
+	writer     *pqarrow.FileWriter
+	kvMetadata map[string]string
+}
+
+func newParquetRecordWriter(w io.Writer, schema *arrow.Schema, kvMetadata map[string]string, compression Compression, encryption *EncryptionOptions, mem memory.Allocator) (*parquetRecordWriter, error) {
+	if err := encryption.check(); err != nil {
+		return nil, err
+	}
+	props := append([]parquet.WriterProperty{parquet.WithAllocator(mem)}, encryption.writerProperties()...)
+	switch compression {
+	case CompressionLz4:
+		props = append(props, parquet.WithCompression(compress.Codecs.Lz4Raw))
+	case CompressionZstd:
+		props = append(props, parquet.WithCompression(compress.Codecs.Zstd))
+	}
+	writer, err := pqarrow.NewFileWriter(schema, w, parquet.NewWriterProperties(props...), newArrowWriterProperties())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Parquet writer: %w", err)
+	}
+	return &parquetRecordWriter{writer: writer, kvMetadata: kvMetadata}, nil
+}
+
+func (p *parquetRecordWriter) Write(record arrow.Record) error {
+	if err := p.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write RecordBatch: %w", err)
+	}
+	return nil
+}
+
+func (p *parquetRecordWriter) Close() error {
+	if err := appendKeyValueMetadata(p.writer, p.kvMetadata); err != nil {
+		p.writer.Close()
+		return err
+	}
+	if err := p.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close Parquet writer: %w", err)
+	}
+	return nil
+}
+
+// ipcWriter is implemented by the Arrow IPC file and stream writers
+type ipcWriter interface {
+	Write(record arrow.Record) error
+	Close() error
+}
+
+// ipcRecordWriter writes the records in the Arrow IPC file or stream format
+type ipcRecordWriter struct {
+	writer ipcWriter
+	schema *arrow.Schema
+}
+
+func newIpcRecordWriter(w io.Writer, schema *arrow.Schema, kvMetadata map[string]string, options WriterOptions, mem memory.Allocator) (*ipcRecordWriter, error) {
+	if len(kvMetadata) > 0 {
+		md := make(map[string]string, schema.Metadata().Len()+len(kvMetadata))
+		for i, key := range schema.Metadata().Keys() {
+			md[key] = schema.Metadata().Values()[i]
+		}
+		maps.Copy(md, kvMetadata)
+		meta := arrow.MetadataFrom(md)
+		schema = arrow.NewSchema(schema.Fields(), &meta)
+	}
+	opts := []ipc.Option{ipc.WithSchema(schema), ipc.WithAllocator(mem)}
+	switch options.Compression {
+	case CompressionLz4:
+		opts = append(opts, ipc.WithLZ4())
+	case CompressionZstd:
+		opts = append(opts, ipc.WithZstd())
+	}
+	if options.Format == FormatArrowStream {
+		return &ipcRecordWriter{writer: ipc.NewWriter(w, opts...), schema: schema}, nil
+	}
+	writer, err := ipc.NewFileWriter(w, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Arrow IPC file writer: %w", err)
+	}
+	return &ipcRecordWriter{writer: writer, schema: schema}, nil
+}
+
+func (a *ipcRecordWriter) Write(record arrow.Record) error {
+	// the record is rebuilt with the schema holding the key-value metadata written in the IPC schema message
+	rec := array.NewRecord(a.schema, record.Columns(), record.NumRows())
+	defer rec.Release()
+	if err := a.writer.Write(rec); err != nil {
+		return fmt.Errorf("failed to write RecordBatch: %w", err)
+	}
+	return nil
+}
+
+func (a *ipcRecordWriter) Close() error {
+	if err := a.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close Arrow IPC writer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package parquet2db
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// recordCopySource streams the rows of an Arrow RecordBatch to pgx.CopyFrom
+type recordCopySource struct {
+	record arrow.Record
+	row    int
+	values []interface{}
+	err    error
+}
+
+func newRecordCopySource(record arrow.Record) *recordCopySource {
+	return &recordCopySource{record: record, row: -1, values: make([]interface{}, record.NumCols())}
+}
+
+func (s *recordCopySource) Next() bool {
+	s.row++
+	return s.err == nil && s.row < int(s.record.NumRows())
+}
+
+func (s *recordCopySource) Values() ([]interface{}, error) {
+	for i, col := range s.record.Columns() {
+		val, err := arrowValue(col, s.row)
+		if err != nil {
+			s.err = fmt.Errorf("column %s, row %d: %w", s.record.ColumnName(i), s.row, err)
+			return nil, s.err
+		}
+		s.values[i] = val
+	}
+	return s.values, nil
+}
+
+func (s *recordCopySource) Err() error {
+	return s.err
+}
+
+// arrowValue returns the value at index i of the Arrow array as a Go value that pgx can encode
+func arrowValue(arr arrow.Array, i int) (interface{}, error) {
+	if arr.IsNull(i) {
+		return nil, nil
+	}
+	switch a := arr.(type) {
+	case *array.Int8:
+		return int16(a.Value(i)), nil
+	case *array.Uint8:
+		return int16(a.Value(i)), nil
+	case *array.Int16:
+		return a.Value(i), nil
+	case *array.Uint16:
+		return int32(a.Value(i)), nil
+	case *array.Int32:
+		return a.Value(i), nil
+	case *array.Uint32:
+		return int64(a.Value(i)), nil
+	case *array.Int64:
+		return a.Value(i), nil
+	case *array.Uint64:
+		return pgtype.Numeric{Int: new(big.Int).SetUint64(a.Value(i)), Valid: true}, nil
+	case *array.Float16:
+		return a.Value(i).Float32(), nil
+	case *array.Float32:
+		return a.Value(i), nil
+	case *array.Float64:
+		return a.Value(i), nil
+	case *array.Decimal128:
+		scale := a.DataType().(*arrow.Decimal128Type).Scale
+		return pgtype.Numeric{Int: a.Value(i).BigInt(), Exp: -scale, Valid: true}, nil
+	case *array.Decimal256:
+		scale := a.DataType().(*arrow.Decimal256Type).Scale
+		return pgtype.Numeric{Int: a.Value(i).BigInt(), Exp: -scale, Valid: true}, nil
+	case *array.String:
+		return a.Value(i), nil
+	case *array.LargeString:
+		return a.Value(i), nil
+	case *array.StringView:
+		return a.Value(i), nil
+	case *array.Binary:
+		return a.Value(i), nil
+	case *array.LargeBinary:
+		return a.Value(i), nil
+	case *array.BinaryView:
+		return a.Value(i), nil
+	case *array.FixedSizeBinary:
+		return a.Value(i), nil
+	case *array.Boolean:
+		return a.Value(i), nil
+	case *array.Date32:
+		return a.Value(i).ToTime(), nil
+	case *array.Date64:
+		return a.Value(i).ToTime(), nil
+	case *array.Timestamp:
+		unit := a.DataType().(*arrow.TimestampType).Unit
+		return a.Value(i).ToTime(unit), nil
+	case *array.Time32:
+		unit := a.DataType().(*arrow.Time32Type).Unit
+		return pgtype.Time{Microseconds: int64(a.Value(i)) * int64(unit.Multiplier()/arrow.Microsecond.Multiplier()), Valid: true}, nil
+	case *array.Time64:
+		unit := a.DataType().(*arrow.Time64Type).Unit
+		if unit == arrow.Nanosecond {
+			return pgtype.Time{Microseconds: int64(a.Value(i)) / 1000, Valid: true}, nil
+		}
+		return pgtype.Time{Microseconds: int64(a.Value(i)), Valid: true}, nil
+	case *array.Dictionary:
+		return arrowValue(a.Dictionary(), a.GetValueIndex(i))
+	case array.ListLike:
+		start, end := a.ValueOffsets(i)
+		values := a.ListValues()
+		list := make([]interface{}, 0, end-start)
+		for j := start; j < end; j++ {
+			val, err := arrowValue(values, int(j))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, val)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported Arrow data type: %s", arr.DataType())
+	}
+}
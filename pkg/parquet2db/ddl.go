@@ -0,0 +1,77 @@
+package parquet2db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/jackc/pgx/v5"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2parquet"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+// CreateTableStatements returns the CREATE TABLE statement for the Arrow schema followed by the COMMENT statements.
+// The original types, primary key and comments stored in the metadata of files exported by db2parquet are used when present,
+// the stored types that are not plain type names are replaced by the mapping of the Arrow types (see db2arrow.MapArrowField).
+func CreateTableStatements(schemaName string, tableName string, schema *arrow.Schema) ([]string, error) {
+	table := pgx.Identifier{schemaName, tableName}.Sanitize()
+	definitions := make([]string, 0, len(schema.Fields())+1)
+	var primaryKey []string
+	var comments []string
+	for _, field := range schema.Fields() {
+		pgType, err := db2arrow.MapArrowField(field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map column %s: %w", field.Name, err)
+		}
+		column := pgx.Identifier{field.Name}.Sanitize()
+		definition := fmt.Sprintf("    %s %s", column, pgType)
+		if !field.Nullable {
+			definition += " NOT NULL"
+		}
+		definitions = append(definitions, definition)
+		if value, found := field.Metadata.GetValue(db2arrow.MetadataKeyPrimaryKey); found && value == "true" {
+			primaryKey = append(primaryKey, column)
+		}
+		if comment, found := field.Metadata.GetValue(db2arrow.MetadataKeyComment); found {
+			comments = append(comments, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s", table, column, quoteLiteral(comment)))
+		}
+	}
+	if len(primaryKey) > 0 {
+		definitions = append(definitions, fmt.Sprintf("    PRIMARY KEY (%s)", strings.Join(primaryKey, ", ")))
+	}
+	statements := []string{fmt.Sprintf("CREATE TABLE %s (\n%s\n)", table, strings.Join(definitions, ",\n"))}
+	if comment, found := schema.Metadata().GetValue(db2parquet.MetadataKeyTableComment); found {
+		statements = append(statements, fmt.Sprintf("COMMENT ON TABLE %s IS %s", table, quoteLiteral(comment)))
+	}
+	return append(statements, comments...), nil
+}
+
+// checkStoredPgTypes verifies that the types stored in the metadata of the file exist in the database before they
+// are used by CreateTableStatements, the names are sent as parameters so they are never parsed as statements
+func checkStoredPgTypes(ctx context.Context, tx pgx.Tx, schema *arrow.Schema, log golog.MyLogger) error {
+	for _, field := range schema.Fields() {
+		pgType, found := field.Metadata.GetValue(db2arrow.MetadataKeyPgType)
+		if !found {
+			continue
+		}
+		if !db2arrow.IsValidPgType(pgType) {
+			log.Warn("ignoring the stored type %q of column %s, it is not a type name, the type is mapped from %s", pgType, field.Name, field.Type)
+			continue
+		}
+		var resolved *string
+		if err := tx.QueryRow(ctx, "SELECT to_regtype($1)::text", pgType).Scan(&resolved); err != nil {
+			return fmt.Errorf("failed to check the type %s of column %s: %w", pgType, field.Name, err)
+		}
+		if resolved == nil {
+			return fmt.Errorf("the type %s of column %s does not exist in the database", pgType, field.Name)
+		}
+	}
+	return nil
+}
+
+// quoteLiteral returns the string as a quoted PostgresSQL literal
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
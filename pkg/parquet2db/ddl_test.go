@@ -0,0 +1,31 @@
+package parquet2db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+)
+
+func TestCreateTableStatementsIgnoresInjectedTypes(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int32,
+			Metadata: arrow.NewMetadata([]string{db2arrow.MetadataKeyPgType}, []string{"int); DROP TABLE x; --"})},
+		{Name: "amount", Type: &arrow.Decimal128Type{Precision: 12, Scale: 2}, Nullable: true,
+			Metadata: arrow.NewMetadata([]string{db2arrow.MetadataKeyPgType}, []string{"numeric(12,2)"})},
+		{Name: "owner", Type: arrow.BinaryTypes.String, Nullable: true,
+			Metadata: arrow.NewMetadata([]string{db2arrow.MetadataKeyPgType}, []string{"text default current_user"})},
+	}, nil)
+	statements, err := CreateTableStatements("public", "t", schema)
+	if err != nil {
+		t.Fatalf("CreateTableStatements() error: %v", err)
+	}
+	want := "CREATE TABLE \"public\".\"t\" (\n    \"id\" integer NOT NULL,\n    \"amount\" numeric(12,2),\n    \"owner\" text\n)"
+	if len(statements) != 1 || statements[0] != want {
+		t.Fatalf("CreateTableStatements() = %q, want %q", statements, want)
+	}
+	if strings.Contains(statements[0], "DROP") || strings.Contains(statements[0], "default") {
+		t.Errorf("the stored type was written in the statement: %s", statements[0])
+	}
+}
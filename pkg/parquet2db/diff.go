@@ -0,0 +1,402 @@
+package parquet2db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/recordsort"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+// DiffKind is the kind of difference found for a key
+type DiffKind string
+
+const (
+	// DiffMissing is a row of the table absent from the file
+	DiffMissing DiffKind = "missing"
+	// DiffExtra is a row of the file absent from the table
+	DiffExtra DiffKind = "extra"
+	// DiffChanged is a row present on both sides with different values
+	DiffChanged DiffKind = "changed"
+)
+
+// DiffOptions defines how a parquet file is compared to a table
+type DiffOptions struct {
+	// KeyColumns identifies the rows, the default is the primary key of the table
+	KeyColumns []string
+	// Columns restricts the compared columns, the default is every column present in the file and in the table
+	Columns []string
+	// SortRunRows is the number of rows of the file sorted in memory, larger files are sorted in runs
+	// spilled to TempDir, 0 uses a default of 500000 rows
+	SortRunRows int
+	// TempDir receives the sorted runs of the file, the default is the temporary directory of the os
+	TempDir string
+	// Report receives every difference in key order, an error stops the comparison
+	Report func(difference RowDifference) error
+}
+
+// CellDifference is a column with different values in the table and in the file
+type CellDifference struct {
+	Column     string `json:"column"`
+	TableValue string `json:"table_value"`
+	FileValue  string `json:"file_value"`
+}
+
+// RowDifference is a key missing on one side or with different values
+type RowDifference struct {
+	Kind DiffKind `json:"kind"`
+	// Key is the formatted key of the row: col1=value1, col2=value2
+	Key   string           `json:"key"`
+	Cells []CellDifference `json:"cells,omitempty"`
+}
+
+// DiffResult summarizes the comparison of a parquet file with a table
+type DiffResult struct {
+	KeyColumns []string
+	Columns    []string
+	// TableOnlyColumns and FileOnlyColumns are not compared
+	TableOnlyColumns []string
+	FileOnlyColumns  []string
+	TableRows        int64
+	FileRows         int64
+	Missing          int64
+	Extra            int64
+	Changed          int64
+}
+
+// Identical returns true when no row differs
+func (r *DiffResult) Identical() bool {
+	return r.Missing == 0 && r.Extra == 0 && r.Changed == 0
+}
+
+// DiffParquetFile compares the rows of a parquet file, a local path or an s3://bucket/key object, with the rows
+// of the table, matching them on the key columns. Both sides are streamed in key order: the table with ORDER BY
+// and the file with an external sort, so the memory used does not depend on the size of the table.
+// The text keys are ordered byte by byte with the "C" collation on both sides.
+func DiffParquetFile(
+	ctx context.Context,
+	dbConn *pgxpool.Pool,
+	parquetFilePath string,
+	schemaName string,
+	tableName string,
+	tableColumns []db.ColumnInfo,
+	options DiffOptions,
+	log golog.MyLogger) (*DiffResult, error) {
+	r, err := sink.OpenReader(ctx, parquetFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	pf, err := file.NewParquetReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Parquet file %s: %w", parquetFilePath, err)
+	}
+	defer pf.Close()
+	mem := memory.DefaultAllocator
+	reader, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: recordsort.DefaultBatchRows}, mem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Arrow reader for %s: %w", parquetFilePath, err)
+	}
+	schema, err := reader.Schema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Arrow schema of %s: %w", parquetFilePath, err)
+	}
+	result, err := diffColumns(schema, tableColumns, options)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compare %s with table %s.%s: %w", parquetFilePath, schemaName, tableName, err)
+	}
+	log.Info("comparing %d columns of %s with table %s.%s on key %s", len(result.Columns), parquetFilePath, schemaName, tableName, strings.Join(result.KeyColumns, ", "))
+	if len(result.TableOnlyColumns) > 0 {
+		log.Warn("columns of table %s.%s absent from the file are not compared: %s", schemaName, tableName, strings.Join(result.TableOnlyColumns, ", "))
+	}
+	if len(result.FileOnlyColumns) > 0 {
+		log.Warn("columns of %s absent from the table are not compared: %s", parquetFilePath, strings.Join(result.FileOnlyColumns, ", "))
+	}
+
+	fileColumns := make([]int, len(result.Columns))
+	for i, name := range result.Columns {
+		fileColumns[i] = schema.FieldIndices(name)[0]
+	}
+	keyPositions := make([]int, len(result.KeyColumns))
+	for i, name := range result.KeyColumns {
+		keyPositions[i] = slices.Index(result.Columns, name)
+	}
+	// the record reader expects the indices of the leaf columns, they differ from the field indices with nested types
+	leafColumns, err := reader.Manifest.GetFieldIndices(fileColumns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select columns of %s: %w", parquetFilePath, err)
+	}
+	recordReader, err := reader.GetRecordReader(ctx, leafColumns, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records of %s: %w", parquetFilePath, err)
+	}
+	defer recordReader.Release()
+	tempDir := options.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	fileRows, err := sortRecordsByKey(ctx, recordReader, keyPositions, options.SortRunRows, tempDir, mem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sort %s by key: %w", parquetFilePath, err)
+	}
+	defer fileRows.close()
+	log.Info("rows of %s sorted by key", parquetFilePath)
+
+	tx, err := dbConn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func(tx pgx.Tx, ctx context.Context) {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Error("failed to rollback transaction: %v", err)
+		}
+	}(tx, context.WithoutCancel(ctx))
+	// pgx reads the rows from the connection while they are consumed, they are never all in memory
+	rows, err := tx.Query(ctx, diffQuery(schemaName, tableName, tableColumns, result))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table %s.%s: %w", schemaName, tableName, err)
+	}
+	defer rows.Close()
+	tableRows := &queryRowIterator{rows: rows}
+
+	if err := mergeDiff(tableRows, fileRows, keyPositions, result, options.Report); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return result, nil
+}
+
+// diffColumns returns the result holding the key and the compared columns, in the order of the file
+func diffColumns(schema *arrow.Schema, tableColumns []db.ColumnInfo, options DiffOptions) (*DiffResult, error) {
+	result := &DiffResult{KeyColumns: options.KeyColumns}
+	inTable := make(map[string]bool, len(tableColumns))
+	for _, col := range tableColumns {
+		inTable[col.Name] = true
+		if len(options.KeyColumns) == 0 && col.IsPrimaryKey {
+			result.KeyColumns = append(result.KeyColumns, col.Name)
+		}
+		if len(schema.FieldIndices(col.Name)) == 0 {
+			result.TableOnlyColumns = append(result.TableOnlyColumns, col.Name)
+		}
+	}
+	if len(result.KeyColumns) == 0 {
+		return nil, errors.New("the table has no primary key, the key columns must be given")
+	}
+	for _, field := range schema.Fields() {
+		if !inTable[field.Name] {
+			result.FileOnlyColumns = append(result.FileOnlyColumns, field.Name)
+			continue
+		}
+		if len(options.Columns) == 0 || slices.Contains(options.Columns, field.Name) || slices.Contains(result.KeyColumns, field.Name) {
+			result.Columns = append(result.Columns, field.Name)
+		}
+	}
+	for _, name := range append(slices.Clone(result.KeyColumns), options.Columns...) {
+		if !slices.Contains(result.Columns, name) {
+			return nil, fmt.Errorf("column %s must exist in the file and in the table", name)
+		}
+	}
+	for _, name := range result.KeyColumns {
+		field, _ := schema.FieldsByName(name)
+		if !isOrderedKeyType(field[0].Type) {
+			return nil, fmt.Errorf("key column %s of type %s has no defined order", name, field[0].Type)
+		}
+		col := tableColumns[slices.IndexFunc(tableColumns, func(c db.ColumnInfo) bool { return c.Name == name })]
+		if !isByteOrderedTableType(col.DataType) {
+			return nil, fmt.Errorf("key column %s of type %s is not ordered by the table like by the file, other key columns must be given",
+				name, columnType(col))
+		}
+	}
+	return result, nil
+}
+
+// isByteOrderedTableType returns true for the table types the query orders like the file is sorted: the numbers,
+// the text types with the "C" collation and the types compared by value. The user-defined types like citext,
+// ordered case-insensitively, or the enums, ordered by declaration, are reported as USER-DEFINED and excluded.
+func isByteOrderedTableType(dataType string) bool {
+	if isTextType(dataType) {
+		return true
+	}
+	switch dataType {
+	case "smallint", "integer", "bigint", "numeric", "real", "double precision", "bytea", "boolean", "uuid", "date",
+		"timestamp without time zone", "timestamp with time zone", "time without time zone":
+		return true
+	}
+	return false
+}
+
+// isOrderedKeyType returns true for the Arrow types whose values are ordered by compareValues
+func isOrderedKeyType(dt arrow.DataType) bool {
+	switch dt.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64, arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+		arrow.FLOAT16, arrow.FLOAT32, arrow.FLOAT64, arrow.DECIMAL128, arrow.DECIMAL256,
+		arrow.STRING, arrow.LARGE_STRING, arrow.STRING_VIEW, arrow.BINARY, arrow.LARGE_BINARY, arrow.BINARY_VIEW,
+		arrow.FIXED_SIZE_BINARY, arrow.BOOL, arrow.DATE32, arrow.DATE64, arrow.TIMESTAMP, arrow.TIME32, arrow.TIME64:
+		return true
+	case arrow.DICTIONARY:
+		return isOrderedKeyType(dt.(*arrow.DictionaryType).ValueType)
+	default:
+		return false
+	}
+}
+
+// diffQuery returns the query selecting the compared columns of the table ordered by key,
+// the text keys are ordered with the "C" collation to match the byte order used to sort the file
+func diffQuery(schemaName string, tableName string, tableColumns []db.ColumnInfo, result *DiffResult) string {
+	columns := make([]string, len(result.Columns))
+	for i, name := range result.Columns {
+		columns[i] = pgx.Identifier{name}.Sanitize()
+	}
+	orderBy := make([]string, len(result.KeyColumns))
+	for i, name := range result.KeyColumns {
+		orderBy[i] = pgx.Identifier{name}.Sanitize()
+		for _, col := range tableColumns {
+			if col.Name == name && isTextType(col.DataType) {
+				orderBy[i] += ` COLLATE "C"`
+			}
+		}
+	}
+	return fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", strings.Join(columns, ", "),
+		pgx.Identifier{schemaName, tableName}.Sanitize(), strings.Join(orderBy, ", "))
+}
+
+// isTextType returns true for the types ordered by a collation, citext is USER-DEFINED in information_schema
+func isTextType(dataType string) bool {
+	switch dataType {
+	case "text", "character varying", "character", "name":
+		return true
+	}
+	return false
+}
+
+// queryRowIterator returns the normalized rows of a query
+type queryRowIterator struct {
+	rows pgx.Rows
+}
+
+func (it *queryRowIterator) next() ([]interface{}, error) {
+	if !it.rows.Next() {
+		if err := it.rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read table rows: %w", err)
+		}
+		return nil, io.EOF
+	}
+	values, err := it.rows.Values()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get row values: %w", err)
+	}
+	for i, val := range values {
+		values[i] = normalizeValue(val)
+	}
+	return values, nil
+}
+
+func (it *queryRowIterator) close() error {
+	it.rows.Close()
+	return nil
+}
+
+// mergeDiff walks the rows of the table and of the file in key order and reports the differences
+func mergeDiff(tableRows rowIterator, fileRows rowIterator, keyPositions []int, result *DiffResult, report func(RowDifference) error) error {
+	nextRow := func(it rowIterator, count *int64) ([]interface{}, error) {
+		row, err := it.next()
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err == nil {
+			*count++
+		}
+		return row, err
+	}
+	send := func(difference RowDifference) error {
+		switch difference.Kind {
+		case DiffMissing:
+			result.Missing++
+		case DiffExtra:
+			result.Extra++
+		case DiffChanged:
+			result.Changed++
+		}
+		if report == nil {
+			return nil
+		}
+		return report(difference)
+	}
+	tableRow, err := nextRow(tableRows, &result.TableRows)
+	if err != nil {
+		return err
+	}
+	fileRow, err := nextRow(fileRows, &result.FileRows)
+	if err != nil {
+		return err
+	}
+	for tableRow != nil || fileRow != nil {
+		c := 0
+		switch {
+		case tableRow == nil:
+			c = 1
+		case fileRow == nil:
+			c = -1
+		default:
+			if c, err = compareKeys(tableRow, fileRow, keyPositions); err != nil {
+				return err
+			}
+		}
+		switch {
+		case c < 0:
+			err = send(RowDifference{Kind: DiffMissing, Key: formatKey(tableRow, keyPositions, result.Columns)})
+		case c > 0:
+			err = send(RowDifference{Kind: DiffExtra, Key: formatKey(fileRow, keyPositions, result.Columns)})
+		default:
+			var cells []CellDifference
+			for i, name := range result.Columns {
+				if !equalValues(tableRow[i], fileRow[i]) {
+					cells = append(cells, CellDifference{Column: name, TableValue: formatValue(tableRow[i]), FileValue: formatValue(fileRow[i])})
+				}
+			}
+			if len(cells) > 0 {
+				err = send(RowDifference{Kind: DiffChanged, Key: formatKey(tableRow, keyPositions, result.Columns), Cells: cells})
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if c <= 0 {
+			if tableRow, err = nextRow(tableRows, &result.TableRows); err != nil {
+				return err
+			}
+		}
+		if c >= 0 {
+			if fileRow, err = nextRow(fileRows, &result.FileRows); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatKey returns the key of the row as col1=value1, col2=value2
+func formatKey(row []interface{}, keyPositions []int, columns []string) string {
+	parts := make([]string, len(keyPositions))
+	for i, pos := range keyPositions {
+		parts[i] = columns[pos] + "=" + formatValue(row[pos])
+	}
+	return strings.Join(parts, ", ")
+}
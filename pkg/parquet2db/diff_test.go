@@ -0,0 +1,40 @@
+package parquet2db
+
+import (
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+)
+
+func TestDiffColumnsKeyTypes(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "code", Type: arrow.BinaryTypes.String},
+		{Name: "email", Type: arrow.BinaryTypes.String},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+	}, nil)
+	tableColumns := []db.ColumnInfo{
+		{Name: "id", DataType: "bigint", PgType: "bigint"},
+		{Name: "code", DataType: "character varying", PgType: "character varying(10)"},
+		{Name: "email", DataType: "USER-DEFINED", PgType: "citext"},
+		{Name: "tags", DataType: "ARRAY", PgType: "text[]"},
+	}
+	tests := []struct {
+		keys    []string
+		wantErr bool
+	}{
+		{[]string{"id"}, false},
+		{[]string{"code", "id"}, false},
+		// citext is ordered case-insensitively by the table and byte by byte in the file
+		{[]string{"email"}, true},
+		{[]string{"id", "email"}, true},
+		{[]string{"tags"}, true},
+	}
+	for _, tt := range tests {
+		_, err := diffColumns(schema, tableColumns, DiffOptions{KeyColumns: tt.keys})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("diffColumns() with key %v error = %v, want an error: %v", tt.keys, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package parquet2db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+// LoadMode defines what happens to the target table before the rows of the parquet file are loaded
+type LoadMode string
+
+const (
+	// LoadModeCreate creates the table, it fails if the table already exists
+	LoadModeCreate LoadMode = "create"
+	// LoadModeAppend inserts the rows in an existing table
+	LoadModeAppend LoadMode = "append"
+	// LoadModeTruncate removes all the rows of an existing table before inserting
+	LoadModeTruncate LoadMode = "truncate"
+	// LoadModeReplace drops the table if it exists and creates it again
+	LoadModeReplace LoadMode = "replace"
+)
+
+// ParseLoadMode returns the LoadMode corresponding to the given string
+func ParseLoadMode(mode string) (LoadMode, error) {
+	switch LoadMode(mode) {
+	case LoadModeCreate, LoadModeAppend, LoadModeTruncate, LoadModeReplace:
+		return LoadMode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid load mode %s, expected one of create, append, truncate, replace", mode)
+	}
+}
+
+// LoadOptions defines how a parquet file is loaded in a table
+type LoadOptions struct {
+	Mode LoadMode
+	// BatchSize is the number of rows read from the parquet file and sent by each COPY
+	BatchSize int
+	// UpsertKey lists the columns of a unique key of the table, the rows with an existing key are updated instead of inserted
+	UpsertKey []string
+	// DeleteMissing deletes the rows of the table whose key is absent from the file, it requires an UpsertKey
+	DeleteMissing bool
+	// PartitionColumns restricts DeleteMissing to the rows of the table having the values of these columns found in the file
+	PartitionColumns []string
+}
+
+// LoadResult summarizes the changes made to the table by a load
+type LoadResult struct {
+	// Rows is the number of rows read from the parquet file
+	Rows int64
+	// Inserted is the number of new rows in the table
+	Inserted int64
+	// Updated is the number of existing rows updated by an upsert
+	Updated int64
+	// Deleted is the number of rows removed because they were absent from the file
+	Deleted int64
+}
+
+// LoadParquetFile loads the rows of a parquet file in the table schemaName.tableName inside one transaction
+// using COPY in batches. With an UpsertKey the rows are copied in a temporary staging table and merged
+// in the table with INSERT ... ON CONFLICT, the key must be the primary key or a unique constraint of the table.
+func LoadParquetFile(
+	ctx context.Context,
+	dbConn *pgxpool.Pool,
+	parquetFilePath string,
+	schemaName string,
+	tableName string,
+	options LoadOptions,
+	log golog.MyLogger) (*LoadResult, error) {
+	pf, err := file.OpenParquetFile(parquetFilePath, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Parquet file %s: %w", parquetFilePath, err)
+	}
+	defer pf.Close()
+	reader, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: int64(options.BatchSize)}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Arrow reader for %s: %w", parquetFilePath, err)
+	}
+	schema, err := reader.Schema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Arrow schema of %s: %w", parquetFilePath, err)
+	}
+	columns := make([]string, len(schema.Fields()))
+	for i, field := range schema.Fields() {
+		columns[i] = field.Name
+	}
+	if err := checkMergeOptions(schema, options); err != nil {
+		return nil, fmt.Errorf("invalid options for %s: %w", parquetFilePath, err)
+	}
+	log.Info("Parquet file %s opened with %d rows and %d columns", parquetFilePath, pf.NumRows(), len(columns))
+
+	tx, err := dbConn.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func(tx pgx.Tx, ctx context.Context) {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Error("failed to rollback transaction: %v", err)
+		}
+	}(tx, context.WithoutCancel(ctx)) // Rollback if not committed, even when the load was canceled
+
+	if err := prepareTable(ctx, tx, schemaName, tableName, schema, options.Mode, log); err != nil {
+		return nil, err
+	}
+	target := pgx.Identifier{schemaName, tableName}
+	if len(options.UpsertKey) > 0 {
+		if err := createStagingTable(ctx, tx, target); err != nil {
+			return nil, err
+		}
+		target = pgx.Identifier{stagingTableName}
+	}
+
+	numRows, err := copyParquetRows(ctx, tx, reader, target, columns, log)
+	if err != nil {
+		return nil, err
+	}
+	result := &LoadResult{Rows: numRows, Inserted: numRows}
+	if len(options.UpsertKey) > 0 {
+		result, err = mergeStagingTable(ctx, tx, pgx.Identifier{schemaName, tableName}, columns, options)
+		if err != nil {
+			return nil, err
+		}
+		result.Rows = numRows
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	log.Info("%d rows loaded from %s in table %s: %d inserted, %d updated, %d deleted", result.Rows, parquetFilePath,
+		pgx.Identifier{schemaName, tableName}.Sanitize(), result.Inserted, result.Updated, result.Deleted)
+	return result, nil
+}
+
+// prepareTable creates, truncates or replaces the target table according to the load mode
+func prepareTable(ctx context.Context, tx pgx.Tx, schemaName string, tableName string, schema *arrow.Schema, mode LoadMode, log golog.MyLogger) error {
+	table := pgx.Identifier{schemaName, tableName}.Sanitize()
+	switch mode {
+	case LoadModeAppend:
+		return nil
+	case LoadModeTruncate:
+		if _, err := tx.Exec(ctx, "TRUNCATE TABLE "+table); err != nil {
+			return fmt.Errorf("failed to truncate table %s: %w", table, err)
+		}
+		log.Info("table %s truncated", table)
+		return nil
+	case LoadModeReplace:
+		if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			return fmt.Errorf("failed to drop table %s: %w", table, err)
+		}
+	case LoadModeCreate:
+	default:
+		return fmt.Errorf("invalid load mode %s", mode)
+	}
+	if err := checkStoredPgTypes(ctx, tx, schema, log); err != nil {
+		return err
+	}
+	statements, err := CreateTableStatements(schemaName, tableName, schema)
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create table %s: %w", table, err)
+		}
+	}
+	log.Info("table %s created", table)
+	return nil
+}
+
+// copyParquetRows sends every RecordBatch of the parquet file to the target table with COPY
+func copyParquetRows(ctx context.Context, tx pgx.Tx, reader *pqarrow.FileReader, target pgx.Identifier, columns []string, log golog.MyLogger) (int64, error) {
+	recordReader, err := reader.GetRecordReader(ctx, nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create record reader: %w", err)
+	}
+	defer recordReader.Release()
+	var numRows int64
+	batchNumber := 0
+	for recordReader.Next() {
+		batchNumber++
+		record := recordReader.Record()
+		n, err := tx.CopyFrom(ctx, target, columns, newRecordCopySource(record))
+		if err != nil {
+			return numRows, fmt.Errorf("failed to copy batch %d in %s: %w", batchNumber, target.Sanitize(), err)
+		}
+		numRows += n
+		log.Info("Copied batch %d of %d rows in %s", batchNumber, n, target.Sanitize())
+	}
+	if err := recordReader.Err(); err != nil {
+		return numRows, fmt.Errorf("failed to read parquet records: %w", err)
+	}
+	return numRows, nil
+}
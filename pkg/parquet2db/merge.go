@@ -0,0 +1,118 @@
+package parquet2db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/jackc/pgx/v5"
+)
+
+const stagingTableName = "parquet2db_staging"
+
+// checkMergeOptions verifies that the key and partition columns of the options exist in the parquet schema
+func checkMergeOptions(schema *arrow.Schema, options LoadOptions) error {
+	if options.DeleteMissing && len(options.UpsertKey) == 0 {
+		return errors.New("deleting the rows absent from the file requires an upsert key")
+	}
+	if len(options.PartitionColumns) > 0 && !options.DeleteMissing {
+		return errors.New("partition columns are only used to delete the rows absent from the file")
+	}
+	for _, column := range options.UpsertKey {
+		if len(schema.FieldIndices(column)) == 0 {
+			return fmt.Errorf("upsert key column %s does not exist in the file", column)
+		}
+	}
+	for _, column := range options.PartitionColumns {
+		if len(schema.FieldIndices(column)) == 0 {
+			return fmt.Errorf("partition column %s does not exist in the file", column)
+		}
+	}
+	return nil
+}
+
+// createStagingTable creates a temporary table with the structure of the target, dropped at the end of the transaction
+func createStagingTable(ctx context.Context, tx pgx.Tx, target pgx.Identifier) error {
+	query := fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP",
+		pgx.Identifier{stagingTableName}.Sanitize(), target.Sanitize())
+	if _, err := tx.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create staging table: %w", err)
+	}
+	return nil
+}
+
+// mergeStagingTable upserts the rows of the staging table in the target and deletes the rows absent from
+// the staging table when options.DeleteMissing is set. It returns the number of inserted, updated and deleted rows.
+func mergeStagingTable(ctx context.Context, tx pgx.Tx, target pgx.Identifier, columns []string, options LoadOptions) (*LoadResult, error) {
+	result := &LoadResult{}
+	if options.DeleteMissing {
+		// deleting first avoids checking the rows that the upsert is about to insert
+		tag, err := tx.Exec(ctx, deleteMissingQuery(target, options.UpsertKey, options.PartitionColumns))
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete rows absent from the file: %w", err)
+		}
+		result.Deleted = tag.RowsAffected()
+	}
+	query := upsertQuery(target, columns, options.UpsertKey)
+	if err := tx.QueryRow(ctx, query).Scan(&result.Inserted, &result.Updated); err != nil {
+		return nil, fmt.Errorf("failed to upsert rows from staging table: %w", err)
+	}
+	return result, nil
+}
+
+// upsertQuery returns the INSERT ... ON CONFLICT query moving the rows of the staging table to the target.
+// The query returns the number of inserted and updated rows, xmax is 0 for the rows inserted by the statement.
+func upsertQuery(target pgx.Identifier, columns []string, key []string) string {
+	quotedColumns := make([]string, len(columns))
+	var updates []string
+	for i, column := range columns {
+		quotedColumns[i] = pgx.Identifier{column}.Sanitize()
+		if !slices.Contains(key, column) {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", quotedColumns[i], quotedColumns[i]))
+		}
+	}
+	conflictAction := "DO NOTHING"
+	if len(updates) > 0 {
+		conflictAction = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	columnList := strings.Join(quotedColumns, ", ")
+	return fmt.Sprintf(`WITH upserted AS (
+    INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT (%s) %s RETURNING (xmax = 0) AS inserted
+)
+SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM upserted`,
+		target.Sanitize(), columnList, columnList, pgx.Identifier{stagingTableName}.Sanitize(), quoteColumns(key), conflictAction)
+}
+
+// deleteMissingQuery returns the DELETE query removing the rows of the target whose key is absent from the staging table.
+// With partition columns only the rows of the partitions present in the staging table are considered.
+func deleteMissingQuery(target pgx.Identifier, key []string, partitionColumns []string) string {
+	staging := pgx.Identifier{stagingTableName}.Sanitize()
+	query := fmt.Sprintf("DELETE FROM %s AS t WHERE NOT EXISTS (SELECT 1 FROM %s AS s WHERE %s)",
+		target.Sanitize(), staging, joinConditions(key, "="))
+	if len(partitionColumns) > 0 {
+		query += fmt.Sprintf(" AND EXISTS (SELECT 1 FROM %s AS s WHERE %s)", staging, joinConditions(partitionColumns, "IS NOT DISTINCT FROM"))
+	}
+	return query
+}
+
+// joinConditions returns the conditions comparing the columns of the target t and of the staging table s
+func joinConditions(columns []string, operator string) string {
+	conditions := make([]string, len(columns))
+	for i, column := range columns {
+		quoted := pgx.Identifier{column}.Sanitize()
+		conditions[i] = fmt.Sprintf("t.%s %s s.%s", quoted, operator, quoted)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// quoteColumns returns the comma separated list of the quoted column names
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,114 @@
+package parquet2db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/recordsort"
+)
+
+// rowIterator returns the rows one by one, with io.EOF after the last one
+type rowIterator interface {
+	next() ([]interface{}, error)
+	close() error
+}
+
+// sortRecordsByKey reads the records and returns an iterator over their rows ordered by the key columns.
+// Runs of runRows rows are sorted in memory, when there are more rows each run is written in an Arrow IPC
+// stream file of tempDir and the runs are merged, so the memory used does not depend on the number of rows.
+// The values returned by the iterator are normalized with normalizeValue.
+func sortRecordsByKey(ctx context.Context, reader array.RecordReader, keyPositions []int, runRows int, tempDir string, mem memory.Allocator) (rowIterator, error) {
+	sorter := recordsort.New(ctx, reader.Schema(), keyComparator(keyPositions),
+		recordsort.Options{RunRows: int64(runRows), TempDir: tempDir, Allocator: mem})
+	for reader.Next() {
+		if err := sorter.Add(reader.Record()); err != nil {
+			sorter.Close()
+			return nil, err
+		}
+	}
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		sorter.Close()
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+	records, err := sorter.Sort()
+	if err != nil {
+		sorter.Close()
+		return nil, err
+	}
+	return &sortedRowIterator{sorter: sorter, records: records}, nil
+}
+
+// keyComparator compares the key columns of two rows of the records, normalized like the rows of the table
+func keyComparator(keyPositions []int) recordsort.CompareFunc {
+	return func(a arrow.Record, i int, b arrow.Record, j int) (int, error) {
+		for _, pos := range keyPositions {
+			x, err := recordValue(a, pos, i)
+			if err != nil {
+				return 0, err
+			}
+			y, err := recordValue(b, pos, j)
+			if err != nil {
+				return 0, err
+			}
+			if c, err := compareValues(x, y); err != nil || c != 0 {
+				return c, err
+			}
+		}
+		return 0, nil
+	}
+}
+
+// sortedRowIterator iterates over the rows of the sorted records
+type sortedRowIterator struct {
+	sorter  *recordsort.Sorter
+	records *recordsort.Iterator
+	record  arrow.Record
+	row     int
+}
+
+func (it *sortedRowIterator) next() ([]interface{}, error) {
+	for it.record == nil || it.row >= int(it.record.NumRows()) {
+		// the record is released by the next call to Next, io.EOF is returned after the last one
+		record, err := it.records.Next()
+		if err != nil {
+			return nil, err
+		}
+		it.record, it.row = record, 0
+	}
+	row, err := recordRow(it.record, it.row)
+	it.row++
+	return row, err
+}
+
+func (it *sortedRowIterator) close() error {
+	it.records.Close()
+	it.sorter.Close()
+	return nil
+}
+
+// recordRow returns the normalized values of a row of the record
+func recordRow(record arrow.Record, row int) ([]interface{}, error) {
+	values := make([]interface{}, record.NumCols())
+	for i := range values {
+		val, err := recordValue(record, i, row)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = val
+	}
+	return values, nil
+}
+
+// recordValue returns the normalized value of a column of the record
+func recordValue(record arrow.Record, col int, row int) (interface{}, error) {
+	val, err := arrowValue(record.Column(col), row)
+	if err != nil {
+		return nil, fmt.Errorf("column %s, row %d: %w", record.ColumnName(col), row, err)
+	}
+	return normalizeValue(val), nil
+}
@@ -0,0 +1,73 @@
+package parquet2db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestSortRecordsByKey(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "code", Type: arrow.BinaryTypes.String},
+		{Name: "id", Type: arrow.PrimitiveTypes.Int32},
+	}, nil)
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	var records []arrow.Record
+	const numRows = 500
+	for start := 0; start < numRows; start += 40 {
+		for id := start; id < min(start+40, numRows); id++ {
+			builder.Field(0).(*array.StringBuilder).Append(string(rune('a' + id*7%26)))
+			builder.Field(1).(*array.Int32Builder).Append(int32(numRows - id))
+		}
+		records = append(records, builder.NewRecord())
+	}
+	reader, err := array.NewRecordReader(schema, records)
+	if err != nil {
+		t.Fatalf("NewRecordReader() error: %v", err)
+	}
+	defer reader.Release()
+	for _, record := range records {
+		record.Release()
+	}
+
+	tempDir := t.TempDir()
+	// runs of 100 rows, the 500 rows are spilled in 5 run files and merged
+	rows, err := sortRecordsByKey(context.Background(), reader, []int{0, 1}, 100, tempDir, mem)
+	if err != nil {
+		t.Fatalf("sortRecordsByKey() error: %v", err)
+	}
+	var previous []interface{}
+	count := 0
+	for {
+		row, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next() error: %v", err)
+		}
+		if previous != nil {
+			if c, err := compareKeys(previous, row, []int{0, 1}); err != nil || c >= 0 {
+				t.Fatalf("row %d %v after %v", count, row, previous)
+			}
+		}
+		previous = row
+		count++
+	}
+	if count != numRows {
+		t.Errorf("next() returned %d rows, want %d", count, numRows)
+	}
+	rows.close()
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("%d run files left after close()", len(entries))
+	}
+}
@@ -0,0 +1,460 @@
+package parquet2db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/decimal256"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+// ViolationKind is the kind of problem preventing a parquet file from being loaded in a table
+type ViolationKind string
+
+const (
+	// ViolationExtraColumn is a column of the file absent from the table
+	ViolationExtraColumn ViolationKind = "extra_column"
+	// ViolationMissingColumn is a NOT NULL column of the table absent from the file, it loads only with a default
+	ViolationMissingColumn ViolationKind = "missing_column"
+	// ViolationType is a column of the file whose type cannot be loaded in the type of the table
+	ViolationType ViolationKind = "type"
+	// ViolationNull is a NOT NULL column of the table with NULL values in the file
+	ViolationNull ViolationKind = "null"
+	// ViolationLength is a string longer than the length of a character varying(n) or character(n) column
+	ViolationLength ViolationKind = "length"
+	// ViolationPrecision is a number with more integer digits than a numeric(p,s) column holds
+	ViolationPrecision ViolationKind = "precision"
+	// ViolationRange is an integer out of the range of a smaller integer column
+	ViolationRange ViolationKind = "range"
+	// validateBatchRows is the batch size of the Arrow reader scanning the checked columns
+	validateBatchRows = 64 * 1024
+)
+
+// Violation is a problem found for a column, Count is the number of rows having it and Example the first
+// offending value, both are 0 and empty for the problems of the schema
+type Violation struct {
+	Column  string        `json:"column"`
+	Kind    ViolationKind `json:"kind"`
+	Message string        `json:"message"`
+	Count   int64         `json:"count,omitempty"`
+	Example string        `json:"example,omitempty"`
+}
+
+// ValidationResult lists every violation found in the file, the ones of the schema and of the statistics
+// come before the ones found by scanning the values
+type ValidationResult struct {
+	Rows       int64
+	Violations []Violation
+}
+
+// Valid returns true when the file can be loaded in the table
+func (r *ValidationResult) Valid() bool {
+	return len(r.Violations) == 0
+}
+
+// valueCheck finds the values of a column that cannot be loaded, check returns true for an invalid value
+type valueCheck struct {
+	violation Violation
+	check     func(arr arrow.Array, i int) bool
+}
+
+// typmodPattern matches the modifiers of a PostgresSQL type, like the length of character varying(50)
+// or the precision and scale of numeric(10,2)
+var typmodPattern = regexp.MustCompile(`\((\d+)(?:,\s*(\d+))?\)`)
+
+// ValidateParquetFile checks that the rows of a parquet file can be loaded in a table with the given columns:
+// the types of the file are mapped with db2arrow.MapArrowDataType and compared with the types of the table,
+// the NULL counts of the statistics with the NOT NULL columns, and the columns needing a look at the values,
+// the lengths of character varying(n), the precision of numeric(p,s) and the range of the smaller integers,
+// are scanned once.
+func ValidateParquetFile(
+	ctx context.Context,
+	parquetFilePath string,
+	tableColumns []db.ColumnInfo,
+	log golog.MyLogger) (*ValidationResult, error) {
+	r, err := sink.OpenReader(ctx, parquetFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	pf, err := file.NewParquetReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Parquet file %s: %w", parquetFilePath, err)
+	}
+	defer pf.Close()
+	mem := memory.DefaultAllocator
+	reader, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: validateBatchRows}, mem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Arrow reader for %s: %w", parquetFilePath, err)
+	}
+	schema, err := reader.Schema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Arrow schema of %s: %w", parquetFilePath, err)
+	}
+
+	result := &ValidationResult{Rows: pf.NumRows()}
+	columns := make(map[string]db.ColumnInfo, len(tableColumns))
+	for _, col := range tableColumns {
+		columns[col.Name] = col
+		if !col.Nullable && len(schema.FieldIndices(col.Name)) == 0 {
+			result.Violations = append(result.Violations, Violation{Column: col.Name, Kind: ViolationMissingColumn,
+				Message: fmt.Sprintf("NOT NULL column %s of the table is absent from the file, it loads only with a default value", col.Name)})
+		}
+	}
+	var checks []valueCheck
+	var fields []int
+	for i, field := range schema.Fields() {
+		col, found := columns[field.Name]
+		if !found {
+			result.Violations = append(result.Violations, Violation{Column: field.Name, Kind: ViolationExtraColumn,
+				Message: fmt.Sprintf("column %s of the file is absent from the table", field.Name)})
+			continue
+		}
+		fileType, err := db2arrow.MapArrowDataType(field.Type)
+		if err != nil {
+			result.Violations = append(result.Violations, Violation{Column: field.Name, Kind: ViolationType, Message: err.Error()})
+			continue
+		}
+		if !loadableType(fileType, col.DataType, col.PgType) {
+			result.Violations = append(result.Violations, Violation{Column: field.Name, Kind: ViolationType,
+				Message: fmt.Sprintf("%s values of the file cannot be loaded in %s column %s", fileType, columnType(col), col.Name)})
+			continue
+		}
+		columnChecks := valueChecks(field, col)
+		if !col.Nullable && field.Nullable {
+			if nulls, known := statisticsNullCount(pf, field); !known {
+				columnChecks = append(columnChecks, nullCheck(col))
+			} else if nulls > 0 {
+				violation := nullCheck(col).violation
+				violation.Count = nulls
+				result.Violations = append(result.Violations, violation)
+			}
+		}
+		if len(columnChecks) > 0 {
+			fields = append(fields, i)
+			checks = append(checks, columnChecks...)
+		}
+	}
+	if len(checks) > 0 {
+		log.Info("scanning %d columns of %s to check their values", len(fields), parquetFilePath)
+		if err := scanValues(ctx, reader, fields, checks); err != nil {
+			return nil, fmt.Errorf("failed to check the values of %s: %w", parquetFilePath, err)
+		}
+		for _, c := range checks {
+			if c.violation.Count > 0 {
+				result.Violations = append(result.Violations, c.violation)
+			}
+		}
+	}
+	return result, nil
+}
+
+// loadableType returns true when the values of the file type can be loaded in the table type,
+// the integers and the floating point numbers are widened, the strings fit the text types
+func loadableType(fileType string, dataType string, pgType string) bool {
+	if dataType == "ARRAY" {
+		element, isArray := strings.CutSuffix(fileType, "[]")
+		tableElement := typmodPattern.ReplaceAllString(strings.TrimSuffix(pgType, "[]"), "")
+		return isArray && loadableType(element, tableElement, tableElement)
+	}
+	fileBase := typmodPattern.ReplaceAllString(fileType, "")
+	switch dataType {
+	case "smallint", "integer", "bigint":
+		// the narrowing and the numeric(20,0) of the unsigned 64-bit integers are checked by value
+		return fileBase == "smallint" || fileBase == "integer" || fileBase == "bigint" || fileType == "numeric(20,0)"
+	case "numeric", "real", "double precision":
+		switch fileBase {
+		case "smallint", "integer", "bigint", "numeric", "real", "double precision":
+			return true
+		}
+		return false
+	case "text", "character varying", "character", "citext", "json", "jsonb", "uuid", "xml":
+		return fileBase == "text"
+	case "timestamp without time zone", "timestamp with time zone":
+		return fileBase == "timestamp without time zone" || fileBase == "timestamp with time zone"
+	default:
+		return fileBase == dataType
+	}
+}
+
+// columnType returns the complete type of a table column
+func columnType(col db.ColumnInfo) string {
+	if col.PgType != "" {
+		return col.PgType
+	}
+	return col.DataType
+}
+
+// statisticsNullCount sums the NULL counts of a primitive column over the row groups,
+// known is false for a nested column or when a row group has no NULL count
+func statisticsNullCount(pf *file.Reader, field arrow.Field) (nulls int64, known bool) {
+	if arrow.IsNested(field.Type.ID()) {
+		return 0, false
+	}
+	leaf := pf.MetaData().Schema.ColumnIndexByName(field.Name)
+	if leaf < 0 {
+		return 0, false
+	}
+	for rg := 0; rg < pf.NumRowGroups(); rg++ {
+		chunk, err := pf.MetaData().RowGroup(rg).ColumnChunk(leaf)
+		if err != nil {
+			return 0, false
+		}
+		stats, err := chunk.Statistics()
+		if err != nil || stats == nil || !stats.HasNullCount() {
+			return 0, false
+		}
+		nulls += stats.NullCount()
+	}
+	return nulls, true
+}
+
+func nullCheck(col db.ColumnInfo) valueCheck {
+	return valueCheck{
+		violation: Violation{Column: col.Name, Kind: ViolationNull, Message: fmt.Sprintf("NOT NULL column %s has NULL values in the file", col.Name)},
+		check: func(arr arrow.Array, i int) bool {
+			return arr.IsNull(i)
+		},
+	}
+}
+
+// valueChecks returns the checks of the values of a column whose type fits only for some values
+func valueChecks(field arrow.Field, col db.ColumnInfo) []valueCheck {
+	typmod := typmodPattern.FindStringSubmatch(col.PgType)
+	switch col.DataType {
+	case "character varying", "character":
+		if typmod == nil {
+			return nil
+		}
+		length, _ := strconv.Atoi(typmod[1])
+		return []valueCheck{{
+			violation: Violation{Column: col.Name, Kind: ViolationLength,
+				Message: fmt.Sprintf("values of column %s are longer than the %d characters of %s", col.Name, length, col.PgType)},
+			check: func(arr arrow.Array, i int) bool {
+				s := stringValue(arr, i)
+				// a string of at most length bytes has at most length characters
+				return len(s) > length && utf8.RuneCountInString(s) > length
+			},
+		}}
+	case "numeric":
+		if typmod == nil {
+			return nil
+		}
+		precision, _ := strconv.Atoi(typmod[1])
+		scale := 0
+		if typmod[2] != "" {
+			scale, _ = strconv.Atoi(typmod[2])
+		}
+		digits := int32(precision - scale)
+		if !mayExceedDigits(field.Type, digits) {
+			return nil
+		}
+		return []valueCheck{{
+			violation: Violation{Column: col.Name, Kind: ViolationPrecision,
+				Message: fmt.Sprintf("values of column %s have more than the %d integer digits of %s", col.Name, digits, col.PgType)},
+			check: func(arr arrow.Array, i int) bool {
+				return exceedsDigits(arr, i, digits)
+			},
+		}}
+	case "smallint", "integer", "bigint":
+		low, high := integerRange(col.DataType)
+		if !mayExceedRange(field.Type, col.DataType) {
+			return nil
+		}
+		return []valueCheck{{
+			violation: Violation{Column: col.Name, Kind: ViolationRange,
+				Message: fmt.Sprintf("values of column %s are out of the range [%d, %d] of %s", col.Name, low, high, col.DataType)},
+			check: func(arr arrow.Array, i int) bool {
+				return outOfRange(arr, i, low, high)
+			},
+		}}
+	}
+	return nil
+}
+
+// mayExceedDigits returns false when every value of the type has at most digits integer digits
+func mayExceedDigits(t arrow.DataType, digits int32) bool {
+	switch t := t.(type) {
+	case *arrow.Decimal128Type:
+		return t.Precision-t.Scale > digits
+	case *arrow.Decimal256Type:
+		return t.Precision-t.Scale > digits
+	case *arrow.Int16Type:
+		return digits < 5
+	case *arrow.Int32Type:
+		return digits < 10
+	default:
+		return true
+	}
+}
+
+// exceedsDigits returns true when the value has more than digits integer digits
+func exceedsDigits(arr arrow.Array, i int, digits int32) bool {
+	if arr.IsNull(i) {
+		return false
+	}
+	switch a := arr.(type) {
+	case *array.Decimal128:
+		t := a.DataType().(*arrow.Decimal128Type)
+		return digits+t.Scale < t.Precision && !a.Value(i).FitsInPrecision(digits+t.Scale)
+	case *array.Decimal256:
+		t := a.DataType().(*arrow.Decimal256Type)
+		return digits+t.Scale < t.Precision && !a.Value(i).FitsInPrecision(digits+t.Scale)
+	default:
+		f, ok := floatValue(arr, i)
+		return ok && math.Abs(f) >= math.Pow10(int(digits))
+	}
+}
+
+// integerRange returns the bounds of a PostgresSQL integer type
+func integerRange(dataType string) (int64, int64) {
+	switch dataType {
+	case "smallint":
+		return math.MinInt16, math.MaxInt16
+	case "integer":
+		return math.MinInt32, math.MaxInt32
+	default:
+		return math.MinInt64, math.MaxInt64
+	}
+}
+
+// mayExceedRange returns false when the Arrow integer type fits the PostgresSQL integer type
+func mayExceedRange(t arrow.DataType, dataType string) bool {
+	switch t.ID() {
+	case arrow.INT8, arrow.UINT8, arrow.INT16:
+		return false
+	case arrow.UINT16, arrow.INT32:
+		return dataType == "smallint"
+	case arrow.UINT32, arrow.INT64:
+		return dataType != "bigint"
+	default:
+		return true
+	}
+}
+
+// outOfRange returns true when the integer is outside [low, high]
+func outOfRange(arr arrow.Array, i int, low, high int64) bool {
+	if arr.IsNull(i) {
+		return false
+	}
+	switch a := arr.(type) {
+	case *array.Uint16:
+		return int64(a.Value(i)) > high
+	case *array.Int32:
+		return int64(a.Value(i)) < low || int64(a.Value(i)) > high
+	case *array.Uint32:
+		return int64(a.Value(i)) > high
+	case *array.Int64:
+		return a.Value(i) < low || a.Value(i) > high
+	case *array.Uint64:
+		return a.Value(i) > uint64(high)
+	case *array.Decimal128:
+		v := a.Value(i)
+		return v.Less(decimal128.FromI64(low)) || v.Greater(decimal128.FromI64(high))
+	case *array.Decimal256:
+		v := a.Value(i)
+		return v.Less(decimal256.FromI64(low)) || v.Greater(decimal256.FromI64(high))
+	default:
+		return false
+	}
+}
+
+// floatValue returns the value of an integer or floating point array
+func floatValue(arr arrow.Array, i int) (float64, bool) {
+	switch a := arr.(type) {
+	case *array.Int8:
+		return float64(a.Value(i)), true
+	case *array.Int16:
+		return float64(a.Value(i)), true
+	case *array.Int32:
+		return float64(a.Value(i)), true
+	case *array.Int64:
+		return float64(a.Value(i)), true
+	case *array.Uint8:
+		return float64(a.Value(i)), true
+	case *array.Uint16:
+		return float64(a.Value(i)), true
+	case *array.Uint32:
+		return float64(a.Value(i)), true
+	case *array.Uint64:
+		return float64(a.Value(i)), true
+	case *array.Float16:
+		return float64(a.Value(i).Float32()), true
+	case *array.Float32:
+		return float64(a.Value(i)), true
+	case *array.Float64:
+		return a.Value(i), true
+	default:
+		return 0, false
+	}
+}
+
+// stringValue returns the value of a string array, empty for a NULL
+func stringValue(arr arrow.Array, i int) string {
+	if arr.IsNull(i) {
+		return ""
+	}
+	switch a := arr.(type) {
+	case *array.String:
+		return a.Value(i)
+	case *array.LargeString:
+		return a.Value(i)
+	default:
+		return arr.ValueStr(i)
+	}
+}
+
+// scanValues reads the fields once and counts the invalid values of each check, with the first one as example
+func scanValues(ctx context.Context, reader *pqarrow.FileReader, fields []int, checks []valueCheck) error {
+	leafColumns, err := reader.Manifest.GetFieldIndices(fields)
+	if err != nil {
+		return err
+	}
+	recordReader, err := reader.GetRecordReader(ctx, leafColumns, nil)
+	if err != nil {
+		return err
+	}
+	defer recordReader.Release()
+	positions := make([]int, len(checks))
+	for i, c := range checks {
+		positions[i] = recordReader.Schema().FieldIndices(c.violation.Column)[0]
+	}
+	for {
+		record, err := recordReader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		for i := range checks {
+			c := &checks[i]
+			arr := record.Column(positions[i])
+			for row := 0; row < arr.Len(); row++ {
+				if !c.check(arr, row) {
+					continue
+				}
+				if c.violation.Count == 0 {
+					c.violation.Example = arr.ValueStr(row)
+				}
+				c.violation.Count++
+			}
+		}
+	}
+}
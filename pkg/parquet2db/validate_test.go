@@ -0,0 +1,34 @@
+package parquet2db
+
+import "testing"
+
+func TestLoadableType(t *testing.T) {
+	tests := []struct {
+		fileType string
+		dataType string
+		pgType   string
+		want     bool
+	}{
+		{"integer", "bigint", "bigint", true},
+		{"bigint", "smallint", "smallint", true},
+		{"numeric(20,0)", "bigint", "bigint", true},
+		{"numeric(12,2)", "integer", "integer", false},
+		{"real", "double precision", "double precision", true},
+		{"numeric(12,2)", "numeric", "numeric(10,4)", true},
+		{"text", "uuid", "uuid", true},
+		{"text", "character varying", "character varying(20)", true},
+		{"bytea", "text", "text", false},
+		{"timestamp without time zone", "timestamp with time zone", "timestamp with time zone", true},
+		{"date", "timestamp without time zone", "timestamp without time zone", false},
+		{"boolean", "boolean", "boolean", true},
+		{"integer[]", "ARRAY", "bigint[]", true},
+		{"text[]", "ARRAY", "character varying(10)[]", true},
+		{"integer", "ARRAY", "integer[]", false},
+		{"text[]", "ARRAY", "integer[]", false},
+	}
+	for _, tt := range tests {
+		if got := loadableType(tt.fileType, tt.dataType, tt.pgType); got != tt.want {
+			t.Errorf("loadableType(%q, %q, %q) = %v, want %v", tt.fileType, tt.dataType, tt.pgType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package parquet2db
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// normalizeValue converts a value returned by pgx or by arrowValue to the type used to compare it:
+// the integers become int64, the floats float64 and the numerics an int64 or an exact *big.Rat.
+// The strings and bytes are copied since the Arrow values point into the buffers of the record.
+func normalizeValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int8:
+		return int64(x)
+	case int16:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case int:
+		return int64(x)
+	case uint8:
+		return int64(x)
+	case uint16:
+		return int64(x)
+	case uint32:
+		return int64(x)
+	case float32:
+		return float64(x)
+	case pgtype.Numeric:
+		return normalizeNumeric(x)
+	case string:
+		return strings.Clone(x)
+	case []byte:
+		return slices.Clone(x)
+	case [16]byte:
+		// a uuid, compared with the fixed size binary values of the file
+		return slices.Clone(x[:])
+	case []interface{}:
+		list := make([]interface{}, len(x))
+		for i, elem := range x {
+			list[i] = normalizeValue(elem)
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+// normalizeNumeric returns the numeric as an int64 when it is an integer in range, as a *big.Rat otherwise
+func normalizeNumeric(n pgtype.Numeric) interface{} {
+	switch {
+	case !n.Valid:
+		return nil
+	case n.NaN:
+		return math.NaN()
+	case n.InfinityModifier == pgtype.Infinity:
+		return math.Inf(1)
+	case n.InfinityModifier == pgtype.NegativeInfinity:
+		return math.Inf(-1)
+	}
+	r := new(big.Rat).SetInt(n.Int)
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt32(n.Exp))), nil)
+	if n.Exp > 0 {
+		r.Mul(r, new(big.Rat).SetInt(exp))
+	} else if n.Exp < 0 {
+		r.Quo(r, new(big.Rat).SetInt(exp))
+	}
+	if r.IsInt() && r.Num().IsInt64() {
+		return r.Num().Int64()
+	}
+	return r
+}
+
+func absInt32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// isNumber returns true for the normalized numeric values
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64, *big.Rat:
+		return true
+	}
+	return false
+}
+
+// compareNumbers compares two normalized numbers, exactly unless one of them is a float64
+func compareNumbers(a, b interface{}) int {
+	if x, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok {
+			return cmp.Compare(x, y)
+		}
+	}
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	if aFloat || bFloat {
+		return compareFloats(toFloat(a), toFloat(b))
+	}
+	return toRat(a).Cmp(toRat(b))
+}
+
+// compareFloats orders NaN after every other number like PostgreSQL
+func compareFloats(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return 1
+	case math.IsNaN(b):
+		return -1
+	default:
+		return cmp.Compare(a, b)
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch x := v.(type) {
+	case int64:
+		return float64(x)
+	case *big.Rat:
+		f, _ := x.Float64()
+		return f
+	default:
+		return v.(float64)
+	}
+}
+
+func toRat(v interface{}) *big.Rat {
+	if x, ok := v.(int64); ok {
+		return new(big.Rat).SetInt64(x)
+	}
+	return v.(*big.Rat)
+}
+
+// equalValues returns true when two normalized values are equal, NULL is only equal to NULL
+func equalValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if isNumber(a) && isNumber(b) {
+		return compareNumbers(a, b) == 0
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case []byte:
+		y, ok := b.([]byte)
+		return ok && bytes.Equal(x, y)
+	case time.Time:
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b) || fmt.Sprint(a) == fmt.Sprint(b)
+	}
+}
+
+// compareValues orders two normalized key values like PostgreSQL with the "C" collation, NULL comes last.
+// The values of types without a defined order, or of two different types, cannot be compared.
+func compareValues(a, b interface{}) (int, error) {
+	switch {
+	case a == nil && b == nil:
+		return 0, nil
+	case a == nil:
+		return 1, nil
+	case b == nil:
+		return -1, nil
+	case isNumber(a) && isNumber(b):
+		return compareNumbers(a, b), nil
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case !x:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), nil
+		}
+	case pgtype.Time:
+		if y, ok := b.(pgtype.Time); ok {
+			return cmp.Compare(x.Microseconds, y.Microseconds), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot order the key values %s (%T) and %s (%T)", formatValue(a), a, formatValue(b), b)
+}
+
+// compareKeys compares the key columns of two rows
+func compareKeys(a, b []interface{}, keyPositions []int) (int, error) {
+	for _, pos := range keyPositions {
+		c, err := compareValues(a[pos], b[pos])
+		if err != nil || c != 0 {
+			return c, err
+		}
+	}
+	return 0, nil
+}
+
+// formatValue formats a normalized value for the reports
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return strconv.Quote(x)
+	case []byte:
+		return `\x` + hex.EncodeToString(x)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case *big.Rat:
+		return strings.TrimRight(strings.TrimRight(x.FloatString(20), "0"), ".")
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case pgtype.Time:
+		return (time.Duration(x.Microseconds) * time.Microsecond).String()
+	case []interface{}:
+		elems := make([]string, len(x))
+		for i, elem := range x {
+			elems[i] = formatValue(elem)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
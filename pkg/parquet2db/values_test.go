@@ -0,0 +1,62 @@
+package parquet2db
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestCompareValues(t *testing.T) {
+	uuid := func(last byte) [16]byte { return [16]byte{0x12, 0x34, 15: last} }
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		a, b interface{}
+		want int
+	}{
+		{"integers", int64(2), int64(10), -1},
+		{"integer and numeric", int64(3), big.NewRat(5, 2), 1},
+		{"NaN after the numbers", math.NaN(), 1e300, 1},
+		{"NULL last", nil, int64(1), 1},
+		{"both NULL", nil, nil, 0},
+		{"strings in byte order", "B", "a", -1},
+		{"bytes", []byte{1, 2}, []byte{1, 3}, -1},
+		{"uuid of the table and of the file", normalizeValue(uuid(2)), []byte{0x12, 0x34, 15: 1}, 1},
+		{"uuids", normalizeValue(uuid(1)), normalizeValue(uuid(1)), 0},
+		{"booleans", false, true, -1},
+		{"timestamps", day, day.Add(time.Second), -1},
+		{"times", pgtype.Time{Microseconds: 9_000_000, Valid: true}, pgtype.Time{Microseconds: 10_000_000, Valid: true}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compareValues(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("compareValues() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("compareValues(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareValuesWithoutOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"lists", []interface{}{int64(1)}, []interface{}{int64(2)}},
+		{"string and bytes", "10", []byte("9")},
+		{"unknown type", struct{ x int }{1}, struct{ x int }{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := compareValues(tt.a, tt.b); err == nil {
+				t.Errorf("compareValues(%v, %v) = %d, want an error", tt.a, tt.b, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package parquetinfo
+
+import (
+	"cmp"
+	"fmt"
+	"time"
+)
+
+// Comparison is the drift between an old and a new file or dataset: the differences of their schemas,
+// of their row counts and of the statistics of the columns they share
+type Comparison struct {
+	Old               string                  `json:"old" yaml:"old"`
+	New               string                  `json:"new" yaml:"new"`
+	OldRows           int64                   `json:"old_rows" yaml:"old_rows"`
+	NewRows           int64                   `json:"new_rows" yaml:"new_rows"`
+	SchemaDifferences []SchemaDifference      `json:"schema_differences,omitempty" yaml:"schema_differences,omitempty"`
+	Columns           []ColumnStatsDifference `json:"columns,omitempty" yaml:"columns,omitempty"`
+	// Breaking is set when one of the schema differences is breaking
+	Breaking bool `json:"breaking" yaml:"breaking"`
+}
+
+// RowCountChange returns the relative change of the number of rows, 0 when the old one is empty
+func (c *Comparison) RowCountChange() float64 {
+	if c.OldRows == 0 {
+		return 0
+	}
+	return float64(c.NewRows-c.OldRows) / float64(c.OldRows)
+}
+
+// ColumnStatsDifference compares the statistics of a column present in both schemas, only the columns
+// with a different null ratio, min or max are listed
+type ColumnStatsDifference struct {
+	Column       string      `json:"column" yaml:"column"`
+	OldNullCount *int64      `json:"old_null_count,omitempty" yaml:"old_null_count,omitempty"`
+	NewNullCount *int64      `json:"new_null_count,omitempty" yaml:"new_null_count,omitempty"`
+	OldNullRatio *float64    `json:"old_null_ratio,omitempty" yaml:"old_null_ratio,omitempty"`
+	NewNullRatio *float64    `json:"new_null_ratio,omitempty" yaml:"new_null_ratio,omitempty"`
+	OldMin       interface{} `json:"old_min,omitempty" yaml:"old_min,omitempty"`
+	NewMin       interface{} `json:"new_min,omitempty" yaml:"new_min,omitempty"`
+	OldMax       interface{} `json:"old_max,omitempty" yaml:"old_max,omitempty"`
+	NewMax       interface{} `json:"new_max,omitempty" yaml:"new_max,omitempty"`
+}
+
+// Compare returns the drift from the older to the newer dataset, a single file is described as a dataset of one file
+func Compare(older, newer *DatasetInfo) *Comparison {
+	comparison := &Comparison{Old: older.Root, New: newer.Root, OldRows: older.NumRows, NewRows: newer.NumRows}
+	comparison.SchemaDifferences = CompareSchemas(older.Schema, newer.Schema)
+	for _, difference := range comparison.SchemaDifferences {
+		comparison.Breaking = comparison.Breaking || difference.Breaking
+	}
+	newColumns := make(map[string]DatasetColumnInfo, len(newer.Columns))
+	for _, c := range newer.Columns {
+		newColumns[c.Path] = c
+	}
+	for _, o := range older.Columns {
+		n, found := newColumns[o.Path]
+		if !found {
+			continue
+		}
+		difference := ColumnStatsDifference{
+			Column:       o.Path,
+			OldNullCount: o.NullCount,
+			NewNullCount: n.NullCount,
+			OldNullRatio: nullRatio(o),
+			NewNullRatio: nullRatio(n),
+			OldMin:       o.Min,
+			NewMin:       n.Min,
+			OldMax:       o.Max,
+			NewMax:       n.Max,
+		}
+		if !equalRatio(difference.OldNullRatio, difference.NewNullRatio) || !equalStatValues(o.Min, n.Min) || !equalStatValues(o.Max, n.Max) {
+			comparison.Columns = append(comparison.Columns, difference)
+		}
+	}
+	return comparison
+}
+
+// nullRatio returns the null count divided by the number of values, the values include the nulls
+func nullRatio(c DatasetColumnInfo) *float64 {
+	if c.NullCount == nil || c.NumValues == 0 {
+		return nil
+	}
+	ratio := float64(*c.NullCount) / float64(c.NumValues)
+	return &ratio
+}
+
+func equalRatio(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func equalStatValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	c, ok := compareStatValues(a, b)
+	return ok && c == 0
+}
+
+// compareStatValues compares two values returned by StatValue, ok is false when they cannot be compared
+func compareStatValues(a, b interface{}) (c int, ok bool) {
+	switch x := a.(type) {
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case int32:
+		if y, ok := b.(int32); ok {
+			return cmp.Compare(x, y), true
+		}
+	case int64:
+		if y, ok := b.(int64); ok {
+			return cmp.Compare(x, y), true
+		}
+	case uint64:
+		if y, ok := b.(uint64); ok {
+			return cmp.Compare(x, y), true
+		}
+	case float64:
+		if y, ok := b.(float64); ok {
+			return cmp.Compare(x, y), true
+		}
+	case string:
+		// the binary values are hex strings, their order is the order of the bytes
+		if y, ok := b.(string); ok {
+			return cmp.Compare(x, y), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	case Decimal:
+		if y, ok := b.(Decimal); ok {
+			return x.Cmp(y), true
+		}
+	}
+	return 0, false
+}
+
+// FormatStatValue formats a value returned by StatValue for the text reports
+func FormatStatValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "-"
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
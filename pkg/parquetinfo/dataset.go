@@ -0,0 +1,366 @@
+package parquetinfo
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2parquet"
+)
+
+// SchemaDifferenceKind is the kind of a difference between the columns of two schemas
+type SchemaDifferenceKind string
+
+const (
+	// SchemaColumnRemoved is a column of the reference schema absent from the other one
+	SchemaColumnRemoved SchemaDifferenceKind = "removed"
+	// SchemaColumnAdded is a column absent from the reference schema
+	SchemaColumnAdded SchemaDifferenceKind = "added"
+	// SchemaColumnRetyped is a column with another physical, logical or converted type
+	SchemaColumnRetyped SchemaDifferenceKind = "retyped"
+	// SchemaColumnRepetition is a column with another repetition, like REQUIRED becoming OPTIONAL
+	SchemaColumnRepetition SchemaDifferenceKind = "repetition"
+)
+
+// SchemaDifference is a difference between a column of the reference schema and of another schema,
+// Reference and Actual describe the column in each schema, empty when it is absent.
+// Breaking is set for the differences that can fail the readers of the reference schema: a removed
+// or retyped column, or a column that may now be null or repeated.
+type SchemaDifference struct {
+	// File is the file compared with the reference in a dataset
+	File      string               `json:"file,omitempty" yaml:"file,omitempty"`
+	Column    string               `json:"column" yaml:"column"`
+	Kind      SchemaDifferenceKind `json:"kind" yaml:"kind"`
+	Reference string               `json:"reference,omitempty" yaml:"reference,omitempty"`
+	Actual    string               `json:"actual,omitempty" yaml:"actual,omitempty"`
+	Breaking  bool                 `json:"breaking" yaml:"breaking"`
+}
+
+// DatasetInfo summarizes the parquet files of a directory, a Hive partitioned dataset or a glob.
+// The schema of the first file is the reference the other files are compared with.
+type DatasetInfo struct {
+	Root             string  `json:"root" yaml:"root"`
+	NumFiles         int     `json:"num_files" yaml:"num_files"`
+	NumRows          int64   `json:"num_rows" yaml:"num_rows"`
+	NumRowGroups     int     `json:"num_row_groups" yaml:"num_row_groups"`
+	FileSize         int64   `json:"file_size" yaml:"file_size"`
+	CompressedSize   int64   `json:"compressed_size" yaml:"compressed_size"`
+	UncompressedSize int64   `json:"uncompressed_size" yaml:"uncompressed_size"`
+	CompressionRatio float64 `json:"compression_ratio" yaml:"compression_ratio"`
+	// PartitionColumns are the Hive partition keys found in the directories, in path order
+	PartitionColumns  []string             `json:"partition_columns,omitempty" yaml:"partition_columns,omitempty"`
+	Schema            []ColumnInfo         `json:"schema" yaml:"schema"`
+	SchemaDifferences []SchemaDifference   `json:"schema_differences,omitempty" yaml:"schema_differences,omitempty"`
+	Partitions        []PartitionInfo      `json:"partitions,omitempty" yaml:"partitions,omitempty"`
+	Columns           []DatasetColumnInfo  `json:"columns" yaml:"columns"`
+	Files             []DatasetFileSummary `json:"files" yaml:"files"`
+}
+
+// Compatible returns true when every file has the schema of the first one
+func (d *DatasetInfo) Compatible() bool {
+	return len(d.SchemaDifferences) == 0
+}
+
+// DatasetFileSummary is a file of the dataset, Path is relative to the root of the dataset
+type DatasetFileSummary struct {
+	Path             string `json:"path" yaml:"path"`
+	Partition        string `json:"partition,omitempty" yaml:"partition,omitempty"`
+	NumRows          int64  `json:"num_rows" yaml:"num_rows"`
+	NumRowGroups     int    `json:"num_row_groups" yaml:"num_row_groups"`
+	FileSize         int64  `json:"file_size" yaml:"file_size"`
+	CompressedSize   int64  `json:"compressed_size" yaml:"compressed_size"`
+	UncompressedSize int64  `json:"uncompressed_size" yaml:"uncompressed_size"`
+}
+
+// PartitionInfo aggregates the files of a Hive partition directory, a NULL value is nil in Values
+type PartitionInfo struct {
+	Path             string             `json:"path" yaml:"path"`
+	Values           map[string]*string `json:"values" yaml:"values"`
+	NumFiles         int                `json:"num_files" yaml:"num_files"`
+	NumRows          int64              `json:"num_rows" yaml:"num_rows"`
+	FileSize         int64              `json:"file_size" yaml:"file_size"`
+	CompressedSize   int64              `json:"compressed_size" yaml:"compressed_size"`
+	UncompressedSize int64              `json:"uncompressed_size" yaml:"uncompressed_size"`
+}
+
+// DatasetColumnInfo aggregates a column over the files having it. NullCount is absent when a file
+// has no null count for the column, Min and Max are the bounds of the row groups having statistics.
+type DatasetColumnInfo struct {
+	Path             string      `json:"path" yaml:"path"`
+	NumFiles         int         `json:"num_files" yaml:"num_files"`
+	NumValues        int64       `json:"num_values" yaml:"num_values"`
+	NullCount        *int64      `json:"null_count,omitempty" yaml:"null_count,omitempty"`
+	Min              interface{} `json:"min,omitempty" yaml:"min,omitempty"`
+	Max              interface{} `json:"max,omitempty" yaml:"max,omitempty"`
+	CompressedSize   int64       `json:"compressed_size" yaml:"compressed_size"`
+	UncompressedSize int64       `json:"uncompressed_size" yaml:"uncompressed_size"`
+	CompressionRatio float64     `json:"compression_ratio" yaml:"compression_ratio"`
+}
+
+// IsDataset returns true when the location is a local directory or a glob pattern rather than one file
+func IsDataset(location string) bool {
+	if strings.ContainsAny(location, "*?[") {
+		return true
+	}
+	stat, err := os.Stat(location)
+	return err == nil && stat.IsDir()
+}
+
+// ListDatasetFiles returns the root and the sorted parquet files of a local directory, searched recursively,
+// or of a glob pattern. The hidden files and the ones starting with _ like _metadata are skipped.
+func ListDatasetFiles(location string) (string, []string, error) {
+	var files []string
+	root := location
+	if strings.ContainsAny(location, "*?[") {
+		matches, err := filepath.Glob(location)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid pattern %s: %w", location, err)
+		}
+		for _, match := range matches {
+			if stat, err := os.Stat(match); err == nil && !stat.IsDir() && !skippedFile(filepath.Base(match)) {
+				files = append(files, match)
+			}
+		}
+		// the root is the directory before the first wildcard, the partitions are found under it
+		root = filepath.Dir(location[:strings.IndexAny(location, "*?[")] + "x")
+	} else {
+		err := filepath.WalkDir(location, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != location && skippedFile(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && strings.HasSuffix(d.Name(), ".parquet") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list %s: %w", location, err)
+		}
+	}
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("no parquet file found in %s", location)
+	}
+	sort.Strings(files)
+	return root, files, nil
+}
+
+func skippedFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// DescribeDataset reads the footers of the files and aggregates them per partition and per column
+func DescribeDataset(ctx context.Context, root string, files []string, decryption *db2parquet.EncryptionOptions) (*DatasetInfo, error) {
+	dataset := &DatasetInfo{Root: root, NumFiles: len(files)}
+	partitions := make(map[string]*PartitionInfo)
+	columns := make(map[string]*DatasetColumnInfo)
+	var columnOrder []string
+	for _, location := range files {
+		info, err := describeFile(ctx, location, decryption)
+		if err != nil {
+			return nil, err
+		}
+		relPath, err := filepath.Rel(root, location)
+		if err != nil {
+			relPath = location
+		}
+		summary := DatasetFileSummary{Path: relPath, NumRows: info.NumRows, NumRowGroups: info.NumRowGroups, FileSize: info.FileSize}
+		if dataset.Schema == nil {
+			dataset.Schema = info.Schema.Columns
+		} else {
+			for _, difference := range CompareSchemas(dataset.Schema, info.Schema.Columns) {
+				difference.File = relPath
+				dataset.SchemaDifferences = append(dataset.SchemaDifferences, difference)
+			}
+		}
+		for _, rg := range info.RowGroups {
+			for _, chunk := range rg.Columns {
+				summary.CompressedSize += chunk.CompressedSize
+				summary.UncompressedSize += chunk.UncompressedSize
+			}
+		}
+		for _, c := range info.Schema.Columns {
+			col, found := columns[c.Path]
+			if !found {
+				var nulls int64
+				col = &DatasetColumnInfo{Path: c.Path, NullCount: &nulls}
+				columns[c.Path] = col
+				columnOrder = append(columnOrder, c.Path)
+			}
+			col.NumFiles++
+			for _, rg := range info.RowGroups {
+				chunk := rg.Columns[c.Index]
+				col.NumValues += chunk.NumValues
+				col.CompressedSize += chunk.CompressedSize
+				col.UncompressedSize += chunk.UncompressedSize
+				if chunk.Statistics == nil || chunk.Statistics.NullCount == nil {
+					col.NullCount = nil
+				} else if col.NullCount != nil {
+					*col.NullCount += *chunk.Statistics.NullCount
+				}
+				if chunk.Statistics != nil && chunk.Statistics.Min != nil {
+					if c, ok := compareStatValues(chunk.Statistics.Min, col.Min); col.Min == nil || (ok && c < 0) {
+						col.Min = chunk.Statistics.Min
+					}
+					if c, ok := compareStatValues(chunk.Statistics.Max, col.Max); col.Max == nil || (ok && c > 0) {
+						col.Max = chunk.Statistics.Max
+					}
+				}
+			}
+		}
+
+		partitionPath, values := HivePartition(relPath)
+		if len(values) > 0 {
+			summary.Partition = partitionPath
+			if dataset.PartitionColumns == nil {
+				dataset.PartitionColumns = PartitionKeys(partitionPath)
+			}
+			partition, found := partitions[partitionPath]
+			if !found {
+				partition = &PartitionInfo{Path: partitionPath, Values: values}
+				partitions[partitionPath] = partition
+			}
+			partition.NumFiles++
+			partition.NumRows += summary.NumRows
+			partition.FileSize += summary.FileSize
+			partition.CompressedSize += summary.CompressedSize
+			partition.UncompressedSize += summary.UncompressedSize
+		}
+		dataset.NumRows += summary.NumRows
+		dataset.NumRowGroups += summary.NumRowGroups
+		dataset.FileSize += summary.FileSize
+		dataset.CompressedSize += summary.CompressedSize
+		dataset.UncompressedSize += summary.UncompressedSize
+		dataset.Files = append(dataset.Files, summary)
+	}
+	dataset.CompressionRatio = compressionRatio(dataset.UncompressedSize, dataset.CompressedSize)
+	for _, path := range sortedPartitionPaths(partitions) {
+		dataset.Partitions = append(dataset.Partitions, *partitions[path])
+	}
+	for _, path := range columnOrder {
+		col := columns[path]
+		col.CompressionRatio = compressionRatio(col.UncompressedSize, col.CompressedSize)
+		dataset.Columns = append(dataset.Columns, *col)
+	}
+	return dataset, nil
+}
+
+func describeFile(ctx context.Context, location string, decryption *db2parquet.EncryptionOptions) (*FileInfo, error) {
+	f, err := Open(ctx, location, decryption)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Describe(f, DescribeOptions{})
+}
+
+// CompareSchemas returns the differences of the columns of other with the reference columns, matched by path
+func CompareSchemas(reference, other []ColumnInfo) []SchemaDifference {
+	var differences []SchemaDifference
+	otherColumns := make(map[string]ColumnInfo, len(other))
+	for _, c := range other {
+		otherColumns[c.Path] = c
+	}
+	referenceColumns := make(map[string]bool, len(reference))
+	for _, ref := range reference {
+		referenceColumns[ref.Path] = true
+		c, found := otherColumns[ref.Path]
+		switch {
+		case !found:
+			differences = append(differences, SchemaDifference{Column: ref.Path, Kind: SchemaColumnRemoved, Reference: columnType(ref), Breaking: true})
+		case columnType(ref) != columnType(c):
+			differences = append(differences, SchemaDifference{Column: ref.Path, Kind: SchemaColumnRetyped, Reference: columnType(ref), Actual: columnType(c), Breaking: true})
+		case ref.Repetition != c.Repetition || ref.MaxDefinitionLevel != c.MaxDefinitionLevel:
+			// a required column becoming optional, or any change of the levels of a nested column, breaks the readers,
+			// an optional column becoming required does not
+			breaking := ref.MaxDefinitionLevel < c.MaxDefinitionLevel || ref.MaxRepetitionLevel != c.MaxRepetitionLevel
+			differences = append(differences, SchemaDifference{Column: ref.Path, Kind: SchemaColumnRepetition,
+				Reference: columnRepetition(ref), Actual: columnRepetition(c), Breaking: breaking})
+		}
+	}
+	for _, c := range other {
+		if !referenceColumns[c.Path] {
+			differences = append(differences, SchemaDifference{Column: c.Path, Kind: SchemaColumnAdded, Actual: columnType(c)})
+		}
+	}
+	return differences
+}
+
+// columnRepetition describes the repetition of a leaf column with its definition and repetition levels
+func columnRepetition(c ColumnInfo) string {
+	return fmt.Sprintf("%s (max levels: definition %d, repetition %d)", c.Repetition, c.MaxDefinitionLevel, c.MaxRepetitionLevel)
+}
+
+// columnType describes the physical and logical types of a column
+func columnType(c ColumnInfo) string {
+	typ := c.PhysicalType
+	if c.TypeLength > 0 {
+		typ = fmt.Sprintf("%s(%d)", typ, c.TypeLength)
+	}
+	if c.LogicalType != "" {
+		typ += " " + c.LogicalType
+	} else if c.ConvertedType != "" {
+		typ += " " + c.ConvertedType
+	}
+	return typ
+}
+
+// HivePartition returns the key=value directories of the relative path of a file and their unescaped values,
+// the value of the default partition is nil
+func HivePartition(relPath string) (string, map[string]*string) {
+	var dirs []string
+	values := make(map[string]*string)
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(relPath)), "/") {
+		key, value, found := strings.Cut(dir, "=")
+		if !found || key == "" {
+			continue
+		}
+		dirs = append(dirs, dir)
+		if value == db2parquet.HiveDefaultPartition {
+			values[key] = nil
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		values[key] = &value
+	}
+	return strings.Join(dirs, "/"), values
+}
+
+// PartitionKeys returns the keys of the key=value directories returned by HivePartition, in path order
+func PartitionKeys(partitionPath string) []string {
+	var keys []string
+	for _, dir := range strings.Split(partitionPath, "/") {
+		key, _, _ := strings.Cut(dir, "=")
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func sortedPartitionPaths(partitions map[string]*PartitionInfo) []string {
+	paths := make([]string, 0, len(partitions))
+	for path := range partitions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// compressionRatio returns the uncompressed size divided by the compressed size, 0 for an empty column
+func compressionRatio(uncompressed, compressed int64) float64 {
+	if compressed == 0 {
+		return 0
+	}
+	return float64(uncompressed) / float64(compressed)
+}
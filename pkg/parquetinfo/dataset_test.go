@@ -0,0 +1,95 @@
+package parquetinfo
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// writeDecimalFile writes the unscaled values in a parquet file with a decimal(10,2) column
+func writeDecimalFile(t *testing.T, path string, values []int64) {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "amount", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}}}, nil)
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+	for _, v := range values {
+		builder.Field(0).(*array.Decimal128Builder).Append(decimal128.FromI64(v))
+	}
+	record := builder.NewRecord()
+	defer record.Release()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := pqarrow.NewFileWriter(schema, f, parquet.NewWriterProperties(), pqarrow.DefaultWriterProps())
+	if err != nil {
+		t.Fatalf("NewFileWriter() error: %v", err)
+	}
+	if err := writer.Write(record); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+}
+
+func TestDescribeDatasetDecimalStatistics(t *testing.T) {
+	root := t.TempDir()
+	// the negative decimals have a leading 0xff byte, they must not sort above the positive ones
+	writeDecimalFile(t, filepath.Join(root, "part-0.parquet"), []int64{-1250, 300})
+	writeDecimalFile(t, filepath.Join(root, "part-1.parquet"), []int64{5, 12})
+	writeDecimalFile(t, filepath.Join(root, "part-2.parquet"), []int64{-7, -1})
+	_, files, err := ListDatasetFiles(root)
+	if err != nil {
+		t.Fatalf("ListDatasetFiles() error: %v", err)
+	}
+	dataset, err := DescribeDataset(context.Background(), root, files, nil)
+	if err != nil {
+		t.Fatalf("DescribeDataset() error: %v", err)
+	}
+	if len(dataset.Columns) != 1 {
+		t.Fatalf("DescribeDataset() returned %d columns, want 1", len(dataset.Columns))
+	}
+	column := dataset.Columns[0]
+	if got := FormatStatValue(column.Min); got != "-12.50" {
+		t.Errorf("min = %s, want -12.50", got)
+	}
+	if got := FormatStatValue(column.Max); got != "3.00" {
+		t.Errorf("max = %s, want 3.00", got)
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	tests := []struct {
+		unscaled int64
+		scale    int32
+		want     string
+	}{
+		{1250, 2, "12.50"},
+		{-1250, 2, "-12.50"},
+		{-5, 3, "-0.005"},
+		{0, 2, "0.00"},
+		{42, 0, "42"},
+		{-42, -2, "-4200"},
+	}
+	for _, tt := range tests {
+		if got := (Decimal{Unscaled: big.NewInt(tt.unscaled), Scale: tt.scale}).String(); got != tt.want {
+			t.Errorf("Decimal{%d, %d}.String() = %s, want %s", tt.unscaled, tt.scale, got, tt.want)
+		}
+	}
+	if c := (Decimal{Unscaled: big.NewInt(-1250), Scale: 2}).Cmp(Decimal{Unscaled: big.NewInt(3), Scale: 0}); c >= 0 {
+		t.Errorf("Cmp(-12.50, 3) = %d, want -1", c)
+	}
+	if c := (Decimal{Unscaled: big.NewInt(300), Scale: 2}).Cmp(Decimal{Unscaled: big.NewInt(3), Scale: 0}); c != 0 {
+		t.Errorf("Cmp(3.00, 3) = %d, want 0", c)
+	}
+}
@@ -0,0 +1,154 @@
+package parquetinfo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/parquet/metadata"
+	"github.com/apache/arrow-go/v18/parquet/schema"
+)
+
+// arrowSchemaKey is the key-value metadata entry holding the Arrow schema serialized by the Arrow writers
+const arrowSchemaKey = "ARROW:schema"
+
+// ArrowFieldInfo is a top-level field of the decoded Arrow schema
+type ArrowFieldInfo struct {
+	Name     string            `json:"name" yaml:"name"`
+	Type     string            `json:"type" yaml:"type"`
+	Nullable bool              `json:"nullable" yaml:"nullable"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// BloomFilterInfo locates the bloom filter of a column chunk. Length is the serialized size with its header,
+// 0 when the writer did not store it, NumBytes the size of the bitset, only read with DescribeOptions.BloomFilters.
+type BloomFilterInfo struct {
+	Offset   int64 `json:"offset" yaml:"offset"`
+	Length   int32 `json:"length,omitempty" yaml:"length,omitempty"`
+	NumBytes int64 `json:"num_bytes,omitempty" yaml:"num_bytes,omitempty"`
+}
+
+// PageIndexInfo is the column index and the offset index of a column chunk, one entry per data page.
+// BoundaryOrder tells whether the min and max of the pages are ASCENDING, DESCENDING or UNORDERED.
+type PageIndexInfo struct {
+	BoundaryOrder string     `json:"boundary_order,omitempty" yaml:"boundary_order,omitempty"`
+	Pages         []PageInfo `json:"pages" yaml:"pages"`
+}
+
+// PageInfo is a data page of the page index, the statistics are absent without a column index
+// and Min and Max are absent for the pages holding only nulls
+type PageInfo struct {
+	Offset         int64       `json:"offset" yaml:"offset"`
+	CompressedSize int32       `json:"compressed_size" yaml:"compressed_size"`
+	FirstRowIndex  int64       `json:"first_row_index" yaml:"first_row_index"`
+	NullPage       bool        `json:"null_page,omitempty" yaml:"null_page,omitempty"`
+	NullCount      *int64      `json:"null_count,omitempty" yaml:"null_count,omitempty"`
+	Min            interface{} `json:"min,omitempty" yaml:"min,omitempty"`
+	Max            interface{} `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// decodeArrowSchema decodes the base64 IPC message of the ARROW:schema entry, padded or not like pqarrow
+func decodeArrowSchema(serialized string) ([]ArrowFieldInfo, error) {
+	decoded, err := base64.StdEncoding.DecodeString(serialized)
+	if err != nil {
+		if decoded, err = base64.RawStdEncoding.DecodeString(serialized); err != nil {
+			return nil, err
+		}
+	}
+	reader, err := ipc.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+	var fields []ArrowFieldInfo
+	for _, field := range reader.Schema().Fields() {
+		info := ArrowFieldInfo{Name: field.Name, Type: field.Type.String(), Nullable: field.Nullable}
+		if field.Metadata.Len() > 0 {
+			info.Metadata = make(map[string]string, field.Metadata.Len())
+			for i, key := range field.Metadata.Keys() {
+				info.Metadata[key] = field.Metadata.Values()[i]
+			}
+		}
+		fields = append(fields, info)
+	}
+	return fields, nil
+}
+
+// readPageIndex sets the page index of the column chunks of the row group, the chunks without one are left as is
+func readPageIndex(f *File, rg *RowGroupInfo, sc *schema.Schema) error {
+	rgReader, err := f.Reader.GetPageIndexReader().RowGroup(rg.Index)
+	if err != nil {
+		return fmt.Errorf("failed to read the page index of row group %d: %w", rg.Index, err)
+	}
+	if rgReader == nil {
+		return nil
+	}
+	for i := range rg.Columns {
+		chunk := &rg.Columns[i]
+		if chunk.Encrypted {
+			continue
+		}
+		offsetIndex, err := rgReader.GetOffsetIndex(i)
+		if err != nil {
+			return fmt.Errorf("failed to read the offset index of column %s in row group %d: %w", chunk.Path, rg.Index, err)
+		}
+		if offsetIndex == nil {
+			continue
+		}
+		columnIndex, err := rgReader.GetColumnIndex(i)
+		if err != nil {
+			return fmt.Errorf("failed to read the column index of column %s in row group %d: %w", chunk.Path, rg.Index, err)
+		}
+		chunk.PageIndex = describePageIndex(sc.Column(i), offsetIndex, columnIndex)
+	}
+	return nil
+}
+
+func describePageIndex(c *schema.Column, offsetIndex metadata.OffsetIndex, columnIndex metadata.ColumnIndex) *PageIndexInfo {
+	info := &PageIndexInfo{}
+	for _, loc := range offsetIndex.GetPageLocations() {
+		info.Pages = append(info.Pages, PageInfo{Offset: loc.Offset, CompressedSize: loc.CompressedPageSize, FirstRowIndex: loc.FirstRowIndex})
+	}
+	if columnIndex == nil {
+		return info
+	}
+	info.BoundaryOrder = columnIndex.GetBoundaryOrder().String()
+	nullPages, minValues, maxValues := columnIndex.GetNullPages(), columnIndex.GetMinValues(), columnIndex.GetMaxValues()
+	for i := range info.Pages {
+		page := &info.Pages[i]
+		if i < len(nullPages) && nullPages[i] {
+			page.NullPage = true
+		} else if i < len(minValues) && i < len(maxValues) {
+			page.Min = StatValue(c, metadata.GetStatValue(c.PhysicalType(), minValues[i]))
+			page.Max = StatValue(c, metadata.GetStatValue(c.PhysicalType(), maxValues[i]))
+		}
+		if columnIndex.IsSetNullCounts() && i < len(columnIndex.GetNullCounts()) {
+			n := columnIndex.GetNullCounts()[i]
+			page.NullCount = &n
+		}
+	}
+	return info
+}
+
+// readBloomFilters sets the size of the bitsets of the bloom filters of the row group
+func readBloomFilters(f *File, rg *RowGroupInfo) error {
+	rgReader, err := f.Reader.GetBloomFilterReader().RowGroup(rg.Index)
+	if err != nil {
+		return fmt.Errorf("failed to read the bloom filters of row group %d: %w", rg.Index, err)
+	}
+	for i := range rg.Columns {
+		chunk := &rg.Columns[i]
+		if chunk.BloomFilter == nil {
+			continue
+		}
+		filter, err := rgReader.GetColumnBloomFilter(i)
+		if err != nil {
+			return fmt.Errorf("failed to read the bloom filter of column %s in row group %d: %w", chunk.Path, rg.Index, err)
+		}
+		if filter != nil {
+			chunk.BloomFilter.NumBytes = filter.Size()
+		}
+	}
+	return nil
+}
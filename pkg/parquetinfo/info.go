@@ -0,0 +1,359 @@
+package parquetinfo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/metadata"
+	"github.com/apache/arrow-go/v18/parquet/schema"
+)
+
+// FileInfo is the metadata of a parquet file, it is the document written by parquetInfo --format json|yaml.
+// The sizes and offsets are in bytes.
+type FileInfo struct {
+	Location string `json:"location" yaml:"location"`
+	// Version is the format version of the file, 1 or 2
+	Version   int32  `json:"version" yaml:"version"`
+	CreatedBy string `json:"created_by" yaml:"created_by"`
+	NumRows   int64  `json:"num_rows" yaml:"num_rows"`
+	// FileSize is the size of the whole file, FooterSize the size of its serialized metadata
+	FileSize     int64 `json:"file_size,omitempty" yaml:"file_size,omitempty"`
+	FooterSize   int   `json:"footer_size" yaml:"footer_size"`
+	NumRowGroups int   `json:"num_row_groups" yaml:"num_row_groups"`
+	NumColumns   int   `json:"num_columns" yaml:"num_columns"`
+	// Encryption is the algorithm of an encrypted file, AES_GCM_V1 or AES_GCM_CTR_V1
+	Encryption       string            `json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	KeyValueMetadata map[string]string `json:"key_value_metadata,omitempty" yaml:"key_value_metadata,omitempty"`
+	// ArrowSchema is the ARROW:schema entry of the key-value metadata decoded, written by the Arrow writers
+	ArrowSchema []ArrowFieldInfo `json:"arrow_schema,omitempty" yaml:"arrow_schema,omitempty"`
+	Schema      SchemaInfo       `json:"schema" yaml:"schema"`
+	RowGroups   []RowGroupInfo   `json:"row_groups" yaml:"row_groups"`
+}
+
+// SchemaInfo is the parquet schema printed as a message and its leaf columns
+type SchemaInfo struct {
+	Text    string       `json:"text" yaml:"text"`
+	Columns []ColumnInfo `json:"columns" yaml:"columns"`
+}
+
+// ColumnInfo is a leaf column of the schema, Path joins the names of the nested fields with dots
+type ColumnInfo struct {
+	Index        int    `json:"index" yaml:"index"`
+	Path         string `json:"path" yaml:"path"`
+	PhysicalType string `json:"physical_type" yaml:"physical_type"`
+	LogicalType  string `json:"logical_type,omitempty" yaml:"logical_type,omitempty"`
+	// ConvertedType is the legacy annotation of the type, still read by the older readers
+	ConvertedType string `json:"converted_type,omitempty" yaml:"converted_type,omitempty"`
+	// TypeLength is the size of the FIXED_LEN_BYTE_ARRAY values
+	TypeLength int `json:"type_length,omitempty" yaml:"type_length,omitempty"`
+	// Repetition is REQUIRED, OPTIONAL or REPEATED for the leaf, the levels count its optional and repeated ancestors
+	Repetition         string `json:"repetition" yaml:"repetition"`
+	MaxDefinitionLevel int16  `json:"max_definition_level" yaml:"max_definition_level"`
+	MaxRepetitionLevel int16  `json:"max_repetition_level" yaml:"max_repetition_level"`
+}
+
+// RowGroupInfo is the metadata of a row group and of its column chunks
+type RowGroupInfo struct {
+	Index   int   `json:"index" yaml:"index"`
+	NumRows int64 `json:"num_rows" yaml:"num_rows"`
+	// TotalByteSize is the uncompressed size of the row group, TotalCompressedSize its size in the file
+	TotalByteSize       int64             `json:"total_byte_size" yaml:"total_byte_size"`
+	TotalCompressedSize int64             `json:"total_compressed_size" yaml:"total_compressed_size"`
+	FileOffset          int64             `json:"file_offset" yaml:"file_offset"`
+	Columns             []ColumnChunkInfo `json:"columns" yaml:"columns"`
+}
+
+// ColumnChunkInfo is the metadata of a column in a row group. When the column is encrypted with a key
+// that was not given, only Path and Encrypted are set.
+type ColumnChunkInfo struct {
+	Path                 string           `json:"path" yaml:"path"`
+	Encrypted            bool             `json:"encrypted,omitempty" yaml:"encrypted,omitempty"`
+	PhysicalType         string           `json:"physical_type,omitempty" yaml:"physical_type,omitempty"`
+	NumValues            int64            `json:"num_values" yaml:"num_values"`
+	Compression          string           `json:"compression,omitempty" yaml:"compression,omitempty"`
+	Encodings            []string         `json:"encodings,omitempty" yaml:"encodings,omitempty"`
+	CompressedSize       int64            `json:"compressed_size" yaml:"compressed_size"`
+	UncompressedSize     int64            `json:"uncompressed_size" yaml:"uncompressed_size"`
+	FileOffset           int64            `json:"file_offset" yaml:"file_offset"`
+	DataPageOffset       int64            `json:"data_page_offset" yaml:"data_page_offset"`
+	DictionaryPageOffset *int64           `json:"dictionary_page_offset,omitempty" yaml:"dictionary_page_offset,omitempty"`
+	IndexPageOffset      *int64           `json:"index_page_offset,omitempty" yaml:"index_page_offset,omitempty"`
+	Statistics           *StatisticsInfo  `json:"statistics,omitempty" yaml:"statistics,omitempty"`
+	BloomFilter          *BloomFilterInfo `json:"bloom_filter,omitempty" yaml:"bloom_filter,omitempty"`
+	// PageIndex is only read with DescribeOptions.PageIndex
+	PageIndex *PageIndexInfo `json:"page_index,omitempty" yaml:"page_index,omitempty"`
+}
+
+// StatisticsInfo is the statistics of a column chunk, the counts are absent when the writer did not store them.
+// Min and Max are the physical values: numbers and booleans as is, UTF8 strings as strings, decimals as Decimal,
+// the other binary values in hex prefixed with \x, INT96 as a timestamp and the non-finite floats as strings.
+type StatisticsInfo struct {
+	NullCount     *int64      `json:"null_count,omitempty" yaml:"null_count,omitempty"`
+	DistinctCount *int64      `json:"distinct_count,omitempty" yaml:"distinct_count,omitempty"`
+	Min           interface{} `json:"min,omitempty" yaml:"min,omitempty"`
+	Max           interface{} `json:"max,omitempty" yaml:"max,omitempty"`
+}
+
+// DescribeOptions selects the parts of the metadata stored outside of the footer, that need more reads
+type DescribeOptions struct {
+	// PageIndex reads the column index and the offset index of each column chunk
+	PageIndex bool
+	// BloomFilters reads the bloom filters to get the size of their bitset
+	BloomFilters bool
+}
+
+// Describe returns the metadata of the open file, the column chunks that cannot be decrypted are marked Encrypted
+func Describe(f *File, options DescribeOptions) (*FileInfo, error) {
+	md := f.Reader.MetaData()
+	info := &FileInfo{
+		Location:     f.Location,
+		Version:      md.GetVersion(),
+		CreatedBy:    md.GetCreatedBy(),
+		NumRows:      md.GetNumRows(),
+		FileSize:     md.GetSourceFileSize(),
+		FooterSize:   md.Size(),
+		NumRowGroups: md.NumRowGroups(),
+		NumColumns:   md.NumColumns(),
+		Schema:       SchemaInfo{Text: md.Schema.String()},
+	}
+	if md.IsSetEncryptionAlgorithm() {
+		info.Encryption = encryptionAlgorithm(md.EncryptionAlgorithm())
+	}
+	if kv := md.KeyValueMetadata(); kv.Len() > 0 {
+		info.KeyValueMetadata = make(map[string]string, kv.Len())
+		for i, key := range kv.Keys() {
+			info.KeyValueMetadata[key] = kv.Values()[i]
+		}
+		if serialized, found := info.KeyValueMetadata[arrowSchemaKey]; found {
+			fields, err := decodeArrowSchema(serialized)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in %s: %w", arrowSchemaKey, f.Location, err)
+			}
+			info.ArrowSchema = fields
+		}
+	}
+	for i, c := range md.Schema.Columns() {
+		info.Schema.Columns = append(info.Schema.Columns, describeColumn(i, c))
+	}
+	for i := 0; i < md.NumRowGroups(); i++ {
+		rg := describeRowGroup(i, md.RowGroup(i), md.Schema)
+		if options.PageIndex {
+			if err := readPageIndex(f, &rg, md.Schema); err != nil {
+				return nil, err
+			}
+		}
+		if options.BloomFilters {
+			if err := readBloomFilters(f, &rg); err != nil {
+				return nil, err
+			}
+		}
+		info.RowGroups = append(info.RowGroups, rg)
+	}
+	return info, nil
+}
+
+func describeColumn(index int, c *schema.Column) ColumnInfo {
+	col := ColumnInfo{
+		Index:              index,
+		Path:               c.Path(),
+		PhysicalType:       c.PhysicalType().String(),
+		Repetition:         c.SchemaNode().RepetitionType().String(),
+		MaxDefinitionLevel: c.MaxDefinitionLevel(),
+		MaxRepetitionLevel: c.MaxRepetitionLevel(),
+	}
+	if c.ConvertedType() != schema.ConvertedTypes.None && c.ConvertedType() != schema.ConvertedTypes.NA {
+		col.ConvertedType = c.ConvertedType().String()
+	}
+	if c.LogicalType() != nil && c.LogicalType().IsValid() && !c.LogicalType().IsNone() {
+		col.LogicalType = c.LogicalType().String()
+	}
+	if c.PhysicalType() == parquet.Types.FixedLenByteArray {
+		col.TypeLength = c.TypeLength()
+	}
+	return col
+}
+
+func describeRowGroup(index int, rg *metadata.RowGroupMetaData, sc *schema.Schema) RowGroupInfo {
+	info := RowGroupInfo{
+		Index:               index,
+		NumRows:             rg.NumRows(),
+		TotalByteSize:       rg.TotalByteSize(),
+		TotalCompressedSize: rg.TotalCompressedSize(),
+		FileOffset:          rg.FileOffset(),
+	}
+	for i := 0; i < rg.NumColumns(); i++ {
+		info.Columns = append(info.Columns, describeColumnChunk(rg, i, sc.Column(i)))
+	}
+	return info
+}
+
+func describeColumnChunk(rg *metadata.RowGroupMetaData, index int, c *schema.Column) ColumnChunkInfo {
+	cm, err := rg.ColumnChunk(index)
+	if err != nil {
+		// the metadata of a column encrypted with its own key cannot be read without this key
+		return ColumnChunkInfo{Path: c.Path(), Encrypted: true}
+	}
+	chunk := ColumnChunkInfo{
+		Path:             cm.PathInSchema().String(),
+		PhysicalType:     cm.Type().String(),
+		NumValues:        cm.NumValues(),
+		Compression:      cm.Compression().String(),
+		CompressedSize:   cm.TotalCompressedSize(),
+		UncompressedSize: cm.TotalUncompressedSize(),
+		FileOffset:       cm.FileOffset(),
+		DataPageOffset:   cm.DataPageOffset(),
+	}
+	for _, enc := range cm.Encodings() {
+		chunk.Encodings = append(chunk.Encodings, enc.String())
+	}
+	if cm.HasDictionaryPage() {
+		offset := cm.DictionaryPageOffset()
+		chunk.DictionaryPageOffset = &offset
+	}
+	if cm.HasIndexPage() {
+		offset := cm.IndexPageOffset()
+		chunk.IndexPageOffset = &offset
+	}
+	if offset := cm.BloomFilterOffset(); offset > 0 {
+		chunk.BloomFilter = &BloomFilterInfo{Offset: offset, Length: cm.BloomFilterLength()}
+	}
+	if stats, err := cm.Statistics(); err == nil && stats != nil {
+		chunk.Statistics = &StatisticsInfo{}
+		if stats.HasNullCount() {
+			n := stats.NullCount()
+			chunk.Statistics.NullCount = &n
+		}
+		if stats.HasDistinctCount() {
+			n := stats.DistinctCount()
+			chunk.Statistics.DistinctCount = &n
+		}
+		if stats.HasMinMax() {
+			chunk.Statistics.Min = StatValue(c, metadata.GetStatValue(stats.Type(), stats.EncodeMin()))
+			chunk.Statistics.Max = StatValue(c, metadata.GetStatValue(stats.Type(), stats.EncodeMax()))
+		}
+	}
+	return chunk
+}
+
+// StatValue converts a value decoded by metadata.GetStatValue to a value that can be encoded in JSON or YAML
+func StatValue(c *schema.Column, v interface{}) interface{} {
+	if scale, ok := decimalScale(c); ok {
+		switch x := v.(type) {
+		case int32:
+			return Decimal{Unscaled: big.NewInt(int64(x)), Scale: scale}
+		case int64:
+			return Decimal{Unscaled: big.NewInt(x), Scale: scale}
+		case []byte:
+			return Decimal{Unscaled: twosComplement(x), Scale: scale}
+		}
+	}
+	switch x := v.(type) {
+	case []byte:
+		if isUTF8(c) {
+			return string(x)
+		}
+		return `\x` + hex.EncodeToString(x)
+	case parquet.Int96:
+		return x.ToTime().UTC()
+	case float32:
+		return finiteFloat(float64(x))
+	case float64:
+		return finiteFloat(x)
+	default:
+		return v
+	}
+}
+
+// Decimal is a statistic of a decimal column, the unscaled integer stored in the file and the scale of the column,
+// it is written as a decimal string and the decimals of the same column are compared by value
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int32
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	if d.Scale <= 0 {
+		digits += strings.Repeat("0", int(-d.Scale))
+	} else {
+		if len(digits) <= int(d.Scale) {
+			digits = strings.Repeat("0", int(d.Scale)-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-int(d.Scale)] + "." + digits[len(digits)-int(d.Scale):]
+	}
+	if d.Unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// MarshalText writes the decimal string in JSON and YAML
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Cmp compares the values of two decimals, whatever their scale
+func (d Decimal) Cmp(other Decimal) int {
+	return d.rat().Cmp(other.rat())
+}
+
+func (d Decimal) rat() *big.Rat {
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(max(d.Scale, -d.Scale))), nil)
+	if d.Scale < 0 {
+		return new(big.Rat).SetInt(new(big.Int).Mul(d.Unscaled, exp))
+	}
+	return new(big.Rat).SetFrac(d.Unscaled, exp)
+}
+
+// decimalScale returns the scale of a decimal column
+func decimalScale(c *schema.Column) (int32, bool) {
+	switch t := c.LogicalType().(type) {
+	case schema.DecimalLogicalType:
+		return t.Scale(), true
+	case *schema.DecimalLogicalType:
+		return t.Scale(), true
+	}
+	return 0, false
+}
+
+// twosComplement decodes the big-endian two's complement integer of the binary decimals
+func twosComplement(b []byte) *big.Int {
+	x := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return x
+}
+
+// finiteFloat returns NaN and the infinities as strings since JSON has no literal for them
+func finiteFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
+
+// isUTF8 returns true for the binary columns holding text
+func isUTF8(c *schema.Column) bool {
+	if c.LogicalType() != nil {
+		switch c.LogicalType().(type) {
+		case schema.StringLogicalType, *schema.StringLogicalType,
+			schema.EnumLogicalType, *schema.EnumLogicalType,
+			schema.JSONLogicalType, *schema.JSONLogicalType:
+			return true
+		}
+	}
+	return c.ConvertedType() == schema.ConvertedTypes.UTF8
+}
+
+func encryptionAlgorithm(algorithm parquet.Algorithm) string {
+	if algorithm.Algo == parquet.AesCtr {
+		return "AES_GCM_CTR_V1"
+	}
+	return "AES_GCM_V1"
+}
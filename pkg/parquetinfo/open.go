@@ -0,0 +1,40 @@
+// Package parquetinfo describes the parquet files written by this project: metadata, schema and statistics.
+package parquetinfo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2parquet"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+)
+
+// File is an open parquet file with its location
+type File struct {
+	Location string
+	Reader   *file.Reader
+	r        sink.Reader
+}
+
+// Open opens the parquet file at location, a local path or an s3://bucket/key object.
+// The keys of decryption decrypt the footer and the encrypted columns, nil reads a file in clear.
+func Open(ctx context.Context, location string, decryption *db2parquet.EncryptionOptions) (*File, error) {
+	r, err := sink.OpenReader(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := file.NewParquetReader(r, file.WithReadProps(decryption.ReaderProperties(memory.DefaultAllocator)))
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to read parquet file %s: %w", location, err)
+	}
+	return &File{Location: location, Reader: reader, r: r}, nil
+}
+
+// Close closes the reader and the underlying file
+func (f *File) Close() error {
+	f.Reader.Close()
+	return f.r.Close()
+}
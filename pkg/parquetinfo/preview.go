@@ -0,0 +1,240 @@
+package parquetinfo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// PreviewMode selects the rows returned by Preview
+type PreviewMode string
+
+const (
+	// PreviewHead returns the first rows
+	PreviewHead PreviewMode = "head"
+	// PreviewTail returns the last rows, only the row groups holding them are read
+	PreviewTail PreviewMode = "tail"
+	// PreviewSample returns rows drawn uniformly without replacement, in file order
+	PreviewSample PreviewMode = "sample"
+	// previewBatchRows is the batch size of the Arrow reader used by Preview
+	previewBatchRows = 8 * 1024
+)
+
+// PreviewOptions defines the rows and the columns returned by Preview
+type PreviewOptions struct {
+	Mode PreviewMode
+	// Rows is the number of rows returned, fewer when the file is smaller
+	Rows int64
+	// Columns lists the top-level columns returned in this order, all the columns when empty
+	Columns []string
+	// RowGroups restricts the rows to these row groups, all the row groups when empty
+	RowGroups []int
+	// Seed makes the sample reproducible, 0 draws a random seed
+	Seed uint64
+}
+
+// rowRange is the half-open range [start, end) of the positions of rows in the selected row groups
+type rowRange struct {
+	start, end int64
+}
+
+// Preview reads the rows selected by the options through pqarrow and returns them in one record
+func Preview(ctx context.Context, f *File, options PreviewOptions, mem memory.Allocator) (arrow.Record, error) {
+	if options.Rows < 0 {
+		return nil, fmt.Errorf("invalid number of rows %d", options.Rows)
+	}
+	reader, err := pqarrow.NewFileReader(f.Reader, pqarrow.ArrowReadProperties{BatchSize: previewBatchRows}, mem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Arrow reader for %s: %w", f.Location, err)
+	}
+	leafColumns, err := selectColumns(f, reader, options.Columns)
+	if err != nil {
+		return nil, err
+	}
+	rowGroups := options.RowGroups
+	if len(rowGroups) == 0 {
+		for i := 0; i < f.Reader.NumRowGroups(); i++ {
+			rowGroups = append(rowGroups, i)
+		}
+	}
+	var total int64
+	rowGroupRows := make([]int64, len(rowGroups))
+	for i, rg := range rowGroups {
+		if rg < 0 || rg >= f.Reader.NumRowGroups() {
+			return nil, fmt.Errorf("row group %d does not exist in %s, it has %d row groups", rg, f.Location, f.Reader.NumRowGroups())
+		}
+		rowGroupRows[i] = f.Reader.MetaData().RowGroup(rg).NumRows()
+		total += rowGroupRows[i]
+	}
+	n := min(options.Rows, total)
+	var ranges []rowRange
+	switch options.Mode {
+	case "", PreviewHead:
+		ranges = []rowRange{{0, n}}
+	case PreviewTail:
+		ranges = []rowRange{{total - n, total}}
+	case PreviewSample:
+		seed := options.Seed
+		if seed == 0 {
+			seed = rand.Uint64()
+		}
+		for _, pos := range samplePositions(total, n, seed) {
+			ranges = append(ranges, rowRange{pos, pos + 1})
+		}
+	default:
+		return nil, fmt.Errorf("invalid preview mode %s, expected head, tail or sample", options.Mode)
+	}
+	return readRanges(ctx, reader, leafColumns, rowGroups, rowGroupRows, ranges, mem)
+}
+
+// selectColumns returns the leaf columns of the top-level columns in this order, nil for all the columns
+func selectColumns(f *File, reader *pqarrow.FileReader, columns []string) ([]int, error) {
+	if len(columns) == 0 {
+		return nil, nil
+	}
+	schema, err := reader.Schema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Arrow schema of %s: %w", f.Location, err)
+	}
+	fields := make([]int, len(columns))
+	for i, name := range columns {
+		indices := schema.FieldIndices(name)
+		if len(indices) == 0 {
+			return nil, fmt.Errorf("column %s not found in %s", name, f.Location)
+		}
+		fields[i] = indices[0]
+	}
+	leafColumns, err := reader.Manifest.GetFieldIndices(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select columns of %s: %w", f.Location, err)
+	}
+	return leafColumns, nil
+}
+
+// samplePositions draws n distinct positions in [0, total) with Floyd's algorithm and returns them sorted
+func samplePositions(total, n int64, seed uint64) []int64 {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	chosen := make(map[int64]struct{}, n)
+	for j := total - n; j < total; j++ {
+		pos := rng.Int64N(j + 1)
+		if _, found := chosen[pos]; found {
+			pos = j
+		}
+		chosen[pos] = struct{}{}
+	}
+	positions := make([]int64, 0, n)
+	for pos := range chosen {
+		positions = append(positions, pos)
+	}
+	slices.Sort(positions)
+	return positions
+}
+
+// readRanges reads the rows of the sorted ranges, the row groups without any row in the ranges are skipped
+func readRanges(ctx context.Context, reader *pqarrow.FileReader, leafColumns, rowGroups []int, rowGroupRows []int64, ranges []rowRange, mem memory.Allocator) (arrow.Record, error) {
+	// keep the row groups holding rows of the ranges, a range only spans kept row groups
+	// so it moves by the number of rows skipped before its first row group
+	type span struct{ start, end, skipped int64 }
+	kept := []int{} // not nil, GetRecordReader reads every row group for nil
+	var spans []span
+	var offset, skipped int64
+	next := 0
+	for i, rg := range rowGroups {
+		start, end := offset, offset+rowGroupRows[i]
+		offset = end
+		for next < len(ranges) && ranges[next].end <= start {
+			next++
+		}
+		if next < len(ranges) && ranges[next].start < end {
+			kept = append(kept, rg)
+			spans = append(spans, span{start, end, skipped})
+		} else {
+			skipped += end - start
+		}
+	}
+	rebased := make([]rowRange, 0, len(ranges))
+	g := 0
+	for _, r := range ranges {
+		if r.start == r.end {
+			continue
+		}
+		for spans[g].end <= r.start {
+			g++
+		}
+		rebased = append(rebased, rowRange{r.start - spans[g].skipped, r.end - spans[g].skipped})
+	}
+	recordReader, err := reader.GetRecordReader(ctx, leafColumns, kept)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+	defer recordReader.Release()
+	schema := recordReader.Schema()
+
+	var parts []arrow.Record
+	defer func() {
+		for _, part := range parts {
+			part.Release()
+		}
+	}()
+	offset, next = 0, 0
+	for next < len(rebased) {
+		record, err := recordReader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read records: %w", err)
+		}
+		start, end := offset, offset+record.NumRows()
+		offset = end
+		for next < len(rebased) && rebased[next].start < end {
+			r := rebased[next]
+			parts = append(parts, record.NewSlice(max(r.start, start)-start, min(r.end, end)-start))
+			if r.end > end {
+				break
+			}
+			next++
+		}
+	}
+	return concatenateRecords(schema, parts, mem)
+}
+
+// concatenateRecords returns one record with the rows of all the records
+func concatenateRecords(schema *arrow.Schema, records []arrow.Record, mem memory.Allocator) (arrow.Record, error) {
+	columns := make([]arrow.Array, schema.NumFields())
+	defer func() {
+		for _, col := range columns {
+			if col != nil {
+				col.Release()
+			}
+		}
+	}()
+	var numRows int64
+	for _, record := range records {
+		numRows += record.NumRows()
+	}
+	for i, field := range schema.Fields() {
+		if len(records) == 0 {
+			columns[i] = array.MakeArrayOfNull(mem, field.Type, 0)
+			continue
+		}
+		chunks := make([]arrow.Array, len(records))
+		for j, record := range records {
+			chunks[j] = record.Column(i)
+		}
+		col, err := array.Concatenate(chunks, mem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to concatenate column %s: %w", field.Name, err)
+		}
+		columns[i] = col
+	}
+	return array.NewRecord(schema, columns, numRows), nil
+}
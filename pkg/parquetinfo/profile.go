@@ -0,0 +1,462 @@
+package parquetinfo
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+const (
+	// DefaultProfileTopK is the number of most frequent values of the string, binary and boolean columns
+	DefaultProfileTopK = 10
+	// DefaultProfileQuantileSample is the number of values of a numeric column kept to compute the quantiles
+	DefaultProfileQuantileSample = 100_000
+	// profileBatchRows is the batch size of the Arrow reader used by Profile
+	profileBatchRows = 64 * 1024
+	// minTopValuesCapacity is the minimum number of values tracked to find the most frequent ones
+	minTopValuesCapacity = 1000
+)
+
+// profileQuantiles are the quantiles of the numeric columns
+var profileQuantiles = []float64{0.01, 0.05, 0.25, 0.5, 0.75, 0.95, 0.99}
+
+// ProfileOptions defines the columns scanned by Profile and the size of the sketches
+type ProfileOptions struct {
+	// Columns lists the top-level columns profiled in this order, all the columns when empty
+	Columns []string
+	// TopK is the number of most frequent values returned, DefaultProfileTopK when 0
+	TopK int
+	// QuantileSample is the size of the uniform sample of a numeric column the quantiles are computed from,
+	// they are exact when the column has fewer values, DefaultProfileQuantileSample when 0
+	QuantileSample int
+	// Seed makes the quantile sample reproducible, 0 uses a fixed seed
+	Seed uint64
+}
+
+// ProfileInfo is the profile of the columns of a parquet file, computed by scanning the data
+type ProfileInfo struct {
+	Location string          `json:"location" yaml:"location"`
+	NumRows  int64           `json:"num_rows" yaml:"num_rows"`
+	Columns  []ColumnProfile `json:"columns" yaml:"columns"`
+}
+
+// ColumnProfile is the profile of a top-level column. DistinctCount is a HyperLogLog estimate of the distinct
+// non-null values, Min and Max are absent for the nested columns and the types without an order.
+type ColumnProfile struct {
+	Name          string      `json:"name" yaml:"name"`
+	Type          string      `json:"type" yaml:"type"`
+	Count         int64       `json:"count" yaml:"count"`
+	NullCount     int64       `json:"null_count" yaml:"null_count"`
+	NullRatio     float64     `json:"null_ratio" yaml:"null_ratio"`
+	DistinctCount uint64      `json:"distinct_count" yaml:"distinct_count"`
+	Min           interface{} `json:"min,omitempty" yaml:"min,omitempty"`
+	Max           interface{} `json:"max,omitempty" yaml:"max,omitempty"`
+	// Numeric is set for the integer, floating point and decimal columns
+	Numeric *NumericProfile `json:"numeric,omitempty" yaml:"numeric,omitempty"`
+	// TopValues are the most frequent values of the string, binary and boolean columns,
+	// TopValuesExact is false when the counts are upper bounds since the column has too many distinct values
+	TopValues      []ValueCount `json:"top_values,omitempty" yaml:"top_values,omitempty"`
+	TopValuesExact bool         `json:"top_values_exact,omitempty" yaml:"top_values_exact,omitempty"`
+	// Lengths is the distribution of the lengths of the strings in bytes, of the binaries and of the lists
+	Lengths *LengthProfile `json:"lengths,omitempty" yaml:"lengths,omitempty"`
+}
+
+// NumericProfile holds the moments and the quantiles of the non-null values, the NaN are only counted
+type NumericProfile struct {
+	Mean      float64         `json:"mean" yaml:"mean"`
+	StdDev    float64         `json:"stddev" yaml:"stddev"`
+	NaNCount  int64           `json:"nan_count,omitempty" yaml:"nan_count,omitempty"`
+	Quantiles []QuantileValue `json:"quantiles,omitempty" yaml:"quantiles,omitempty"`
+	// SampleSize is the number of values the quantiles are computed from
+	SampleSize int `json:"sample_size" yaml:"sample_size"`
+}
+
+// QuantileValue is the value below which the fraction Quantile of the values falls
+type QuantileValue struct {
+	Quantile float64 `json:"quantile" yaml:"quantile"`
+	Value    float64 `json:"value" yaml:"value"`
+}
+
+// ValueCount is a value with its number of occurrences
+type ValueCount struct {
+	Value string `json:"value" yaml:"value"`
+	Count int64  `json:"count" yaml:"count"`
+}
+
+// LengthProfile is the distribution of the lengths, in buckets of powers of two
+type LengthProfile struct {
+	Min       int64          `json:"min" yaml:"min"`
+	Max       int64          `json:"max" yaml:"max"`
+	Mean      float64        `json:"mean" yaml:"mean"`
+	Histogram []LengthBucket `json:"histogram" yaml:"histogram"`
+}
+
+// LengthBucket counts the lengths in [Min, Max]
+type LengthBucket struct {
+	Min   int64 `json:"min" yaml:"min"`
+	Max   int64 `json:"max" yaml:"max"`
+	Count int64 `json:"count" yaml:"count"`
+}
+
+// Profile scans the columns of the file through pqarrow and computes their profiles
+func Profile(ctx context.Context, f *File, options ProfileOptions, mem memory.Allocator) (*ProfileInfo, error) {
+	if options.TopK <= 0 {
+		options.TopK = DefaultProfileTopK
+	}
+	if options.QuantileSample <= 0 {
+		options.QuantileSample = DefaultProfileQuantileSample
+	}
+	reader, err := pqarrow.NewFileReader(f.Reader, pqarrow.ArrowReadProperties{BatchSize: profileBatchRows}, mem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Arrow reader for %s: %w", f.Location, err)
+	}
+	leafColumns, err := selectColumns(f, reader, options.Columns)
+	if err != nil {
+		return nil, err
+	}
+	recordReader, err := reader.GetRecordReader(ctx, leafColumns, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records of %s: %w", f.Location, err)
+	}
+	defer recordReader.Release()
+
+	profilers := make([]*columnProfiler, recordReader.Schema().NumFields())
+	for i, field := range recordReader.Schema().Fields() {
+		profilers[i] = newColumnProfiler(field, options, uint64(i))
+	}
+	info := &ProfileInfo{Location: f.Location}
+	for {
+		record, err := recordReader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read records of %s: %w", f.Location, err)
+		}
+		info.NumRows += record.NumRows()
+		for i, profiler := range profilers {
+			profiler.add(record.Column(i))
+		}
+	}
+	for _, profiler := range profilers {
+		info.Columns = append(info.Columns, profiler.result(options.TopK))
+	}
+	return info, nil
+}
+
+// profileKind selects the statistics computed for a column from its Arrow type
+type profileKind int
+
+const (
+	profileOther profileKind = iota
+	profileNumeric
+	profileString
+	profileBinary
+	profileBoolean
+	profileTemporal
+	profileList
+)
+
+// columnProfiler accumulates the profile of a column over the records
+type columnProfiler struct {
+	profile ColumnProfile
+	kind    profileKind
+	hll     hyperLogLog
+	// topValues tracks the most frequent values of the string, binary and boolean columns
+	topValues *spaceSaving
+	// count, mean and m2 are the state of Welford's algorithm for the variance
+	count    int64
+	mean, m2 float64
+	nanCount int64
+	// sample is a reservoir of the numeric values for the quantiles
+	sample     []float64
+	sampleSize int
+	rng        *rand.Rand
+	// lengths counts the lengths per bucket of powers of two, bucket i holds [2^(i-1), 2^i - 1]
+	lengths    [65]int64
+	lengthSum  int64
+	numLengths int64
+	minLength  int64
+	maxLength  int64
+}
+
+func newColumnProfiler(field arrow.Field, options ProfileOptions, index uint64) *columnProfiler {
+	p := &columnProfiler{profile: ColumnProfile{Name: field.Name, Type: field.Type.String()}, kind: kindOf(field.Type)}
+	switch p.kind {
+	case profileNumeric:
+		p.sampleSize = options.QuantileSample
+		p.rng = rand.New(rand.NewPCG(options.Seed, index))
+	case profileString, profileBinary, profileBoolean:
+		p.topValues = newSpaceSaving(max(minTopValuesCapacity, 100*options.TopK))
+	}
+	return p
+}
+
+func kindOf(t arrow.DataType) profileKind {
+	switch t.ID() {
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64, arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+		arrow.FLOAT16, arrow.FLOAT32, arrow.FLOAT64, arrow.DECIMAL128, arrow.DECIMAL256:
+		return profileNumeric
+	case arrow.STRING, arrow.LARGE_STRING:
+		return profileString
+	case arrow.BINARY, arrow.LARGE_BINARY, arrow.FIXED_SIZE_BINARY:
+		return profileBinary
+	case arrow.BOOL:
+		return profileBoolean
+	case arrow.DATE32, arrow.DATE64, arrow.TIMESTAMP:
+		return profileTemporal
+	case arrow.LIST, arrow.LARGE_LIST, arrow.FIXED_SIZE_LIST:
+		return profileList
+	default:
+		return profileOther
+	}
+}
+
+func (p *columnProfiler) add(arr arrow.Array) {
+	for i := 0; i < arr.Len(); i++ {
+		p.profile.Count++
+		if arr.IsNull(i) {
+			p.profile.NullCount++
+			continue
+		}
+		switch p.kind {
+		case profileNumeric:
+			p.addNumeric(arr, i)
+		case profileString, profileBinary:
+			s := byteString(arr, i)
+			p.hll.add(hashString(s))
+			p.bound(s)
+			p.topValues.add(s)
+			p.addLength(int64(len(s)))
+		case profileBoolean:
+			v := arr.(*array.Boolean).Value(i)
+			p.hll.add(mix64(uint64(b2i(v))))
+			p.bound(v)
+			p.topValues.add(strconv.FormatBool(v))
+		case profileTemporal:
+			t := timeValue(arr, i)
+			p.hll.add(mix64(uint64(t.UnixNano())))
+			p.bound(t)
+		case profileList:
+			p.hll.add(hashString(arr.ValueStr(i)))
+			start, end := arr.(array.ListLike).ValueOffsets(i)
+			p.addLength(end - start)
+		default:
+			p.hll.add(hashString(arr.ValueStr(i)))
+		}
+	}
+}
+
+// addNumeric adds a value of a numeric column, the integers keep their exact bounds
+func (p *columnProfiler) addNumeric(arr arrow.Array, i int) {
+	var v interface{}
+	var f float64
+	switch a := arr.(type) {
+	case *array.Int8:
+		v, f = int64(a.Value(i)), float64(a.Value(i))
+	case *array.Int16:
+		v, f = int64(a.Value(i)), float64(a.Value(i))
+	case *array.Int32:
+		v, f = int64(a.Value(i)), float64(a.Value(i))
+	case *array.Int64:
+		v, f = a.Value(i), float64(a.Value(i))
+	case *array.Uint8:
+		v, f = uint64(a.Value(i)), float64(a.Value(i))
+	case *array.Uint16:
+		v, f = uint64(a.Value(i)), float64(a.Value(i))
+	case *array.Uint32:
+		v, f = uint64(a.Value(i)), float64(a.Value(i))
+	case *array.Uint64:
+		v, f = a.Value(i), float64(a.Value(i))
+	case *array.Float16:
+		f = float64(a.Value(i).Float32())
+	case *array.Float32:
+		f = float64(a.Value(i))
+	case *array.Float64:
+		f = a.Value(i)
+	case *array.Decimal128:
+		f = a.Value(i).ToFloat64(a.DataType().(*arrow.Decimal128Type).Scale)
+	case *array.Decimal256:
+		f = a.Value(i).ToFloat64(a.DataType().(*arrow.Decimal256Type).Scale)
+	}
+	if math.IsNaN(f) {
+		p.nanCount++
+		p.hll.add(mix64(math.Float64bits(math.NaN())))
+		return
+	}
+	switch x := v.(type) {
+	case int64:
+		p.hll.add(mix64(uint64(x)))
+	case uint64:
+		p.hll.add(mix64(x))
+	default:
+		v = f
+		p.hll.add(mix64(math.Float64bits(f)))
+	}
+	p.bound(v)
+
+	p.count++
+	delta := f - p.mean
+	p.mean += delta / float64(p.count)
+	p.m2 += delta * (f - p.mean)
+
+	// reservoir sampling: the n-th value replaces a sampled one with the probability sampleSize/n
+	if len(p.sample) < p.sampleSize {
+		p.sample = append(p.sample, f)
+	} else if j := p.rng.Int64N(p.count); j < int64(p.sampleSize) {
+		p.sample[j] = f
+	}
+}
+
+// bound updates the min and the max, the strings are cloned as they reference the buffer of the array
+func (p *columnProfiler) bound(v interface{}) {
+	if c, ok := compareStatValues(v, p.profile.Min); p.profile.Min == nil || (ok && c < 0) {
+		p.profile.Min = cloneValue(v)
+	}
+	if c, ok := compareStatValues(v, p.profile.Max); p.profile.Max == nil || (ok && c > 0) {
+		p.profile.Max = cloneValue(v)
+	}
+}
+
+func (p *columnProfiler) addLength(length int64) {
+	p.lengths[bits.Len64(uint64(length))]++
+	p.lengthSum += length
+	if p.numLengths == 0 || length < p.minLength {
+		p.minLength = length
+	}
+	if p.numLengths == 0 || length > p.maxLength {
+		p.maxLength = length
+	}
+	p.numLengths++
+}
+
+// result returns the profile of the column, with the binary values in hex like StatValue
+func (p *columnProfiler) result(topK int) ColumnProfile {
+	profile := p.profile
+	if profile.Count > 0 {
+		profile.NullRatio = float64(profile.NullCount) / float64(profile.Count)
+	}
+	if profile.Count > profile.NullCount {
+		profile.DistinctCount = p.hll.estimate()
+	}
+	if p.kind == profileNumeric && (p.count > 0 || p.nanCount > 0) {
+		profile.Numeric = &NumericProfile{Mean: p.mean, NaNCount: p.nanCount, SampleSize: len(p.sample)}
+		if p.count > 1 {
+			profile.Numeric.StdDev = math.Sqrt(p.m2 / float64(p.count-1))
+		}
+		slices.Sort(p.sample)
+		for _, q := range profileQuantiles {
+			if len(p.sample) > 0 {
+				profile.Numeric.Quantiles = append(profile.Numeric.Quantiles, QuantileValue{Quantile: q, Value: quantile(p.sample, q)})
+			}
+		}
+		if f, ok := profile.Min.(float64); ok {
+			profile.Min = finiteFloat(f)
+		}
+		if f, ok := profile.Max.(float64); ok {
+			profile.Max = finiteFloat(f)
+		}
+	}
+	if p.topValues != nil {
+		profile.TopValues = p.topValues.top(topK)
+		profile.TopValuesExact = !p.topValues.replaced
+	}
+	if p.kind == profileBinary {
+		for i := range profile.TopValues {
+			profile.TopValues[i].Value = hexValue(profile.TopValues[i].Value)
+		}
+		if s, ok := profile.Min.(string); ok {
+			profile.Min = hexValue(s)
+		}
+		if s, ok := profile.Max.(string); ok {
+			profile.Max = hexValue(s)
+		}
+	}
+	if p.numLengths > 0 {
+		profile.Lengths = &LengthProfile{Min: p.minLength, Max: p.maxLength, Mean: float64(p.lengthSum) / float64(p.numLengths)}
+		for i, count := range p.lengths {
+			if count == 0 {
+				continue
+			}
+			bucket := LengthBucket{Count: count}
+			if i > 0 {
+				bucket.Min, bucket.Max = 1<<(i-1), 1<<i-1
+			}
+			profile.Lengths.Histogram = append(profile.Lengths.Histogram, bucket)
+		}
+	}
+	return profile
+}
+
+// quantile interpolates linearly between the closest ranks of the sorted values
+func quantile(sorted []float64, q float64) float64 {
+	position := q * float64(len(sorted)-1)
+	lower := int(position)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (position-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// byteString returns the value of a string or binary array, referencing its buffer
+func byteString(arr arrow.Array, i int) string {
+	switch a := arr.(type) {
+	case *array.String:
+		return a.Value(i)
+	case *array.LargeString:
+		return a.Value(i)
+	case *array.Binary:
+		return string(a.Value(i))
+	case *array.LargeBinary:
+		return string(a.Value(i))
+	case *array.FixedSizeBinary:
+		return string(a.Value(i))
+	default:
+		return arr.ValueStr(i)
+	}
+}
+
+func timeValue(arr arrow.Array, i int) time.Time {
+	switch a := arr.(type) {
+	case *array.Date32:
+		return a.Value(i).ToTime()
+	case *array.Date64:
+		return a.Value(i).ToTime()
+	case *array.Timestamp:
+		return a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit)
+	default:
+		return time.Time{}
+	}
+}
+
+func cloneValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return strings.Clone(s)
+	}
+	return v
+}
+
+func hexValue(s string) string {
+	return `\x` + hex.EncodeToString([]byte(s))
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,294 @@
+package parquetinfo
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/db2parquet"
+)
+
+// jsonSchemaDialect is the version of the JSON Schema documents returned by JSONSchema
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// ArrowSchema returns the Arrow schema of the file as read by pqarrow, with the field metadata stored by the Arrow writers
+func (f *File) ArrowSchema() (*arrow.Schema, error) {
+	reader, err := pqarrow.NewFileReader(f.Reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, err
+	}
+	return reader.Schema()
+}
+
+// KeyValue is an entry of the metadata of an Arrow schema or field
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ArrowSchemaDocument is an Arrow schema in the JSON layout of the Arrow integration tests
+type ArrowSchemaDocument struct {
+	Fields   []ArrowFieldDocument `json:"fields"`
+	Metadata []KeyValue           `json:"metadata,omitempty"`
+}
+
+// ArrowFieldDocument is a field of an ArrowSchemaDocument, Type holds the name and the parameters of the type
+type ArrowFieldDocument struct {
+	Name       string                   `json:"name"`
+	Nullable   bool                     `json:"nullable"`
+	Type       map[string]interface{}   `json:"type"`
+	Children   []ArrowFieldDocument     `json:"children"`
+	Dictionary *ArrowDictionaryDocument `json:"dictionary,omitempty"`
+	Metadata   []KeyValue               `json:"metadata,omitempty"`
+}
+
+// ArrowDictionaryDocument is the index type of a dictionary encoded field
+type ArrowDictionaryDocument struct {
+	IndexType map[string]interface{} `json:"indexType"`
+	IsOrdered bool                   `json:"isOrdered"`
+}
+
+// ArrowSchemaJSON returns the document describing the Arrow schema
+func ArrowSchemaJSON(schema *arrow.Schema) ArrowSchemaDocument {
+	document := ArrowSchemaDocument{Fields: make([]ArrowFieldDocument, len(schema.Fields())), Metadata: keyValues(schema.Metadata())}
+	for i, field := range schema.Fields() {
+		document.Fields[i] = arrowFieldDocument(field)
+	}
+	return document
+}
+
+func arrowFieldDocument(field arrow.Field) ArrowFieldDocument {
+	document := ArrowFieldDocument{Name: field.Name, Nullable: field.Nullable, Children: []ArrowFieldDocument{}, Metadata: keyValues(field.Metadata)}
+	dataType := field.Type
+	if dictionary, ok := dataType.(*arrow.DictionaryType); ok {
+		document.Dictionary = &ArrowDictionaryDocument{IndexType: arrowTypeDocument(dictionary.IndexType), IsOrdered: dictionary.Ordered}
+		dataType = dictionary.ValueType
+	}
+	document.Type = arrowTypeDocument(dataType)
+	switch t := dataType.(type) {
+	case arrow.ListLikeType:
+		document.Children = append(document.Children, arrowFieldDocument(t.ElemField()))
+	case *arrow.StructType:
+		for _, child := range t.Fields() {
+			document.Children = append(document.Children, arrowFieldDocument(child))
+		}
+	}
+	return document
+}
+
+// arrowTypeDocument returns the name and the parameters of a type, as in the Arrow integration JSON format
+func arrowTypeDocument(dataType arrow.DataType) map[string]interface{} {
+	switch t := dataType.(type) {
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Int64Type:
+		return map[string]interface{}{"name": "int", "bitWidth": t.(arrow.FixedWidthDataType).BitWidth(), "isSigned": true}
+	case *arrow.Uint8Type, *arrow.Uint16Type, *arrow.Uint32Type, *arrow.Uint64Type:
+		return map[string]interface{}{"name": "int", "bitWidth": t.(arrow.FixedWidthDataType).BitWidth(), "isSigned": false}
+	case *arrow.Float16Type:
+		return map[string]interface{}{"name": "floatingpoint", "precision": "HALF"}
+	case *arrow.Float32Type:
+		return map[string]interface{}{"name": "floatingpoint", "precision": "SINGLE"}
+	case *arrow.Float64Type:
+		return map[string]interface{}{"name": "floatingpoint", "precision": "DOUBLE"}
+	case *arrow.Decimal128Type:
+		return map[string]interface{}{"name": "decimal", "precision": t.Precision, "scale": t.Scale, "bitWidth": 128}
+	case *arrow.Decimal256Type:
+		return map[string]interface{}{"name": "decimal", "precision": t.Precision, "scale": t.Scale, "bitWidth": 256}
+	case *arrow.FixedSizeBinaryType:
+		return map[string]interface{}{"name": "fixedsizebinary", "byteWidth": t.ByteWidth}
+	case *arrow.Date32Type:
+		return map[string]interface{}{"name": "date", "unit": "DAY"}
+	case *arrow.Date64Type:
+		return map[string]interface{}{"name": "date", "unit": "MILLISECOND"}
+	case *arrow.Time32Type:
+		return map[string]interface{}{"name": "time", "unit": timeUnitName(t.Unit), "bitWidth": 32}
+	case *arrow.Time64Type:
+		return map[string]interface{}{"name": "time", "unit": timeUnitName(t.Unit), "bitWidth": 64}
+	case *arrow.TimestampType:
+		document := map[string]interface{}{"name": "timestamp", "unit": timeUnitName(t.Unit)}
+		if t.TimeZone != "" {
+			document["timezone"] = t.TimeZone
+		}
+		return document
+	case *arrow.DurationType:
+		return map[string]interface{}{"name": "duration", "unit": timeUnitName(t.Unit)}
+	case *arrow.FixedSizeListType:
+		return map[string]interface{}{"name": "fixedsizelist", "listSize": t.Len()}
+	case *arrow.MapType:
+		return map[string]interface{}{"name": "map", "keysSorted": t.KeysSorted}
+	default:
+		// the other types have no parameter: null, bool, utf8, largeutf8, binary, largebinary, list, largelist, struct
+		return map[string]interface{}{"name": dataType.Name()}
+	}
+}
+
+func timeUnitName(unit arrow.TimeUnit) string {
+	switch unit {
+	case arrow.Second:
+		return "SECOND"
+	case arrow.Millisecond:
+		return "MILLISECOND"
+	case arrow.Microsecond:
+		return "MICROSECOND"
+	default:
+		return "NANOSECOND"
+	}
+}
+
+func keyValues(md arrow.Metadata) []KeyValue {
+	var entries []KeyValue
+	for i, key := range md.Keys() {
+		entries = append(entries, KeyValue{Key: key, Value: md.Values()[i]})
+	}
+	return entries
+}
+
+// JSONSchemaNode is a JSON Schema describing a row or a value, as written by the NDJSON format of db2parquet.
+// Type is a string, or a list ending with "null" for the nullable fields.
+type JSONSchemaNode struct {
+	Schema          string          `json:"$schema,omitempty"`
+	Title           string          `json:"title,omitempty"`
+	Description     string          `json:"description,omitempty"`
+	Type            interface{}     `json:"type,omitempty"`
+	Format          string          `json:"format,omitempty"`
+	ContentEncoding string          `json:"contentEncoding,omitempty"`
+	Pattern         string          `json:"pattern,omitempty"`
+	Minimum         *float64        `json:"minimum,omitempty"`
+	Maximum         *float64        `json:"maximum,omitempty"`
+	MinItems        *int            `json:"minItems,omitempty"`
+	MaxItems        *int            `json:"maxItems,omitempty"`
+	Items           *JSONSchemaNode `json:"items,omitempty"`
+	Properties      *JSONProperties `json:"properties,omitempty"`
+	Required        []string        `json:"required,omitempty"`
+	Additional      *bool           `json:"additionalProperties,omitempty"`
+	PgType          string          `json:"x-pg-type,omitempty"`
+	ArrowType       string          `json:"x-arrow-type,omitempty"`
+}
+
+// JSONProperties are the properties of an object, marshalled in the order of the columns
+type JSONProperties struct {
+	Names  []string
+	Values []JSONSchemaNode
+}
+
+// MarshalJSON writes the properties in their order
+func (p *JSONProperties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range p.Names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(p.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// JSONSchema returns the JSON Schema of the rows of the schema, the nullable columns accept null and the
+// others are required. The comments and the original types stored by db2parquet are kept as description and x-pg-type.
+func JSONSchema(title string, schema *arrow.Schema) JSONSchemaNode {
+	root := objectSchema(schema.Fields())
+	root.Schema, root.Title = jsonSchemaDialect, title
+	if comment, found := schema.Metadata().GetValue(db2parquet.MetadataKeyTableComment); found {
+		root.Description = comment
+	}
+	return root
+}
+
+func objectSchema(fields []arrow.Field) JSONSchemaNode {
+	additional := false
+	node := JSONSchemaNode{Type: "object", Properties: &JSONProperties{}, Additional: &additional}
+	for _, field := range fields {
+		node.Properties.Names = append(node.Properties.Names, field.Name)
+		node.Properties.Values = append(node.Properties.Values, fieldSchema(field))
+		if !field.Nullable {
+			node.Required = append(node.Required, field.Name)
+		}
+	}
+	return node
+}
+
+func fieldSchema(field arrow.Field) JSONSchemaNode {
+	node := valueSchema(field.Type)
+	if comment, found := field.Metadata.GetValue(db2arrow.MetadataKeyComment); found {
+		node.Description = comment
+	}
+	if pgType, err := db2arrow.MapArrowField(field); err == nil {
+		node.PgType = pgType
+	}
+	if field.Nullable {
+		node.Type = []string{node.Type.(string), "null"}
+	}
+	return node
+}
+
+// valueSchema returns the schema of the JSON values of a type, in the form used by Arrow to marshal them
+func valueSchema(dataType arrow.DataType) JSONSchemaNode {
+	node := JSONSchemaNode{ArrowType: dataType.String()}
+	switch t := dataType.(type) {
+	case *arrow.BooleanType:
+		node.Type = "boolean"
+	case *arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Uint8Type, *arrow.Uint16Type, *arrow.Uint32Type:
+		node.Type = "integer"
+		bits := t.(arrow.FixedWidthDataType).BitWidth()
+		low, high := -math.Ldexp(1, bits-1), math.Ldexp(1, bits-1)-1
+		if strings.HasPrefix(t.Name(), "uint") {
+			low, high = 0, math.Ldexp(1, bits)-1
+		}
+		node.Minimum, node.Maximum = &low, &high
+	case *arrow.Int64Type, *arrow.Uint64Type:
+		node.Type = "integer"
+	case *arrow.Float16Type, *arrow.Float32Type, *arrow.Float64Type:
+		node.Type = "number"
+	case *arrow.Decimal128Type, *arrow.Decimal256Type:
+		// the decimals are marshalled as strings to keep their precision
+		node.Type, node.Pattern = "string", `^-?[0-9]+(\.[0-9]+)?$`
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		node.Type = "string"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.BinaryViewType, *arrow.FixedSizeBinaryType:
+		node.Type, node.ContentEncoding = "string", "base64"
+	case *arrow.Date32Type, *arrow.Date64Type:
+		node.Type, node.Format = "string", "date"
+	case *arrow.TimestampType, *arrow.Time32Type, *arrow.Time64Type, *arrow.DurationType:
+		node.Type = "string"
+	case *arrow.MapType:
+		// a map is marshalled as the list of its key and value entries
+		node.Type = "array"
+		items := objectSchema([]arrow.Field{t.KeyField(), t.ItemField()})
+		node.Items = &items
+	case arrow.ListLikeType:
+		node.Type = "array"
+		items := fieldSchema(t.ElemField())
+		node.Items = &items
+		if fixed, ok := t.(*arrow.FixedSizeListType); ok {
+			size := int(fixed.Len())
+			node.MinItems, node.MaxItems = &size, &size
+		}
+	case *arrow.StructType:
+		object := objectSchema(t.Fields())
+		object.ArrowType = node.ArrowType
+		return object
+	case *arrow.DictionaryType:
+		return valueSchema(t.ValueType)
+	case *arrow.NullType:
+		node.Type = "null"
+	default:
+		node.Type = "string"
+	}
+	return node
+}
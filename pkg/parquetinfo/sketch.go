@@ -0,0 +1,148 @@
+package parquetinfo
+
+import (
+	"container/heap"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+const (
+	// hllPrecision is the number of bits of the hash selecting a register, the standard error is 1.04/sqrt(2^14) ≈ 0.8%
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+)
+
+// hyperLogLog estimates the number of distinct hashes added
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	index := hash >> (64 - hllPrecision)
+	// the sentinel bit bounds the rank when the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// estimate returns the HyperLogLog estimate, with the linear counting correction of the small cardinalities
+func (h *hyperLogLog) estimate() uint64 {
+	const m = float64(hllRegisters)
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// mix64 is the finalizer of SplitMix64, it spreads the bits of the integers and of the FNV hashes
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// hashString returns the FNV-1a hash of s mixed by mix64
+func hashString(s string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint64(s[i])
+		hash *= 1099511628211
+	}
+	return mix64(hash)
+}
+
+// spaceSaving tracks the most frequent values in a bounded memory with the Space-Saving algorithm:
+// a new value replaces the least frequent one once capacity values are tracked, and inherits its count.
+// The counts are exact as long as no value was replaced, overestimated by at most the replaced count otherwise.
+type spaceSaving struct {
+	capacity int
+	entries  map[string]*frequentValue
+	heap     frequentHeap
+	replaced bool
+}
+
+type frequentValue struct {
+	value string
+	count int64
+	index int
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, entries: make(map[string]*frequentValue, capacity)}
+}
+
+// add counts the value, it is cloned when tracked as it usually references the buffer of an Arrow array
+func (s *spaceSaving) add(value string) {
+	if entry, found := s.entries[value]; found {
+		entry.count++
+		heap.Fix(&s.heap, entry.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		entry := &frequentValue{value: strings.Clone(value), count: 1}
+		s.entries[entry.value] = entry
+		heap.Push(&s.heap, entry)
+		return
+	}
+	least := s.heap[0]
+	delete(s.entries, least.value)
+	least.value = strings.Clone(value)
+	least.count++
+	s.entries[least.value] = least
+	heap.Fix(&s.heap, 0)
+	s.replaced = true
+}
+
+// top returns the k most frequent values, by decreasing count then by value
+func (s *spaceSaving) top(k int) []ValueCount {
+	values := make([]ValueCount, 0, len(s.heap))
+	for _, entry := range s.heap {
+		values = append(values, ValueCount{Value: entry.value, Count: entry.count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	return values[:min(k, len(values))]
+}
+
+// frequentHeap is a min-heap of the tracked values by count
+type frequentHeap []*frequentValue
+
+func (h frequentHeap) Len() int           { return len(h) }
+func (h frequentHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h frequentHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *frequentHeap) Push(x interface{}) {
+	entry := x.(*frequentValue)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *frequentHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
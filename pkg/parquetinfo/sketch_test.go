@@ -0,0 +1,50 @@
+package parquetinfo
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, distinct := range []int{0, 1, 100, 10_000, 1_000_000} {
+		t.Run(strconv.Itoa(distinct), func(t *testing.T) {
+			var h hyperLogLog
+			// every value twice, the duplicates must not change the estimate
+			for pass := 0; pass < 2; pass++ {
+				for i := 0; i < distinct; i++ {
+					h.add(hashString("value-" + strconv.Itoa(i)))
+				}
+			}
+			got := float64(h.estimate())
+			// 4 times the standard error of 0.8%, and one value for the small cardinalities
+			if diff := math.Abs(got - float64(distinct)); diff > max(0.032*float64(distinct), 1) {
+				t.Errorf("estimate() = %.0f for %d distinct values", got, distinct)
+			}
+		})
+	}
+}
+
+func TestSpaceSavingTop(t *testing.T) {
+	s := newSpaceSaving(3)
+	for _, value := range []string{"a", "b", "a", "c", "a", "b"} {
+		s.add(value)
+	}
+	got := s.top(2)
+	want := []ValueCount{{Value: "a", Count: 3}, {Value: "b", Count: 2}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("top(2) = %v, want %v", got, want)
+	}
+	if s.replaced {
+		t.Errorf("replaced is set with fewer values than the capacity")
+	}
+
+	// a new value takes the place of the least frequent one and inherits its count
+	s.add("d")
+	if !s.replaced {
+		t.Errorf("replaced is not set after a value was evicted")
+	}
+	if got := s.top(3); got[0] != (ValueCount{Value: "a", Count: 3}) || got[1] != (ValueCount{Value: "b", Count: 2}) || got[2] != (ValueCount{Value: "d", Count: 2}) {
+		t.Errorf("top(3) after the eviction = %v", got)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build duckdb_arrow && cgo
+
+package parquetquery
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/duckdb/duckdb-go/v2"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+// Query runs the SQL in an in-memory DuckDB database where the view Options.Table reads the parquet files,
+// the queries cannot access other files. The caller must Close the result.
+func Query(ctx context.Context, files []string, query string, options Options, log golog.MyLogger) (*Result, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no parquet file to query")
+	}
+	if options.Table == "" {
+		options.Table = DefaultTable
+	}
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open duckdb: %w", err)
+	}
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		connector.Close()
+		return nil, fmt.Errorf("failed to connect to duckdb: %w", err)
+	}
+	closeDatabase := func() error {
+		return errors.Join(conn.Close(), connector.Close())
+	}
+	execer := conn.(driver.ExecerContext)
+	statements := append(settingsStatements(options), viewStatement(options.Table, files))
+	for _, statement := range append(statements, lockStatements(files)...) {
+		if _, err := execer.ExecContext(ctx, statement, nil); err != nil {
+			closeDatabase()
+			return nil, fmt.Errorf("failed to prepare the view %s of %d parquet files: %w", options.Table, len(files), err)
+		}
+	}
+	arrowConn, err := duckdb.NewArrowFromConn(conn)
+	if err != nil {
+		closeDatabase()
+		return nil, fmt.Errorf("failed to open the arrow interface of duckdb: %w", err)
+	}
+	log.Debug("running the query over the view %s of %d parquet files", options.Table, len(files))
+	reader, err := arrowConn.QueryContext(ctx, query)
+	if err != nil {
+		closeDatabase()
+		return nil, fmt.Errorf("failed to run the query: %w", err)
+	}
+	return &Result{RecordReader: reader, maxRows: options.MaxRows, close: closeDatabase}, nil
+}
@@ -0,0 +1,161 @@
+// Package parquetquery runs SQL over the parquet files exported by this project with an embedded DuckDB,
+// the results are returned as Arrow records. DuckDB needs cgo and the duckdb_arrow build tag, the binaries
+// built without them return ErrUnavailable.
+package parquetquery
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
+)
+
+// DefaultTable is the name of the view over the parquet files used in the queries
+const DefaultTable = "parquet"
+
+// ErrUnavailable is returned by Query in the binaries built without DuckDB
+var ErrUnavailable = errors.New("parquet queries need a binary built with cgo and the duckdb_arrow build tag")
+
+// Options are the settings of the DuckDB database of a query, the zero values keep the DuckDB defaults
+type Options struct {
+	// Table is the name of the view reading all the files, DefaultTable when empty
+	Table string
+	// Threads is the number of threads used by DuckDB
+	Threads int
+	// MemoryLimit is the maximum memory used by DuckDB, like 512MB or 4GB
+	MemoryLimit string
+	// MaxRows stops the results after this number of rows, 0 returns all the rows
+	MaxRows int64
+}
+
+// Result is the stream of the records of a query, Close releases the records and the database
+type Result struct {
+	array.RecordReader
+	rows    int64
+	maxRows int64
+	record  arrow.Record
+	close   func() error
+}
+
+// Next moves to the next record, the last one is sliced to the MaxRows of the query
+func (r *Result) Next() bool {
+	if r.record != nil {
+		r.record.Release()
+		r.record = nil
+	}
+	if r.maxRows > 0 && r.rows >= r.maxRows {
+		return false
+	}
+	if !r.RecordReader.Next() {
+		return false
+	}
+	record := r.RecordReader.Record()
+	if r.maxRows > 0 && r.rows+record.NumRows() > r.maxRows {
+		r.record = record.NewSlice(0, r.maxRows-r.rows)
+	} else {
+		record.Retain()
+		r.record = record
+	}
+	r.rows += r.record.NumRows()
+	return true
+}
+
+// Record returns the current record, it stays valid until the next call to Next
+func (r *Result) Record() arrow.Record {
+	return r.record
+}
+
+// RecordBatch returns the current record, it stays valid until the next call to Next
+func (r *Result) RecordBatch() arrow.RecordBatch {
+	return r.record
+}
+
+// Rows returns the number of rows returned so far
+func (r *Result) Rows() int64 {
+	return r.rows
+}
+
+// Close releases the records and closes the database of the query
+func (r *Result) Close() error {
+	if r.record != nil {
+		r.record.Release()
+		r.record = nil
+	}
+	r.RecordReader.Release()
+	return r.close()
+}
+
+// ResolveFiles returns the absolute paths of the parquet files of the locations, each one is a file, a local
+// directory searched recursively or a glob pattern
+func ResolveFiles(locations []string) ([]string, error) {
+	var files []string
+	for _, location := range locations {
+		found := []string{location}
+		if parquetinfo.IsDataset(location) {
+			var err error
+			if _, found, err = parquetinfo.ListDatasetFiles(location); err != nil {
+				return nil, err
+			}
+			if len(found) == 0 {
+				return nil, fmt.Errorf("no parquet file found in %s", location)
+			}
+		}
+		for _, file := range found {
+			path, err := filepath.Abs(file)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// settingsStatements returns the statements applying the options, run before the view is created
+func settingsStatements(options Options) []string {
+	var statements []string
+	if options.Threads > 0 {
+		statements = append(statements, fmt.Sprintf("SET threads = %d", options.Threads))
+	}
+	if options.MemoryLimit != "" {
+		statements = append(statements, fmt.Sprintf("SET memory_limit = %s", quoteLiteral(options.MemoryLimit)))
+	}
+	return statements
+}
+
+// viewStatement returns the statement creating the view over the files, the columns missing in some files are
+// NULL and the Hive partition directories like year=2024 become columns
+func viewStatement(table string, files []string) string {
+	return fmt.Sprintf("CREATE VIEW %s AS SELECT * FROM read_parquet(%s, union_by_name = true)", quoteIdentifier(table), quoteList(files))
+}
+
+// lockStatements returns the statements restricting the file system access of the queries to the files of the view,
+// the configuration is locked so that the queries cannot read other files, write files or load extensions
+func lockStatements(files []string) []string {
+	return []string{
+		fmt.Sprintf("SET allowed_paths = %s", quoteList(files)),
+		"SET enable_external_access = false",
+		"SET autoload_known_extensions = false",
+		"SET lock_configuration = true",
+	}
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = quoteLiteral(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
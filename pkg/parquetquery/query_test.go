@@ -0,0 +1,145 @@
+package parquetquery
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		quote func(string) string
+		value string
+		want  string
+	}{
+		{"literal", quoteLiteral, "/data/export.parquet", `'/data/export.parquet'`},
+		{"literal with quote", quoteLiteral, "/data/o'brien/export.parquet", `'/data/o''brien/export.parquet'`},
+		{"literal closing the string", quoteLiteral, "x'); COPY t TO '/tmp/out", `'x''); COPY t TO ''/tmp/out'`},
+		{"literal memory limit", quoteLiteral, "4GB", `'4GB'`},
+		{"identifier", quoteIdentifier, "parquet", `"parquet"`},
+		{"identifier with quote", quoteIdentifier, `my "table"`, `"my ""table"""`},
+		{"identifier with single quote", quoteIdentifier, "o'brien", `"o'brien"`},
+	}
+	for _, tt := range tests {
+		if got := tt.quote(tt.value); got != tt.want {
+			t.Errorf("%s: quote(%q) = %s, want %s", tt.name, tt.value, got, tt.want)
+		}
+	}
+	if got, want := quoteList([]string{"/data/a.parquet", "/data/it's.parquet"}), `['/data/a.parquet', '/data/it''s.parquet']`; got != want {
+		t.Errorf("quoteList() = %s, want %s", got, want)
+	}
+	if got, want := quoteList(nil), "[]"; got != want {
+		t.Errorf("quoteList(nil) = %s, want %s", got, want)
+	}
+}
+
+func TestResolveFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"single.parquet", "dataset/year=2024/part-0.parquet", "dataset/year=2025/part-0.parquet", "dataset/_SUCCESS"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("PAR1"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// a relative location is made absolute
+	t.Chdir(root)
+
+	files, err := ResolveFiles([]string{"single.parquet", filepath.Join(root, "dataset")})
+	if err != nil {
+		t.Fatalf("ResolveFiles() error: %v", err)
+	}
+	want := []string{
+		filepath.Join(root, "single.parquet"),
+		filepath.Join(root, "dataset/year=2024/part-0.parquet"),
+		filepath.Join(root, "dataset/year=2025/part-0.parquet"),
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("ResolveFiles() = %v, want %v", files, want)
+	}
+	if _, err := ResolveFiles([]string{filepath.Join(root, "empty")}); err == nil {
+		t.Errorf("ResolveFiles() of a directory without parquet file succeeded")
+	}
+}
+
+// newTestResult returns a Result over records of the given numbers of rows, with ids counting from 0
+func newTestResult(t *testing.T, mem memory.Allocator, sizes []int, maxRows int64) *Result {
+	t.Helper()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	builder := array.NewInt64Builder(mem)
+	defer builder.Release()
+	var records []arrow.Record
+	id := int64(0)
+	for _, size := range sizes {
+		for i := 0; i < size; i++ {
+			builder.Append(id)
+			id++
+		}
+		column := builder.NewArray()
+		records = append(records, array.NewRecord(schema, []arrow.Array{column}, int64(size)))
+		column.Release()
+	}
+	reader, err := array.NewRecordReader(schema, records)
+	if err != nil {
+		t.Fatalf("NewRecordReader() error: %v", err)
+	}
+	for _, record := range records {
+		record.Release()
+	}
+	return &Result{RecordReader: reader, maxRows: maxRows, close: func() error { return nil }}
+}
+
+func TestResultMaxRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		sizes   []int
+		maxRows int64
+		want    []int64
+	}{
+		{"all the rows", []int{3, 4, 5}, 0, []int64{3, 4, 5}},
+		{"inside the second record", []int{3, 4, 5}, 5, []int64{3, 2}},
+		{"end of a record", []int{3, 4, 5}, 7, []int64{3, 4}},
+		{"inside the first record", []int{3, 4, 5}, 2, []int64{2}},
+		{"more than the rows", []int{3, 4}, 100, []int64{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+			defer mem.AssertSize(t, 0)
+			result := newTestResult(t, mem, tt.sizes, tt.maxRows)
+			var got []int64
+			var nextId int64
+			for result.Next() {
+				record := result.Record()
+				got = append(got, record.NumRows())
+				ids := record.Column(0).(*array.Int64)
+				for i := 0; i < ids.Len(); i++ {
+					if ids.Value(i) != nextId {
+						t.Fatalf("row %d has id %d", nextId, ids.Value(i))
+					}
+					nextId++
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("records of %v rows, want %v", got, tt.want)
+			}
+			if result.Rows() != nextId {
+				t.Errorf("Rows() = %d, want %d", result.Rows(), nextId)
+			}
+			if err := result.Close(); err != nil {
+				t.Errorf("Close() error: %v", err)
+			}
+		})
+	}
+}
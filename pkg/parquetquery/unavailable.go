@@ -0,0 +1,14 @@
+//go:build !duckdb_arrow || !cgo
+
+package parquetquery
+
+import (
+	"context"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+// Query returns ErrUnavailable, DuckDB is only linked in the binaries built with cgo and the duckdb_arrow build tag
+func Query(ctx context.Context, files []string, query string, options Options, log golog.MyLogger) (*Result, error) {
+	return nil, ErrUnavailable
+}
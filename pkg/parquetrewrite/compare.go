@@ -0,0 +1,143 @@
+package parquetrewrite
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// SortKey is a column of the sort order of the rewritten rows, the NULL values come last in both directions
+type SortKey struct {
+	Column     string
+	Descending bool
+}
+
+// ParseSortKeys parses a comma separated list of columns, each one optionally followed by :asc or :desc
+func ParseSortKeys(list string) ([]SortKey, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var keys []SortKey
+	for _, item := range strings.Split(list, ",") {
+		column, direction, _ := strings.Cut(strings.TrimSpace(item), ":")
+		key := SortKey{Column: column}
+		switch strings.ToLower(direction) {
+		case "", "asc":
+		case "desc":
+			key.Descending = true
+		default:
+			return nil, fmt.Errorf("invalid sort direction %s of column %s, expected asc or desc", direction, column)
+		}
+		if key.Column == "" {
+			return nil, fmt.Errorf("invalid sort key %q", item)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sortable returns true for the types compared by compareValues
+func sortable(t arrow.DataType) bool {
+	switch t.ID() {
+	case arrow.BOOL, arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64, arrow.UINT8, arrow.UINT16, arrow.UINT32, arrow.UINT64,
+		arrow.FLOAT32, arrow.FLOAT64, arrow.STRING, arrow.LARGE_STRING, arrow.BINARY, arrow.LARGE_BINARY, arrow.FIXED_SIZE_BINARY,
+		arrow.DATE32, arrow.DATE64, arrow.TIMESTAMP, arrow.TIME32, arrow.TIME64, arrow.DECIMAL128, arrow.DECIMAL256:
+		return true
+	}
+	return false
+}
+
+// sortColumn is a sort key resolved to the position of its column in the records
+type sortColumn struct {
+	position   int
+	descending bool
+}
+
+// compareRows compares the row i of a with the row j of b on the sort columns
+func compareRows(a arrow.Record, i int, b arrow.Record, j int, columns []sortColumn) int {
+	for _, c := range columns {
+		x, y := a.Column(c.position), b.Column(c.position)
+		xNull, yNull := x.IsNull(i), y.IsNull(j)
+		switch {
+		case xNull && yNull:
+			continue
+		case xNull:
+			return 1
+		case yNull:
+			return -1
+		}
+		if result := compareValues(x, i, y, j); result != 0 {
+			if c.descending {
+				return -result
+			}
+			return result
+		}
+	}
+	return 0
+}
+
+// compareValues compares two non-null values of arrays of the same sortable type, NaN is less than the numbers
+func compareValues(x arrow.Array, i int, y arrow.Array, j int) int {
+	switch a := x.(type) {
+	case *array.Boolean:
+		b := y.(*array.Boolean)
+		switch {
+		case a.Value(i) == b.Value(j):
+			return 0
+		case !a.Value(i):
+			return -1
+		default:
+			return 1
+		}
+	case *array.Int8:
+		return cmp.Compare(a.Value(i), y.(*array.Int8).Value(j))
+	case *array.Int16:
+		return cmp.Compare(a.Value(i), y.(*array.Int16).Value(j))
+	case *array.Int32:
+		return cmp.Compare(a.Value(i), y.(*array.Int32).Value(j))
+	case *array.Int64:
+		return cmp.Compare(a.Value(i), y.(*array.Int64).Value(j))
+	case *array.Uint8:
+		return cmp.Compare(a.Value(i), y.(*array.Uint8).Value(j))
+	case *array.Uint16:
+		return cmp.Compare(a.Value(i), y.(*array.Uint16).Value(j))
+	case *array.Uint32:
+		return cmp.Compare(a.Value(i), y.(*array.Uint32).Value(j))
+	case *array.Uint64:
+		return cmp.Compare(a.Value(i), y.(*array.Uint64).Value(j))
+	case *array.Float32:
+		return cmp.Compare(a.Value(i), y.(*array.Float32).Value(j))
+	case *array.Float64:
+		return cmp.Compare(a.Value(i), y.(*array.Float64).Value(j))
+	case *array.String:
+		return strings.Compare(a.Value(i), y.(*array.String).Value(j))
+	case *array.LargeString:
+		return strings.Compare(a.Value(i), y.(*array.LargeString).Value(j))
+	case *array.Binary:
+		return bytes.Compare(a.Value(i), y.(*array.Binary).Value(j))
+	case *array.LargeBinary:
+		return bytes.Compare(a.Value(i), y.(*array.LargeBinary).Value(j))
+	case *array.FixedSizeBinary:
+		return bytes.Compare(a.Value(i), y.(*array.FixedSizeBinary).Value(j))
+	case *array.Date32:
+		return cmp.Compare(a.Value(i), y.(*array.Date32).Value(j))
+	case *array.Date64:
+		return cmp.Compare(a.Value(i), y.(*array.Date64).Value(j))
+	case *array.Timestamp:
+		return cmp.Compare(a.Value(i), y.(*array.Timestamp).Value(j))
+	case *array.Time32:
+		return cmp.Compare(a.Value(i), y.(*array.Time32).Value(j))
+	case *array.Time64:
+		return cmp.Compare(a.Value(i), y.(*array.Time64).Value(j))
+	case *array.Decimal128:
+		return a.Value(i).Cmp(y.(*array.Decimal128).Value(j))
+	case *array.Decimal256:
+		return a.Value(i).Cmp(y.(*array.Decimal256).Value(j))
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,138 @@
+package parquetrewrite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+)
+
+// partitionColumn is a key of the Hive partition directories of the inputs, the files of a partitioned
+// dataset do not hold it so it is added back as a column of the output
+type partitionColumn struct {
+	field arrow.Field
+	// values holds the value of each input, nil for the default partition
+	values []*string
+}
+
+// inputPartitions returns the key=value directories between the dataset root and the inputs as columns,
+// in path order. Every input must be in a partition of the same keys, the type of a column is int64 when
+// all its values are integers and string otherwise.
+func inputPartitions(inputs []string, root string) ([]partitionColumn, error) {
+	if root == "" {
+		return nil, nil
+	}
+	var columns []partitionColumn
+	var firstPath string
+	for i, input := range inputs {
+		relPath, err := filepath.Rel(root, input)
+		if err != nil {
+			return nil, fmt.Errorf("input %s is not in the dataset %s: %w", input, root, err)
+		}
+		partitionPath, values := parquetinfo.HivePartition(relPath)
+		var keys []string
+		if partitionPath != "" {
+			keys = parquetinfo.PartitionKeys(partitionPath)
+		}
+		if i == 0 {
+			firstPath = partitionPath
+			for _, key := range keys {
+				columns = append(columns, partitionColumn{field: arrow.Field{Name: key, Nullable: true}})
+			}
+		} else if !slices.EqualFunc(keys, columns, func(key string, c partitionColumn) bool { return key == c.field.Name }) {
+			return nil, fmt.Errorf("input %s is in the partition %q, the first input is in %q", input, partitionPath, firstPath)
+		}
+		for j := range columns {
+			columns[j].values = append(columns[j].values, values[columns[j].field.Name])
+		}
+	}
+	for i := range columns {
+		columns[i].field.Type = partitionType(columns[i].values)
+	}
+	return columns, nil
+}
+
+// partitionType returns int64 when every value is an integer, string otherwise
+func partitionType(values []*string) arrow.DataType {
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		if _, err := strconv.ParseInt(*value, 10, 64); err != nil {
+			return arrow.BinaryTypes.String
+		}
+	}
+	return arrow.PrimitiveTypes.Int64
+}
+
+// array returns a column of numRows rows holding the partition value of the input
+func (c partitionColumn) array(input int, numRows int, mem memory.Allocator) arrow.Array {
+	value := c.values[input]
+	if value == nil {
+		return array.MakeArrayOfNull(mem, c.field.Type, numRows)
+	}
+	switch c.field.Type.ID() {
+	case arrow.INT64:
+		builder := array.NewInt64Builder(mem)
+		defer builder.Release()
+		n, _ := strconv.ParseInt(*value, 10, 64)
+		builder.Reserve(numRows)
+		for i := 0; i < numRows; i++ {
+			builder.UnsafeAppend(n)
+		}
+		return builder.NewArray()
+	default:
+		builder := array.NewStringBuilder(mem)
+		defer builder.Release()
+		builder.Reserve(numRows)
+		builder.ReserveData(numRows * len(*value))
+		for i := 0; i < numRows; i++ {
+			builder.Append(*value)
+		}
+		return builder.NewArray()
+	}
+}
+
+// checkOutput returns an error when the output is one of the inputs or a directory holding one,
+// even through a relative path or a parent directory
+func checkOutput(inputs []string, output string) error {
+	if sink.IsS3(output) {
+		for _, input := range inputs {
+			if input == output || strings.HasPrefix(input, strings.TrimSuffix(output, "/")+"/") {
+				return fmt.Errorf("the output %s cannot be or contain the input %s", output, input)
+			}
+		}
+		return nil
+	}
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return fmt.Errorf("invalid output %s: %w", output, err)
+	}
+	for _, input := range inputs {
+		if sink.IsS3(input) {
+			continue
+		}
+		absInput, err := filepath.Abs(input)
+		if err != nil {
+			return fmt.Errorf("invalid input %s: %w", input, err)
+		}
+		if isInside(absOutput, absInput) {
+			return fmt.Errorf("the output %s cannot be or contain the input %s", output, input)
+		}
+	}
+	return nil
+}
+
+// isInside returns true when the absolute path is the directory dir or below it
+func isInside(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
@@ -0,0 +1,521 @@
+// Package parquetrewrite rewrites parquet files and datasets with another layout: codec, row group size,
+// sort order, columns and dictionary encoding, merging the small files in fewer larger ones.
+package parquetrewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/recordsort"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/sink"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	// DefaultRowGroupRows is the number of rows of the row groups written
+	DefaultRowGroupRows = 1024 * 1024
+	// DefaultBatchRows is the number of rows of the records read from the inputs
+	DefaultBatchRows = 64 * 1024
+	// DefaultSortRunRows is the number of rows sorted in memory before they are spilled to a temporary file
+	DefaultSortRunRows = 1_000_000
+	// partFileNameFormat is the name of the files written in the output directory
+	partFileNameFormat = "part-%04d.parquet"
+	// arrowSchemaKey is the key-value metadata entry of the Arrow schema, written again by the parquet writer
+	arrowSchemaKey = "ARROW:schema"
+)
+
+// Options defines the layout of the rewritten files
+type Options struct {
+	// Compression is the codec of the pages, CompressionLevel its level, 0 for the default level of the codec
+	Compression      compress.Compression
+	CompressionLevel int
+	// RowGroupRows is the number of rows of the row groups, DefaultRowGroupRows when 0
+	RowGroupRows int64
+	// TargetFileSize writes the rows in files of about this number of bytes in the output directory,
+	// 0 writes one file at the output location
+	TargetFileSize int64
+	// SortBy sorts the rows on these columns, with an external merge sort of runs of SortRunRows rows
+	// spilled to TempDir, the inputs order is kept when empty
+	SortBy      []SortKey
+	SortRunRows int64
+	TempDir     string
+	// Columns lists the top-level columns kept in this order, all the columns when empty
+	Columns []string
+	// DatasetRoot is the directory the inputs were listed from, the key=value directories between it and
+	// the files are added as columns after the columns of the files, unless the files already hold them
+	DatasetRoot string
+	// NoDictionary disables the dictionary encoding, Dictionary enables or disables it for some column paths
+	NoDictionary bool
+	Dictionary   map[string]bool
+	// BatchRows is the number of rows read at once from the inputs, DefaultBatchRows when 0
+	BatchRows int64
+	// Memory is the allocator of the records, memory.DefaultAllocator when nil
+	Memory memory.Allocator
+}
+
+// Result summarizes a rewrite
+type Result struct {
+	InputFiles int
+	Rows       int64
+	RowGroups  int
+	// Files are the locations of the files written
+	Files []string
+	// Bytes is the total size of the files written
+	Bytes int64
+}
+
+// ParseCompression returns the parquet codec of the given name: none, snappy, gzip, brotli, zstd or lz4
+func ParseCompression(name string) (compress.Compression, error) {
+	switch strings.ToLower(name) {
+	case "", "none", "uncompressed":
+		return compress.Codecs.Uncompressed, nil
+	case "snappy":
+		return compress.Codecs.Snappy, nil
+	case "gzip":
+		return compress.Codecs.Gzip, nil
+	case "brotli":
+		return compress.Codecs.Brotli, nil
+	case "zstd":
+		return compress.Codecs.Zstd, nil
+	case "lz4":
+		return compress.Codecs.Lz4Raw, nil
+	default:
+		return 0, fmt.Errorf("invalid compression %s, expected one of none, snappy, gzip, brotli, zstd, lz4", name)
+	}
+}
+
+// Rewrite reads the rows of the input parquet files in order and writes them with the layout of the options.
+// The records are streamed from the inputs to the output, only one row group of the output, and with SortBy
+// one run of rows, are held in memory. The inputs must have the same columns and types, a column is nullable
+// in the output when it is in one of the inputs. The key-value metadata of the first input is kept.
+// The output cannot be an input or a directory holding one.
+func Rewrite(ctx context.Context, inputs []string, output string, options Options, log golog.MyLogger) (*Result, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("no input parquet file")
+	}
+	if options.RowGroupRows <= 0 {
+		options.RowGroupRows = DefaultRowGroupRows
+	}
+	if options.BatchRows <= 0 {
+		options.BatchRows = DefaultBatchRows
+	}
+	if options.SortRunRows <= 0 {
+		options.SortRunRows = DefaultSortRunRows
+	}
+	if options.Memory == nil {
+		options.Memory = memory.DefaultAllocator
+	}
+	if err := checkOutput(inputs, output); err != nil {
+		return nil, err
+	}
+	partitions, err := inputPartitions(inputs, options.DatasetRoot)
+	if err != nil {
+		return nil, err
+	}
+	if partitions, err = withoutFileColumns(ctx, inputs[0], partitions); err != nil {
+		return nil, err
+	}
+	fileColumns := options.Columns
+	if len(options.Columns) > 0 && len(partitions) > 0 {
+		fileColumns = slices.DeleteFunc(slices.Clone(options.Columns), func(name string) bool {
+			return slices.ContainsFunc(partitions, func(c partitionColumn) bool { return c.field.Name == name })
+		})
+		if len(fileColumns) == 0 {
+			return nil, errors.New("at least one column of the files must be kept besides the partition columns")
+		}
+	}
+	fileSchema, kvMetadata, err := inputSchema(ctx, inputs, fileColumns)
+	if err != nil {
+		return nil, err
+	}
+	schema := outputSchema(fileSchema, partitions, options.Columns)
+	if len(partitions) > 0 {
+		log.Info("the partition columns %s of the directories are added to the rows", strings.Join(partitionNames(partitions), ", "))
+	}
+	w := &rewriteWriter{ctx: ctx, output: output, schema: schema, kvMetadata: kvMetadata, options: options, log: log,
+		result: &Result{InputFiles: len(inputs)}}
+	defer w.abort()
+
+	write := w.write
+	var sorter *recordsort.Sorter
+	if len(options.SortBy) > 0 {
+		var columns []sortColumn
+		for _, key := range options.SortBy {
+			indices := schema.FieldIndices(key.Column)
+			if len(indices) == 0 {
+				return nil, fmt.Errorf("sort column %s is not one of the rewritten columns", key.Column)
+			}
+			if !sortable(schema.Field(indices[0]).Type) {
+				return nil, fmt.Errorf("sort column %s has the type %s that cannot be sorted", key.Column, schema.Field(indices[0]).Type)
+			}
+			columns = append(columns, sortColumn{position: indices[0], descending: key.Descending})
+		}
+		compare := func(a arrow.Record, i int, b arrow.Record, j int) (int, error) {
+			return compareRows(a, i, b, j, columns), nil
+		}
+		sorter = recordsort.New(ctx, schema, compare, recordsort.Options{RunRows: options.SortRunRows, BatchRows: options.BatchRows,
+			TempDir: options.TempDir, Allocator: options.Memory})
+		defer sorter.Close()
+		write = sorter.Add
+	}
+	for i, input := range inputs {
+		if err := readInput(ctx, i, input, schema, fileColumns, partitions, options, write); err != nil {
+			return nil, err
+		}
+	}
+	if sorter != nil {
+		log.Info("merging the sorted rows of %d runs", max(sorter.Runs(), 1))
+		if err := writeSorted(sorter, w.write); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.close(); err != nil {
+		return nil, err
+	}
+	return w.result, nil
+}
+
+// inputSchema returns the schema of the selected columns of the first input, with the fields nullable in any input,
+// and the key-value metadata of the first input without the Arrow schema
+func inputSchema(ctx context.Context, inputs []string, columns []string) (*arrow.Schema, map[string]string, error) {
+	var fields []arrow.Field
+	var schemaMetadata arrow.Metadata
+	kvMetadata := make(map[string]string)
+	for i, input := range inputs {
+		err := withReader(ctx, input, memory.DefaultAllocator, 0, func(pf *file.Reader, reader *pqarrow.FileReader) error {
+			schema, _, err := selectedSchema(reader, columns)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				fields, schemaMetadata = schema.Fields(), schema.Metadata()
+				if kv := pf.MetaData().KeyValueMetadata(); kv != nil {
+					for j, key := range kv.Keys() {
+						if key != arrowSchemaKey {
+							kvMetadata[key] = kv.Values()[j]
+						}
+					}
+				}
+				return nil
+			}
+			if err := checkSameColumns(fields, schema.Fields()); err != nil {
+				return fmt.Errorf("%w, first input %s", err, inputs[0])
+			}
+			for j, field := range schema.Fields() {
+				fields[j].Nullable = fields[j].Nullable || field.Nullable
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return arrow.NewSchema(fields, &schemaMetadata), kvMetadata, nil
+}
+
+// withoutFileColumns removes the partition columns that are already columns of the files
+func withoutFileColumns(ctx context.Context, input string, partitions []partitionColumn) ([]partitionColumn, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	err := withReader(ctx, input, memory.DefaultAllocator, 0, func(pf *file.Reader, reader *pqarrow.FileReader) error {
+		schema, err := reader.Schema()
+		if err != nil {
+			return fmt.Errorf("failed to read Arrow schema: %w", err)
+		}
+		partitions = slices.DeleteFunc(partitions, func(c partitionColumn) bool { return schema.HasField(c.field.Name) })
+		return nil
+	})
+	return partitions, err
+}
+
+// outputSchema returns the schema of the rewritten rows: the columns of the files followed by the partition
+// columns, or the listed columns in their order
+func outputSchema(fileSchema *arrow.Schema, partitions []partitionColumn, columns []string) *arrow.Schema {
+	if len(partitions) == 0 {
+		return fileSchema
+	}
+	metadata := fileSchema.Metadata()
+	if len(columns) == 0 {
+		fields := fileSchema.Fields()
+		for _, c := range partitions {
+			fields = append(fields, c.field)
+		}
+		return arrow.NewSchema(fields, &metadata)
+	}
+	fields := make([]arrow.Field, 0, len(columns))
+	for _, name := range columns {
+		if index := slices.IndexFunc(partitions, func(c partitionColumn) bool { return c.field.Name == name }); index >= 0 {
+			fields = append(fields, partitions[index].field)
+			continue
+		}
+		fields = append(fields, fileSchema.Field(fileSchema.FieldIndices(name)[0]))
+	}
+	return arrow.NewSchema(fields, &metadata)
+}
+
+func partitionNames(partitions []partitionColumn) []string {
+	names := make([]string, len(partitions))
+	for i, c := range partitions {
+		names[i] = c.field.Name
+	}
+	return names
+}
+
+// checkSameColumns returns an error when the fields have other names or types than the reference
+func checkSameColumns(reference, fields []arrow.Field) error {
+	if len(reference) != len(fields) {
+		return fmt.Errorf("%d columns instead of %d", len(fields), len(reference))
+	}
+	for i, field := range fields {
+		if field.Name != reference[i].Name || !arrow.TypeEqual(field.Type, reference[i].Type) {
+			return fmt.Errorf("column %d is %s %s instead of %s %s", i+1, field.Name, field.Type, reference[i].Name, reference[i].Type)
+		}
+	}
+	return nil
+}
+
+// withReader opens a parquet file with an Arrow reader and closes it after f
+func withReader(ctx context.Context, location string, mem memory.Allocator, batchRows int64, f func(pf *file.Reader, reader *pqarrow.FileReader) error) error {
+	r, err := sink.OpenReader(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	pf, err := file.NewParquetReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to open Parquet file %s: %w", location, err)
+	}
+	defer pf.Close()
+	reader, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: batchRows}, mem)
+	if err != nil {
+		return fmt.Errorf("failed to create Arrow reader for %s: %w", location, err)
+	}
+	if err := f(pf, reader); err != nil {
+		return fmt.Errorf("%s: %w", location, err)
+	}
+	return nil
+}
+
+// selectedSchema returns the schema of the selected top-level columns and their leaf columns, all when empty
+func selectedSchema(reader *pqarrow.FileReader, columns []string) (*arrow.Schema, []int, error) {
+	schema, err := reader.Schema()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read Arrow schema: %w", err)
+	}
+	if len(columns) == 0 {
+		return schema, nil, nil
+	}
+	indices := make([]int, len(columns))
+	fields := make([]arrow.Field, len(columns))
+	for i, name := range columns {
+		found := schema.FieldIndices(name)
+		if len(found) == 0 {
+			return nil, nil, fmt.Errorf("column %s not found", name)
+		}
+		indices[i], fields[i] = found[0], schema.Field(found[0])
+	}
+	leafColumns, err := reader.Manifest.GetFieldIndices(indices)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select columns: %w", err)
+	}
+	metadata := schema.Metadata()
+	return arrow.NewSchema(fields, &metadata), leafColumns, nil
+}
+
+// readInput reads the selected columns of the parquet file of index i and passes its records, with the output schema
+// and the values of its partition, to write
+func readInput(ctx context.Context, i int, input string, schema *arrow.Schema, fileColumns []string, partitions []partitionColumn,
+	options Options, write func(arrow.Record) error) error {
+	return withReader(ctx, input, options.Memory, options.BatchRows, func(pf *file.Reader, reader *pqarrow.FileReader) error {
+		_, leafColumns, err := selectedSchema(reader, fileColumns)
+		if err != nil {
+			return err
+		}
+		recordReader, err := reader.GetRecordReader(ctx, leafColumns, nil)
+		if err != nil {
+			return fmt.Errorf("failed to read records: %w", err)
+		}
+		defer recordReader.Release()
+		// the columns are read in the order of the file, they are put back in the order of the schema,
+		// a negative position -n is the partition column n-1
+		positions := make([]int, schema.NumFields())
+		for j, field := range schema.Fields() {
+			if found := recordReader.Schema().FieldIndices(field.Name); len(found) > 0 {
+				positions[j] = found[0]
+				continue
+			}
+			positions[j] = -1 - slices.IndexFunc(partitions, func(c partitionColumn) bool { return c.field.Name == field.Name })
+		}
+		for {
+			record, err := recordReader.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return fmt.Errorf("failed to read records: %w", err)
+			}
+			columns := make([]arrow.Array, len(positions))
+			var partitionArrays []arrow.Array
+			for j, pos := range positions {
+				if pos >= 0 {
+					columns[j] = record.Column(pos)
+					continue
+				}
+				columns[j] = partitions[-1-pos].array(i, int(record.NumRows()), options.Memory)
+				partitionArrays = append(partitionArrays, columns[j])
+			}
+			rec := array.NewRecord(schema, columns, record.NumRows())
+			err = write(rec)
+			rec.Release()
+			for _, arr := range partitionArrays {
+				arr.Release()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// rewriteWriter writes the records in row groups of RowGroupRows rows, in a new file of the output directory
+// each time the current one reaches TargetFileSize
+type rewriteWriter struct {
+	ctx        context.Context
+	output     string
+	schema     *arrow.Schema
+	kvMetadata map[string]string
+	options    Options
+	log        golog.MyLogger
+	result     *Result
+	out        sink.Sink
+	counter    *countingWriter
+	writer     *pqarrow.FileWriter
+	partNumber int
+}
+
+func (w *rewriteWriter) write(record arrow.Record) error {
+	if w.writer == nil {
+		if err := w.openFile(); err != nil {
+			return err
+		}
+	}
+	if err := w.writer.WriteBuffered(record); err != nil {
+		return fmt.Errorf("failed to write RecordBatch in %s: %w", w.out.Location(), err)
+	}
+	w.result.Rows += record.NumRows()
+	if w.options.TargetFileSize > 0 && w.counter.n+w.writer.RowGroupTotalCompressedBytes() >= w.options.TargetFileSize {
+		return w.closeFile()
+	}
+	return nil
+}
+
+func (w *rewriteWriter) openFile() error {
+	location := w.output
+	if w.options.TargetFileSize > 0 {
+		if err := sink.MkdirAll(w.output); err != nil {
+			return err
+		}
+		location = sink.Join(w.output, fmt.Sprintf(partFileNameFormat, w.partNumber))
+		w.partNumber++
+	}
+	out, err := sink.Open(w.ctx, location)
+	if err != nil {
+		return err
+	}
+	props := []parquet.WriterProperty{
+		parquet.WithAllocator(w.options.Memory),
+		parquet.WithCompression(w.options.Compression),
+		parquet.WithMaxRowGroupLength(w.options.RowGroupRows),
+		parquet.WithDictionaryDefault(!w.options.NoDictionary),
+	}
+	if w.options.CompressionLevel != 0 {
+		props = append(props, parquet.WithCompressionLevel(w.options.CompressionLevel))
+	}
+	for _, path := range slices.Sorted(maps.Keys(w.options.Dictionary)) {
+		props = append(props, parquet.WithDictionaryFor(path, w.options.Dictionary[path]))
+	}
+	w.out, w.counter = out, &countingWriter{w: out}
+	w.writer, err = pqarrow.NewFileWriter(w.schema, w.counter, parquet.NewWriterProperties(props...),
+		pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		out.Abort()
+		w.out = nil
+		return fmt.Errorf("failed to create Parquet writer for %s: %w", location, err)
+	}
+	w.log.Info("Parquet file %s created", location)
+	return nil
+}
+
+func (w *rewriteWriter) closeFile() error {
+	writer, out := w.writer, w.out
+	w.writer, w.out = nil, nil
+	for _, key := range slices.Sorted(maps.Keys(w.kvMetadata)) {
+		if err := writer.AppendKeyValueMetadata(key, w.kvMetadata[key]); err != nil {
+			writer.Close()
+			out.Abort()
+			return fmt.Errorf("failed to append key-value metadata %s: %w", key, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		out.Abort()
+		return fmt.Errorf("failed to close Parquet writer of %s: %w", out.Location(), err)
+	}
+	if err := out.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", out.Location(), err)
+	}
+	md, err := writer.FileMetadata()
+	if err == nil {
+		w.result.RowGroups += md.NumRowGroups()
+	}
+	w.result.Files = append(w.result.Files, out.Location())
+	w.result.Bytes += w.counter.n
+	w.log.Info("Parquet file %s written: %d bytes", out.Location(), w.counter.n)
+	return nil
+}
+
+// close closes the current file, a file with the schema and no row is written when there were no rows
+func (w *rewriteWriter) close() error {
+	if w.writer == nil && len(w.result.Files) == 0 {
+		if err := w.openFile(); err != nil {
+			return err
+		}
+	}
+	if w.writer == nil {
+		return nil
+	}
+	return w.closeFile()
+}
+
+// abort discards the file being written after an error
+func (w *rewriteWriter) abort() {
+	if w.writer != nil {
+		w.writer.Close()
+		w.out.Abort()
+		w.writer, w.out = nil, nil
+	}
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,146 @@
+package parquetrewrite
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/parquetinfo"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+func newTestLogger(t *testing.T) golog.MyLogger {
+	t.Helper()
+	l, err := golog.NewLogger("zap", golog.ErrorLevel, "test")
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	return l
+}
+
+// writeTestFile writes a parquet file with an id column holding the given values
+func writeTestFile(t *testing.T, location string, ids ...int64) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(location), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+	builder.Field(0).(*array.Int64Builder).AppendValues(ids, nil)
+	record := builder.NewRecord()
+	defer record.Release()
+	writer, err := pqarrow.NewFileWriter(schema, f, nil, pqarrow.DefaultWriterProps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewritePartitionedDataset(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "year=2023", "region=north", "part-0000.parquet"), 1, 2)
+	writeTestFile(t, filepath.Join(root, "year=2024", "region=south%2Feast", "part-0000.parquet"), 3)
+	writeTestFile(t, filepath.Join(root, "year=__HIVE_DEFAULT_PARTITION__", "region=north", "part-0000.parquet"), 4)
+	datasetRoot, inputs, err := parquetinfo.ListDatasetFiles(root)
+	if err != nil {
+		t.Fatalf("ListDatasetFiles() error: %v", err)
+	}
+	output := filepath.Join(t.TempDir(), "compacted.parquet")
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	options := Options{DatasetRoot: datasetRoot, Memory: mem}
+	if _, err := Rewrite(context.Background(), inputs, output, options, newTestLogger(t)); err != nil {
+		t.Fatalf("Rewrite() error: %v", err)
+	}
+
+	pf, err := file.OpenParquetFile(output, false)
+	if err != nil {
+		t.Fatalf("OpenParquetFile() error: %v", err)
+	}
+	defer pf.Close()
+	reader, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatalf("NewFileReader() error: %v", err)
+	}
+	table, err := reader.ReadTable(context.Background())
+	if err != nil {
+		t.Fatalf("ReadTable() error: %v", err)
+	}
+	defer table.Release()
+	schema := table.Schema()
+	if schema.NumFields() != 3 || schema.Field(1).Name != "year" || schema.Field(2).Name != "region" {
+		t.Fatalf("schema of the output: %s, want id, year and region", schema)
+	}
+	if !arrow.TypeEqual(schema.Field(1).Type, arrow.PrimitiveTypes.Int64) || !arrow.TypeEqual(schema.Field(2).Type, arrow.BinaryTypes.String) {
+		t.Errorf("partition columns of types %s and %s, want int64 and utf8", schema.Field(1).Type, schema.Field(2).Type)
+	}
+	// the files are listed in path order, the default partition sorts after the years
+	wantYears := []interface{}{int64(2023), int64(2023), int64(2024), nil}
+	wantRegions := []string{"north", "north", "south/east", "north"}
+	years := table.Column(1).Data().Chunk(0).(*array.Int64)
+	regions := table.Column(2).Data().Chunk(0).(*array.String)
+	for i := range wantYears {
+		var year interface{}
+		if years.IsValid(i) {
+			year = years.Value(i)
+		}
+		if year != wantYears[i] || regions.Value(i) != wantRegions[i] {
+			t.Errorf("row %d: year %v region %s, want %v %s", i, year, regions.Value(i), wantYears[i], wantRegions[i])
+		}
+	}
+}
+
+func TestRewriteMixedPartitions(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "year=2023", "part-0000.parquet"), 1)
+	writeTestFile(t, filepath.Join(root, "region=north", "part-0000.parquet"), 2)
+	datasetRoot, inputs, err := parquetinfo.ListDatasetFiles(root)
+	if err != nil {
+		t.Fatalf("ListDatasetFiles() error: %v", err)
+	}
+	output := filepath.Join(t.TempDir(), "compacted.parquet")
+	if _, err := Rewrite(context.Background(), inputs, output, Options{DatasetRoot: datasetRoot}, newTestLogger(t)); err == nil {
+		t.Errorf("Rewrite() of inputs partitioned by other keys succeeded")
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "data", "year=2024", "part-0000.parquet")
+	tests := []struct {
+		name    string
+		output  string
+		wantErr bool
+	}{
+		{"the input", input, true},
+		{"the input by a relative path", filepath.Join(dir, "data", "..", "data", "year=2024", "part-0000.parquet"), true},
+		{"the directory of the input", filepath.Join(dir, "data", "year=2024"), true},
+		{"a parent directory of the input", filepath.Join(dir, "data"), true},
+		{"a sibling directory", filepath.Join(dir, "data-compacted"), false},
+		{"a file next to the input", filepath.Join(dir, "data", "year=2024", "compacted.parquet"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkOutput([]string{input}, tt.output); (err != nil) != tt.wantErr {
+				t.Errorf("checkOutput(%s) error = %v, want an error: %v", tt.output, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package parquetrewrite
+
+import (
+	"errors"
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/lao-tseu-is-alive/ArrowFlightPg/pkg/recordsort"
+)
+
+// writeSorted merges the runs of the sorter and writes the sorted records
+func writeSorted(sorter *recordsort.Sorter, write func(arrow.Record) error) error {
+	it, err := sorter.Sort()
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := write(record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,420 @@
+// Package recordsort sorts Arrow records with more rows than fit in memory, the rows are sorted in runs
+// that are spilled to Arrow IPC stream files and merged when the sorted rows are read.
+package recordsort
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/compute"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+const (
+	// DefaultRunRows is the number of rows sorted in memory before they are spilled to a run file
+	DefaultRunRows = 500_000
+	// DefaultBatchRows is the number of rows of the records of the run files and of the sorted records,
+	// only one of them is held in memory for each run while the runs are merged
+	DefaultBatchRows = 16 * 1024
+)
+
+// CompareFunc compares the row i of a with the row j of b, the first error stops the sort
+type CompareFunc func(a arrow.Record, i int, b arrow.Record, j int) (int, error)
+
+// Options defines the size of the runs and where they are spilled
+type Options struct {
+	// RunRows is the number of rows sorted in memory, default DefaultRunRows
+	RunRows int64
+	// BatchRows is the maximum number of rows of the sorted records, default DefaultBatchRows
+	BatchRows int64
+	// TempDir is the directory of the run files, default os.TempDir()
+	TempDir string
+	// Allocator allocates the buffers of the sorted records, default memory.DefaultAllocator
+	Allocator memory.Allocator
+}
+
+// Sorter sorts the records in runs of RunRows rows, each run is sorted in memory and written in an
+// Arrow IPC stream file of TempDir when there is more than one, the runs are merged by the Iterator.
+// The sort is stable, the rows comparing equal keep the order in which they were added.
+type Sorter struct {
+	ctx         context.Context
+	schema      *arrow.Schema
+	compare     CompareFunc
+	options     Options
+	pending     []arrow.Record
+	pendingRows int64
+	runFiles    []string
+}
+
+// New returns a Sorter of the records of the schema ordered by compare, it must be closed to remove the run files
+func New(ctx context.Context, schema *arrow.Schema, compare CompareFunc, options Options) *Sorter {
+	if options.RunRows <= 0 {
+		options.RunRows = DefaultRunRows
+	}
+	if options.BatchRows <= 0 {
+		options.BatchRows = DefaultBatchRows
+	}
+	if options.Allocator == nil {
+		options.Allocator = memory.DefaultAllocator
+	}
+	return &Sorter{ctx: ctx, schema: schema, compare: compare, options: options}
+}
+
+// Add keeps the record until the run is full, then the run is sorted and spilled
+func (s *Sorter) Add(record arrow.Record) error {
+	record.Retain()
+	s.pending = append(s.pending, record)
+	s.pendingRows += record.NumRows()
+	if s.pendingRows >= s.options.RunRows {
+		return s.spill()
+	}
+	return nil
+}
+
+// Runs returns the number of runs spilled to files
+func (s *Sorter) Runs() int {
+	return len(s.runFiles)
+}
+
+// Sort sorts the rows added and returns an Iterator over them, no record can be added after
+func (s *Sorter) Sort() (*Iterator, error) {
+	if len(s.runFiles) == 0 {
+		// everything fits in one run, it is read from memory
+		sorted, err := s.sortPending()
+		if err != nil {
+			return nil, err
+		}
+		return &Iterator{sorter: s, sorted: sorted}, nil
+	}
+	if err := s.spill(); err != nil {
+		return nil, err
+	}
+	return s.merge()
+}
+
+// Close releases the pending records and removes the run files
+func (s *Sorter) Close() {
+	for _, record := range s.pending {
+		record.Release()
+	}
+	s.pending, s.pendingRows = nil, 0
+	for _, name := range s.runFiles {
+		os.Remove(name)
+	}
+	s.runFiles = nil
+}
+
+// sortPending concatenates the pending records and returns them as one sorted record, nil without rows
+func (s *Sorter) sortPending() (arrow.Record, error) {
+	defer func() {
+		for _, record := range s.pending {
+			record.Release()
+		}
+		s.pending, s.pendingRows = nil, 0
+	}()
+	if s.pendingRows == 0 {
+		return nil, nil
+	}
+	record, err := concatenateRecords(s.schema, s.pending, s.options.Allocator)
+	if err != nil {
+		return nil, err
+	}
+	defer record.Release()
+	indices := make([]int64, record.NumRows())
+	for i := range indices {
+		indices[i] = int64(i)
+	}
+	var compareErr error
+	sort.SliceStable(indices, func(i, j int) bool {
+		c, err := s.compare(record, int(indices[i]), record, int(indices[j]))
+		if err != nil && compareErr == nil {
+			compareErr = err
+		}
+		return c < 0
+	})
+	if compareErr != nil {
+		return nil, compareErr
+	}
+	indexBuilder := array.NewInt64Builder(s.options.Allocator)
+	defer indexBuilder.Release()
+	indexBuilder.AppendValues(indices, nil)
+	indexArray := indexBuilder.NewArray()
+	defer indexArray.Release()
+	columns := make([]arrow.Array, record.NumCols())
+	defer releaseArrays(columns)
+	for i, col := range record.Columns() {
+		taken, err := compute.TakeArray(compute.WithAllocator(s.ctx, s.options.Allocator), col, indexArray)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sort column %s: %w", record.ColumnName(i), err)
+		}
+		columns[i] = taken
+	}
+	return array.NewRecord(s.schema, columns, record.NumRows()), nil
+}
+
+// spill sorts the pending records and writes them in a new run file
+func (s *Sorter) spill() error {
+	sorted, err := s.sortPending()
+	if err != nil || sorted == nil {
+		return err
+	}
+	defer sorted.Release()
+	f, err := os.CreateTemp(s.options.TempDir, "sort-run-*.arrows")
+	if err != nil {
+		return fmt.Errorf("failed to create sort run file: %w", err)
+	}
+	s.runFiles = append(s.runFiles, f.Name())
+	defer f.Close()
+	writer := ipc.NewWriter(f, ipc.WithSchema(s.schema), ipc.WithAllocator(s.options.Allocator))
+	for offset := int64(0); offset < sorted.NumRows(); offset += s.options.BatchRows {
+		slice := sorted.NewSlice(offset, min(offset+s.options.BatchRows, sorted.NumRows()))
+		err := writer.Write(slice)
+		slice.Release()
+		if err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to write sort run file %s: %w", f.Name(), err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write sort run file %s: %w", f.Name(), err)
+	}
+	return f.Close()
+}
+
+// merge opens the run files and returns an Iterator merging their rows
+func (s *Sorter) merge() (*Iterator, error) {
+	it := &Iterator{sorter: s, heap: &runHeap{runs: make(map[*runCursor]int, len(s.runFiles)), compare: s.compare}}
+	for i, name := range s.runFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			it.Close()
+			return nil, fmt.Errorf("failed to open sort run file: %w", err)
+		}
+		it.files = append(it.files, f)
+		reader, err := ipc.NewReader(f, ipc.WithAllocator(s.options.Allocator))
+		if err != nil {
+			it.Close()
+			return nil, fmt.Errorf("failed to read sort run file %s: %w", name, err)
+		}
+		c := &runCursor{reader: reader, row: -1}
+		it.cursors = append(it.cursors, c)
+		it.heap.runs[c] = i
+		if err := c.advance(); err != nil {
+			it.Close()
+			return nil, err
+		}
+		if c.record != nil {
+			it.heap.cursors = append(it.heap.cursors, c)
+		}
+	}
+	heap.Init(it.heap)
+	if it.heap.err != nil {
+		it.Close()
+		return nil, it.heap.err
+	}
+	return it, nil
+}
+
+// Iterator returns the sorted rows in records of at most BatchRows rows
+type Iterator struct {
+	sorter *Sorter
+	// sorted holds the rows of a single run, the runs spilled to files are merged with the heap
+	sorted  arrow.Record
+	offset  int64
+	heap    *runHeap
+	files   []*os.File
+	cursors []*runCursor
+	current arrow.Record
+}
+
+// Next returns the next sorted record, or io.EOF after the last one. The record is released
+// by the next call to Next or by Close, it must be retained to be kept longer.
+func (it *Iterator) Next() (arrow.Record, error) {
+	it.releaseCurrent()
+	batchRows := it.sorter.options.BatchRows
+	if it.heap == nil {
+		if it.sorted == nil || it.offset >= it.sorted.NumRows() {
+			return nil, io.EOF
+		}
+		it.current = it.sorted.NewSlice(it.offset, min(it.offset+batchRows, it.sorted.NumRows()))
+		it.offset += it.current.NumRows()
+		return it.current, nil
+	}
+	if it.heap.Len() == 0 {
+		return nil, io.EOF
+	}
+
+	// the consecutive rows taken from the same record of a run are kept as one slice,
+	// the slices are concatenated in a record of batchRows rows
+	var slices []arrow.Record
+	var numRows int64
+	defer func() {
+		for _, slice := range slices {
+			slice.Release()
+		}
+	}()
+	h := it.heap
+	for h.Len() > 0 && numRows < batchRows {
+		c := h.cursors[0]
+		record, start := c.record, c.row
+		// the reader releases the record when the cursor moves to its next record
+		record.Retain()
+		// take the rows of this record as long as they come before the current rows of the other runs
+		for {
+			if err := c.advance(); err != nil {
+				record.Release()
+				return nil, err
+			}
+			if c.record != record || numRows+int64(c.row-start) >= batchRows {
+				break
+			}
+			if h.Len() > 1 && !h.Less(0, smallestChild(h)) {
+				break
+			}
+		}
+		end := c.row
+		if c.record != record {
+			end = int(record.NumRows())
+		}
+		slices = append(slices, record.NewSlice(int64(start), int64(end)))
+		record.Release()
+		numRows += int64(end - start)
+		if c.record == nil {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+		if h.err != nil {
+			return nil, h.err
+		}
+	}
+	record, err := concatenateRecords(it.sorter.schema, slices, it.sorter.options.Allocator)
+	if err != nil {
+		return nil, err
+	}
+	it.current = record
+	return record, nil
+}
+
+// Close releases the records and closes the run files, they are removed by Sorter.Close
+func (it *Iterator) Close() {
+	it.releaseCurrent()
+	if it.sorted != nil {
+		it.sorted.Release()
+		it.sorted = nil
+	}
+	for _, c := range it.cursors {
+		c.reader.Release()
+	}
+	it.cursors = nil
+	for _, f := range it.files {
+		f.Close()
+	}
+	it.files = nil
+}
+
+func (it *Iterator) releaseCurrent() {
+	if it.current != nil {
+		it.current.Release()
+		it.current = nil
+	}
+}
+
+// runCursor is the current row of a run file
+type runCursor struct {
+	reader *ipc.Reader
+	record arrow.Record
+	row    int
+}
+
+// advance moves to the next row of the run, record is nil at the end of the run
+func (c *runCursor) advance() error {
+	c.row++
+	for c.record == nil || c.row >= int(c.record.NumRows()) {
+		if !c.reader.Next() {
+			c.record = nil
+			if err := c.reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("failed to read sort run file: %w", err)
+			}
+			return nil
+		}
+		// the record of the reader stays valid until the next call to Next
+		c.record, c.row = c.reader.Record(), 0
+	}
+	return nil
+}
+
+// runHeap orders the run cursors by their current row, the first run wins a tie to keep the sort stable,
+// err keeps the first failed comparison
+type runHeap struct {
+	cursors []*runCursor
+	runs    map[*runCursor]int
+	compare CompareFunc
+	err     error
+}
+
+func (h *runHeap) Len() int { return len(h.cursors) }
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	c, err := h.compare(a.record, a.row, b.record, b.row)
+	if err != nil && h.err == nil {
+		h.err = err
+	}
+	if c != 0 {
+		return c < 0
+	}
+	return h.runs[a] < h.runs[b]
+}
+func (h *runHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *runHeap) Push(x any)    { h.cursors = append(h.cursors, x.(*runCursor)) }
+func (h *runHeap) Pop() any {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
+
+// smallestChild returns the index of the smallest child of the root of the heap, it has at least one child
+func smallestChild(h *runHeap) int {
+	if h.Len() > 2 && h.Less(2, 1) {
+		return 2
+	}
+	return 1
+}
+
+// concatenateRecords returns one record with the rows of all the records
+func concatenateRecords(schema *arrow.Schema, records []arrow.Record, mem memory.Allocator) (arrow.Record, error) {
+	var numRows int64
+	columns := make([]arrow.Array, schema.NumFields())
+	defer releaseArrays(columns)
+	for i := range columns {
+		chunks := make([]arrow.Array, len(records))
+		for j, record := range records {
+			chunks[j] = record.Column(i)
+		}
+		col, err := array.Concatenate(chunks, mem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to concatenate column %s: %w", schema.Field(i).Name, err)
+		}
+		columns[i] = col
+	}
+	for _, record := range records {
+		numRows += record.NumRows()
+	}
+	return array.NewRecord(schema, columns, numRows), nil
+}
+
+func releaseArrays(arrays []arrow.Array) {
+	for _, arr := range arrays {
+		if arr != nil {
+			arr.Release()
+		}
+	}
+}
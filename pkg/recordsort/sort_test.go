@@ -0,0 +1,140 @@
+package recordsort
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// compareKeyAndId orders the rows by ascending key with the NULL keys last, then by descending id
+func compareKeyAndId(a arrow.Record, i int, b arrow.Record, j int) (int, error) {
+	x, y := a.Column(0).(*array.Int64), b.Column(0).(*array.Int64)
+	switch {
+	case x.IsNull(i) && !y.IsNull(j):
+		return 1, nil
+	case !x.IsNull(i) && y.IsNull(j):
+		return -1, nil
+	case !x.IsNull(i) && x.Value(i) != y.Value(j):
+		return cmp.Compare(x.Value(i), y.Value(j)), nil
+	}
+	return cmp.Compare(b.Column(1).(*array.Int64).Value(j), a.Column(1).(*array.Int64).Value(i)), nil
+}
+
+func TestSorter(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "key", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	tempDir := t.TempDir()
+	options := Options{RunRows: 100, BatchRows: 64, TempDir: tempDir, Allocator: mem}
+	sorter := New(context.Background(), schema, compareKeyAndId, options)
+	defer sorter.Close()
+
+	// 1000 rows in records of 30 rows, the runs of 100 rows are spilled and merged
+	const numRows = 1000
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	for start := 0; start < numRows; start += 30 {
+		for id := start; id < min(start+30, numRows); id++ {
+			if id%17 == 0 {
+				builder.Field(0).AppendNull()
+			} else {
+				builder.Field(0).(*array.Int64Builder).Append(int64(id * 7919 % 10))
+			}
+			builder.Field(1).(*array.Int64Builder).Append(int64(id))
+		}
+		record := builder.NewRecord()
+		err := sorter.Add(record)
+		record.Release()
+		if err != nil {
+			t.Fatalf("Add() error: %v", err)
+		}
+	}
+	if sorter.Runs() == 0 {
+		t.Fatalf("no run spilled with %d rows and runs of %d rows", numRows, options.RunRows)
+	}
+	it, err := sorter.Sort()
+	if err != nil {
+		t.Fatalf("Sort() error: %v", err)
+	}
+
+	var rows int
+	var previousKey, previousId int64
+	previousNull := false
+	for {
+		record, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		if record.NumRows() > options.BatchRows {
+			t.Errorf("record of %d rows, more than the batch of %d rows", record.NumRows(), options.BatchRows)
+		}
+		keys, ids := record.Column(0).(*array.Int64), record.Column(1).(*array.Int64)
+		for i := 0; i < int(record.NumRows()); i++ {
+			isNull, key, id := keys.IsNull(i), keys.Value(i), ids.Value(i)
+			if rows > 0 {
+				// ascending keys with the NULL keys last, then descending ids
+				switch {
+				case previousNull && !isNull:
+					t.Fatalf("row %d: key %d after a NULL key", rows, key)
+				case !previousNull && !isNull && key < previousKey:
+					t.Fatalf("row %d: key %d after key %d", rows, key, previousKey)
+				case previousNull == isNull && (isNull || key == previousKey) && id >= previousId:
+					t.Fatalf("row %d: id %d after id %d with the same key", rows, id, previousId)
+				}
+			}
+			previousNull, previousKey, previousId = isNull, key, id
+			rows++
+		}
+	}
+	if rows != numRows {
+		t.Errorf("Next() returned %d rows, want %d", rows, numRows)
+	}
+
+	it.Close()
+	sorter.Close()
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("ReadDir() error: %v", err)
+	}
+	for _, entry := range entries {
+		t.Errorf("run file %s left after Close()", entry.Name())
+	}
+}
+
+func TestSorterCompareError(t *testing.T) {
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	schema := arrow.NewSchema([]arrow.Field{{Name: "id", Type: arrow.PrimitiveTypes.Int64}}, nil)
+	builder := array.NewInt64Builder(mem)
+	defer builder.Release()
+	builder.AppendValues([]int64{3, 1, 2}, nil)
+	column := builder.NewArray()
+	defer column.Release()
+	record := array.NewRecord(schema, []arrow.Array{column}, 3)
+	defer record.Release()
+
+	errCompare := errors.New("values cannot be ordered")
+	sorter := New(context.Background(), schema, func(a arrow.Record, i int, b arrow.Record, j int) (int, error) {
+		return 0, errCompare
+	}, Options{TempDir: t.TempDir(), Allocator: mem})
+	defer sorter.Close()
+	if err := sorter.Add(record); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if _, err := sorter.Sort(); !errors.Is(err, errCompare) {
+		t.Errorf("Sort() error = %v, want the error of the comparison", err)
+	}
+}
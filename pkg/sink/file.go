@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fileSink writes in a temporary file of the destination directory renamed to the final path by Commit,
+// so a failed export never leaves a half-written file at the final path
+type fileSink struct {
+	file     *os.File
+	filePath string
+	done     bool
+}
+
+func newFileSink(filePath string) (*fileSink, error) {
+	// the temporary file is hidden and in the same directory to be renamed atomically
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for %s: %w", filePath, err)
+	}
+	return &fileSink{file: file, filePath: filePath}, nil
+}
+
+func (s *fileSink) Write(p []byte) (int, error) {
+	if s.done {
+		return 0, ErrAborted
+	}
+	return s.file.Write(p)
+}
+
+func (s *fileSink) Commit() error {
+	if s.done {
+		return fmt.Errorf("sink %s already closed", s.filePath)
+	}
+	s.done = true
+	tmpPath := s.file.Name()
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := s.file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+	// os.CreateTemp creates the file with mode 0600
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to change mode of %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename %s to %s: %w", tmpPath, s.filePath, err)
+	}
+	return nil
+}
+
+func (s *fileSink) Abort() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.file.Close()
+	if err := os.Remove(s.file.Name()); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", s.file.Name(), err)
+	}
+	return nil
+}
+
+func (s *fileSink) Location() string { return s.filePath }
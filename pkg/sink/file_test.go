@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dirEntries returns the names of the files of the directory, the hidden temporary files included
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir(%s) error: %v", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFileSinkCommit(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "export.parquet")
+	s, err := Open(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer s.Abort()
+	content := []byte("PAR1 content PAR1")
+	if _, err := s.Write(content); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if _, err := os.Stat(filePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("final file exists before Commit, Stat() error: %v", err)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 || names[0][0] != '.' {
+		t.Fatalf("files before Commit: %v, want one hidden temporary file", names)
+	}
+
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	got, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile() after Commit error: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("content after Commit = %q, want %q", got, content)
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Stat() after Commit error: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("mode after Commit = %o, want 644", mode)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 || names[0] != "export.parquet" {
+		t.Errorf("files after Commit: %v, want only export.parquet", names)
+	}
+	if err := s.Commit(); err == nil {
+		t.Errorf("second Commit() succeeded")
+	}
+}
+
+func TestFileSinkAbort(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "export.parquet")
+	s, err := Open(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if _, err := s.Write([]byte("partial rows")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := s.Abort(); err != nil {
+		t.Fatalf("Abort() error: %v", err)
+	}
+	if names := dirEntries(t, dir); len(names) != 0 {
+		t.Errorf("files after Abort: %v, want none", names)
+	}
+	if _, err := s.Write([]byte("more rows")); !errors.Is(err, ErrAborted) {
+		t.Errorf("Write() after Abort error = %v, want ErrAborted", err)
+	}
+	if err := s.Commit(); err == nil {
+		t.Errorf("Commit() after Abort succeeded")
+	}
+	if err := s.Abort(); err != nil {
+		t.Errorf("second Abort() error: %v", err)
+	}
+}
+
+func TestFileSinkAbortKeepsExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "export.parquet")
+	previous := []byte("previous export")
+	if err := os.WriteFile(filePath, previous, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(context.Background(), filePath)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if _, err := s.Write([]byte("new rows")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := s.Abort(); err != nil {
+		t.Fatalf("Abort() error: %v", err)
+	}
+	got, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile() after Abort error: %v", err)
+	}
+	if !bytes.Equal(got, previous) {
+		t.Errorf("content after Abort = %q, want the previous export %q", got, previous)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("files after Abort: %v, want only the previous export", names)
+	}
+}
@@ -0,0 +1,183 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// s3PartSize is the size of the parts of the multipart uploads, each upload buffers one part in memory
+	s3PartSize = 16 * 1024 * 1024
+	// defaultS3Endpoint is used when S3_ENDPOINT is not set
+	defaultS3Endpoint = "s3.amazonaws.com"
+)
+
+// S3Config defines the connection to an S3-compatible object storage like MinIO
+type S3Config struct {
+	Endpoint        string
+	AccessKeyId     string
+	SecretAccessKey string
+	Region          string
+	UseSSL          bool
+}
+
+// GetS3ConfigFromEnv returns the S3Config defined by the environment variables S3_ENDPOINT (host:port),
+// S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY, S3_REGION and S3_USE_SSL (default true)
+func GetS3ConfigFromEnv() (S3Config, error) {
+	config := S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		AccessKeyId:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Region:          os.Getenv("S3_REGION"),
+		UseSSL:          true,
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = defaultS3Endpoint
+	}
+	if useSSL := os.Getenv("S3_USE_SSL"); useSSL != "" {
+		value, err := strconv.ParseBool(useSSL)
+		if err != nil {
+			return config, fmt.Errorf("invalid S3_USE_SSL value %s: %w", useSSL, err)
+		}
+		config.UseSSL = value
+	}
+	return config, nil
+}
+
+// NewS3Client returns a client of the S3-compatible object storage
+func NewS3Client(config S3Config) (*minio.Client, error) {
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKeyId, config.SecretAccessKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client for %s: %w", config.Endpoint, err)
+	}
+	return client, nil
+}
+
+// s3Sink streams the written data to a multipart upload. The object only exists in the bucket when
+// the upload is completed by Commit, Abort cancels the upload and its parts are removed.
+type s3Sink struct {
+	location string
+	writer   *io.PipeWriter
+	cancel   context.CancelFunc
+	result   chan error
+	done     bool
+}
+
+func newS3Sink(ctx context.Context, location string, bucket string, key string) (*s3Sink, error) {
+	config, err := GetS3ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+	// the upload does not stop with the context of the caller, Abort must still be able to remove the parts
+	// of the multipart upload after a SIGINT. It is canceled by Commit and Abort once the upload returned.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	reader, writer := io.Pipe()
+	s := &s3Sink{location: location, writer: writer, cancel: cancel, result: make(chan error, 1)}
+	go func() {
+		// with an unknown size the data is read and uploaded part by part
+		_, err := client.PutObject(ctx, bucket, key, reader, -1, minio.PutObjectOptions{
+			PartSize:    s3PartSize,
+			ContentType: "application/octet-stream",
+		})
+		// unblocks the writer when the upload fails before reading everything
+		reader.CloseWithError(err)
+		s.result <- err
+	}()
+	return s, nil
+}
+
+func (s *s3Sink) Write(p []byte) (int, error) {
+	if s.done {
+		return 0, ErrAborted
+	}
+	return s.writer.Write(p)
+}
+
+func (s *s3Sink) Commit() error {
+	if s.done {
+		return fmt.Errorf("sink %s already closed", s.location)
+	}
+	s.done = true
+	defer s.cancel()
+	s.writer.Close()
+	if err := <-s.result; err != nil {
+		return fmt.Errorf("failed to upload %s: %w", s.location, err)
+	}
+	return nil
+}
+
+func (s *s3Sink) Abort() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	// the upload reads the error and minio-go aborts the multipart upload with its context, still valid here
+	s.writer.CloseWithError(ErrAborted)
+	<-s.result
+	s.cancel()
+	return nil
+}
+
+func (s *s3Sink) Location() string { return s.location }
+
+// openS3Reader returns the object of the bucket, its content is fetched by ranges when it is read
+func openS3Reader(ctx context.Context, location string, bucket string, key string) (*minio.Object, error) {
+	config, err := GetS3ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewS3Client(config)
+	if err != nil {
+		return nil, err
+	}
+	object, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", location, err)
+	}
+	// GetObject does not send any request, Stat reports a missing object now rather than at the first read
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", location, err)
+	}
+	return object, nil
+}
+
+// removeS3Object deletes the object of the bucket, S3 answers a success for a missing object
+func removeS3Object(ctx context.Context, location string, bucket string, key string) error {
+	config, err := GetS3ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	client, err := NewS3Client(config)
+	if err != nil {
+		return err
+	}
+	if err := client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", location, err)
+	}
+	return nil
+}
+
+// splitS3Location returns the bucket and the key of an s3://bucket/key location
+func splitS3Location(location string) (string, string, error) {
+	bucket, key, found := strings.Cut(strings.TrimPrefix(location, S3Scheme), "/")
+	if !found || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 location %s, expected s3://bucket/key", location)
+	}
+	return bucket, key, nil
+}
@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// newTestS3Location returns a new key of the bucket S3_TEST_BUCKET, the tests run against the server
+// started by make run-minio with S3_ENDPOINT=localhost:9000 and S3_USE_SSL=false
+func newTestS3Location(t *testing.T, name string) (*minio.Client, string, string) {
+	t.Helper()
+	bucket := os.Getenv("S3_TEST_BUCKET")
+	if bucket == "" {
+		t.Skip("S3_TEST_BUCKET is not set, start make run-minio to run the S3 tests")
+	}
+	config, err := GetS3ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("GetS3ConfigFromEnv() error: %v", err)
+	}
+	client, err := NewS3Client(config)
+	if err != nil {
+		t.Fatalf("NewS3Client() error: %v", err)
+	}
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		t.Fatalf("BucketExists(%s) error: %v", bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: config.Region}); err != nil {
+			t.Fatalf("MakeBucket(%s) error: %v", bucket, err)
+		}
+	}
+	key := fmt.Sprintf("sink-test/%s-%d.bin", name, time.Now().UnixNano())
+	t.Cleanup(func() {
+		client.RemoveObject(context.Background(), bucket, key, minio.RemoveObjectOptions{})
+	})
+	return client, bucket, key
+}
+
+func TestS3SinkCommit(t *testing.T) {
+	client, bucket, key := newTestS3Location(t, "commit")
+	s, err := Open(context.Background(), S3Scheme+bucket+"/"+key)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer s.Abort()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	if _, err := s.Write(content); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if _, err := client.StatObject(context.Background(), bucket, key, minio.StatObjectOptions{}); err == nil {
+		t.Fatalf("object %s exists before Commit", key)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	info, err := client.StatObject(context.Background(), bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatalf("StatObject() after Commit error: %v", err)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("object size = %d, want %d", info.Size, len(content))
+	}
+}
+
+func TestS3SinkAbortAfterCancel(t *testing.T) {
+	client, bucket, key := newTestS3Location(t, "abort")
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := Open(ctx, S3Scheme+bucket+"/"+key)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	// more than one part, the multipart upload is started and its first part sent
+	part := bytes.Repeat([]byte{1}, 1024*1024)
+	for i := 0; i < s3PartSize/len(part)+2; i++ {
+		if _, err := s.Write(part); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	// like a SIGINT during the export, the context of the caller is canceled before Abort
+	cancel()
+	if err := s.Abort(); err != nil {
+		t.Fatalf("Abort() error: %v", err)
+	}
+	if _, err := client.StatObject(context.Background(), bucket, key, minio.StatObjectOptions{}); err == nil {
+		t.Errorf("object %s exists after Abort", key)
+	}
+	for upload := range client.ListIncompleteUploads(context.Background(), bucket, key, true) {
+		if upload.Err != nil {
+			t.Fatalf("ListIncompleteUploads() error: %v", upload.Err)
+		}
+		t.Errorf("multipart upload %s of %s left after Abort", upload.UploadID, upload.Key)
+	}
+}
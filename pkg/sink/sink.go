@@ -0,0 +1,142 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// StdoutLocation is the location used to write to the standard output
+	StdoutLocation = "-"
+	// S3Scheme prefixes the locations of objects in an S3-compatible bucket: s3://bucket/key
+	S3Scheme = "s3://"
+)
+
+// ErrAborted is returned by the writes done after the sink was aborted
+var ErrAborted = errors.New("sink aborted")
+
+// Sink is the destination of an export. The data written only appears at its final location
+// when Commit succeeds, Abort discards it. Abort does nothing after a successful Commit,
+// so it can be deferred right after Open like a transaction rollback.
+type Sink interface {
+	io.Writer
+	// Commit makes the written data visible at the final location
+	Commit() error
+	// Abort discards the written data, nothing is left at the final location
+	Abort() error
+	// Location returns the final location of the data
+	Location() string
+}
+
+// Open returns the Sink for the location: "-" for the standard output, s3://bucket/key for an object
+// in an S3-compatible bucket configured with the S3_* environment variables, or a local file path.
+func Open(ctx context.Context, location string) (Sink, error) {
+	switch {
+	case location == StdoutLocation:
+		return &stdoutSink{}, nil
+	case IsS3(location):
+		bucket, key, err := splitS3Location(location)
+		if err != nil {
+			return nil, err
+		}
+		return newS3Sink(ctx, location, bucket, key)
+	default:
+		return newFileSink(location)
+	}
+}
+
+// Reader reads the content of a location with random access, as needed by the parquet and Arrow IPC file readers
+type Reader interface {
+	io.ReaderAt
+	io.ReadSeeker
+	io.Closer
+}
+
+// OpenReader opens a local file or an s3://bucket/key object for reading
+func OpenReader(ctx context.Context, location string) (Reader, error) {
+	switch {
+	case location == StdoutLocation:
+		return nil, fmt.Errorf("the standard output cannot be read")
+	case IsS3(location):
+		bucket, key, err := splitS3Location(location)
+		if err != nil {
+			return nil, err
+		}
+		return openS3Reader(ctx, location, bucket, key)
+	default:
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", location, err)
+		}
+		return file, nil
+	}
+}
+
+// IsS3 returns true when the location is an object in an S3-compatible bucket
+func IsS3(location string) bool {
+	return strings.HasPrefix(location, S3Scheme)
+}
+
+// Join joins the elements to the location, with slashes for the S3 keys and the os separator for the local paths
+func Join(location string, elem ...string) string {
+	if IsS3(location) {
+		return S3Scheme + path.Join(append([]string{strings.TrimPrefix(location, S3Scheme)}, elem...)...)
+	}
+	return filepath.Join(append([]string{location}, elem...)...)
+}
+
+// MkdirAll creates the local directory and its parents, there is nothing to create in an S3 bucket
+func MkdirAll(location string) error {
+	if IsS3(location) {
+		return nil
+	}
+	if err := os.MkdirAll(location, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", location, err)
+	}
+	return nil
+}
+
+// Remove deletes the local file or the S3 object at the location, a missing one is not an error
+func Remove(ctx context.Context, location string) error {
+	if IsS3(location) {
+		bucket, key, err := splitS3Location(location)
+		if err != nil {
+			return err
+		}
+		return removeS3Object(ctx, location, bucket, key)
+	}
+	if err := os.Remove(location); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", location, err)
+	}
+	return nil
+}
+
+// WriteFile writes the content at the location through a Sink, the location is unchanged when it fails
+func WriteFile(ctx context.Context, location string, content []byte) error {
+	out, err := Open(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	if _, err := out.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", location, err)
+	}
+	return out.Commit()
+}
+
+// stdoutSink writes to the standard output, the data is already visible when it is written
+type stdoutSink struct{}
+
+func (s *stdoutSink) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+func (s *stdoutSink) Commit() error { return nil }
+
+func (s *stdoutSink) Abort() error { return nil }
+
+func (s *stdoutSink) Location() string { return StdoutLocation }